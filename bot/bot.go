@@ -6,22 +6,25 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
-	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
 	gethcmn "github.com/ethereum/go-ethereum/common"
 	gethtypes "github.com/ethereum/go-ethereum/core/types"
 	gethcrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/gcash/bchd/bchec"
 	"github.com/gcash/bchd/btcjson"
 	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/wire"
 	"github.com/gcash/bchutil"
 	log "github.com/sirupsen/logrus"
 	"github.com/smartbch/atomic-swap-bot/htlcbch"
 	"github.com/smartbch/atomic-swap-bot/htlcsbch"
+	"gorm.io/gorm"
 )
 
 /*
@@ -142,6 +145,7 @@ M: master, S: slave
 | handleBchUserDeposits   |✓| | New            | SbchLocked     |
 | handleSbchLockEventB2S  | |✓| New            | SbchLocked     |
 | refundLockedSbch        |✓|✓| SbchLocked     | SbchRefunded   |
+| handleSbchRefundEventB2S|✓|✓| SbchLocked     | SbchRefunded   |
 +-------------------------+-+-+----------------+----------------+
 +-------------------------+-+-+----------------+----------------+
 | BCH2SBCH: too late      |M|S| old status     | new status     |
@@ -149,6 +153,20 @@ M: master, S: slave
 | handleBchDepositTxB2S   |✓|✓|                | New            |
 | handleBchUserDeposits   |✓| | New            | TooLate        |
 +-------------------------+-+-+----------------+----------------+
++-------------------------+-+-+----------------+----------------+
+| BCH2SBCH: user refund   |M|S| old status     | new status     |
++-------------------------+-+-+----------------+----------------+
+| handleBchDepositTxB2S   |✓|✓|                | New            |
+| handleBchUserDeposits   |✓| | New            | TooLate        |
+| handleBchRefundTxB2S    |✓|✓| TooLate        | RefundedByUser |
++-------------------------+-+-+----------------+----------------+
++-------------------------+-+-+----------------+----------------+
+| BCH2SBCH: no liquidity  |M|S| old status     | new status     |
++-------------------------+-+-+----------------+----------------+
+| handleBchDepositTxB2S   |✓|✓|                | New            |
+| handleBchUserDeposits   |✓| | New            | InsufficientL. |
+| handleBchRefundTxB2S    |✓|✓| InsufficientL. | RefundedByUser |
++-------------------------+-+-+----------------+----------------+
 
 +-------------------------+-+-+----------------+----------------+
 | SBCH2BCH: normal        |M|S| old status     | new status     |
@@ -157,6 +175,7 @@ M: master, S: slave
 | handleSbchUserDeposits  |✓| | New            | BchLocked      |
 | handleBchDepositTxS2B   | |✓| New            | BchLocked      |
 | handleBchReceiptTx      |✓|✓| BchLocked      | SecretRevealed |
+| scanBchMempoolTxs       |✓|✓| BchLocked      | SecretRevealed |
 | unlockSbchUserDeposits  |✓|✓| SecretRevealed | SbchUnlocked   |
 +-------------------------+-+-+----------------+----------------+
 +-------------------------+-+-+----------------+----------------+
@@ -166,6 +185,7 @@ M: master, S: slave
 | handleSbchUserDeposits  |✓| | New            | BchLocked      |
 | handleBchDepositTxS2B   | |✓| New            | BchLocked      |
 | refundLockedBCH         |✓|✓| BchLocked      | BchRefunded    |
+| handleBchRefundTxS2B    |✓|✓| BchLocked      | BchRefunded    |
 +-------------------------+-+-+----------------+----------------+
 +-------------------------+-+-+----------------+----------------+
 | SBCH2BCH: too late      |M|S| old status     | new status     |
@@ -173,6 +193,20 @@ M: master, S: slave
 | handleSbchLockEventS2B  |✓|✓|                | New            |
 | handleSbchUserDeposits  |✓| | New            | TooLate        |
 +-------------------------+-+-+----------------+----------------+
++-------------------------+-+-+----------------+----------------+
+| SBCH2BCH: user refund   |M|S| old status     | new status     |
++-------------------------+-+-+----------------+----------------+
+| handleSbchLockEventS2B  |✓|✓|                | New            |
+| handleSbchUserDeposits  |✓| | New            | TooLate        |
+| handleSbchRefundEventS2B|✓|✓| TooLate        | RefundedByUser |
++-------------------------+-+-+----------------+----------------+
++-------------------------+-+-+----------------+----------------+
+| SBCH2BCH: no liquidity  |M|S| old status     | new status     |
++-------------------------+-+-+----------------+----------------+
+| handleSbchLockEventS2B  |✓|✓|                | New            |
+| handleSbchUserDeposits  |✓| | New            | InsufficientL. |
+| handleSbchRefundEventS2B|✓|✓| InsufficientL. | RefundedByUser |
++-------------------------+-+-+----------------+----------------+
 
 */
 
@@ -180,14 +214,27 @@ const (
 	slaveDelayBchBlocks = 1
 	slaveDelaySeconds   = 600 // 10m
 	priceUpdateInterval = 120 // 2m
+	maxBchReorgDepth    = 100 // in blocks
+	bchBlocksToKeep     = 1000
+	sbchBlockBatch      = 200
+	maxSbchReorgDepth   = 20 // in block ranges
+	sbchBlocksToKeep    = 100000
 )
 
 type MarketMakerBot struct {
-	db          DB            // thread safe
-	bchCli      IBchClient    // thread safe
-	sbchCli     ISbchClient   // not thread safe
-	sbchCliRO   *SbchClientRO // not thread safe
-	errLogQueue *ErrLogQueue  // thread safe
+	db            DB                // thread safe
+	bchCli        IBchClient        // thread safe
+	sbchCli       ISbchClient       // not thread safe
+	sbchCliRO     ISbchClientRO     // not thread safe
+	errLogQueue   *ErrLogQueue      // thread safe
+	metrics       *botMetrics       // thread safe, nil-safe
+	swapEvents    *swapEventHub     // thread safe
+	priceFeed     *PriceFeed        // nil if on-chain prices are updated manually
+	consolidator  *UtxoConsolidator // nil if UTXOs are not consolidated automatically
+	feeEstimator  *FeeEstimator     // nil if static BCH fee rates are used
+	cpfpBumper    *CpfpBumper       // nil if stuck BCH lock txs are not bumped
+	sbchGasOracle *SbchGasOracle    // nil if the fixed sBCH gas price is used
+	liquidity     liquidityBooks    // thread safe
 
 	// BCH key
 	bchPrivKey *bchec.PrivateKey
@@ -209,15 +256,22 @@ type MarketMakerBot struct {
 	minSwapVal            uint64 // in sats
 	maxSwapVal            uint64 // in sats
 	bchConfirmations      uint8
-	bchLockMinerFeeRate   uint64 // sats/byte
-	bchUnlockMinerFeeRate uint64 // sats/byte
-	bchRefundMinerFeeRate uint64 // sats/byte
+	sbchConfirmations     uint8
+	bchLockMinerFeeRate   uint64 // sats/byte, fallback of feeEstimator
+	bchUnlockMinerFeeRate uint64 // sats/byte, fallback of feeEstimator
+	bchRefundMinerFeeRate uint64 // sats/byte, fallback of feeEstimator
 	dbQueryLimit          int
+	scanBchMempool        bool
+	zeroConfMaxSwapVal    uint64 // in sats, 0 means zero-conf deposits are not accepted
+	zeroConfMaxTotalVal   uint64 // in sats
 	isSlaveMode           bool
 	lazyMaster            bool // debug only
 
 	// internal state
 	lastPricesUpdatedAt int64
+	bchMempoolTxs       map[string]struct{}             // txs in BCH mempool which are already parsed
+	droppedBchLockTxs   map[string]struct{}             // zero-conf lock txs which are dropped after sBCH is locked
+	pendingSbchTxs      map[gethcmn.Hash]*pendingSbchTx // sBCH txs which are sent but not mined
 }
 
 func NewBot(
@@ -227,14 +281,26 @@ func NewBot(
 	bchRpcUrl, sbchRpcUrl string,
 	sbchHtlcAddr gethcmn.Address,
 	sbchGasPrice *big.Int,
-	bchConfirmations uint8,
+	bchConfirmations, sbchConfirmations uint8,
 	bchLockMinerFeeRate, bchUnlockMinerFeeRate, bchRefundMinerFeeRate uint64,
 	dbQueryLimit int,
+	scanBchMempool bool,
+	zeroConfMaxSwapVal, zeroConfMaxTotalVal uint64, // in sats
+	priceFeed *PriceFeed, // optional
+	consolidator *UtxoConsolidator, // optional
+	feeEstimator *FeeEstimator, // optional
+	cpfpBumper *CpfpBumper, // optional
+	sbchGasOracle *SbchGasOracle, // optional
 	debugMode bool,
 	slaveMode bool,
 	lazyMaster bool, // debug only
 ) (*MarketMakerBot, error) {
 
+	if zeroConfMaxTotalVal < zeroConfMaxSwapVal {
+		return nil, fmt.Errorf("zero-conf total limit is less than per-swap limit: %d < %d",
+			zeroConfMaxTotalVal, zeroConfMaxSwapVal)
+	}
+
 	// load BCH key
 	bchPrivKey, bchPbk, bchPkh, bchAddr, err := loadBchKey(
 		bchPrivKeyWIF, bchMasterAddr, debugMode, slaveMode)
@@ -285,8 +351,13 @@ func NewBot(
 	log.Info("BCH address : ", bchAddr.String())
 	log.Info("sBCH address: ", sbchAddr.String())
 
-	return &MarketMakerBot{
-		db:                    db,
+	actor := ActorMaster
+	if slaveMode {
+		actor = ActorSlave
+	}
+
+	bot := &MarketMakerBot{
+		db:                    db.withActor(actor),
 		bchCli:                bchCli,
 		bchPrivKey:            bchPrivKey,
 		bchPkh:                bchPkh,
@@ -306,11 +377,24 @@ func NewBot(
 		bchUnlockMinerFeeRate: bchUnlockMinerFeeRate,
 		bchRefundMinerFeeRate: bchRefundMinerFeeRate,
 		bchConfirmations:      bchConfirmations,
+		sbchConfirmations:     sbchConfirmations,
 		dbQueryLimit:          dbQueryLimit,
+		scanBchMempool:        scanBchMempool,
+		zeroConfMaxSwapVal:    zeroConfMaxSwapVal,
+		zeroConfMaxTotalVal:   zeroConfMaxTotalVal,
 		isSlaveMode:           slaveMode,
 		lazyMaster:            debugMode && lazyMaster,
 		errLogQueue:           newErrLogQueue(5000),
-	}, nil
+		priceFeed:             priceFeed,
+		consolidator:          consolidator,
+		feeEstimator:          feeEstimator,
+		cpfpBumper:            cpfpBumper,
+		sbchGasOracle:         sbchGasOracle,
+		metrics:               newBotMetrics(),
+		swapEvents:            newSwapEventHub(),
+	}
+	bot.metrics.registry.MustRegister(newBotCollector(bot))
+	return bot, nil
 }
 
 func loadBchKey(privKeyWIF, masterAddr string, debugMode, slaveMode bool,
@@ -399,6 +483,7 @@ func getBchParams(debugMode bool) *chaincfg.Params {
 
 func (bot *MarketMakerBot) logError(msg string, err error) {
 	log.Error(msg, err)
+	bot.metrics.incErrors(msg)
 	bot.errLogQueue.recordErrLog("error", fmt.Sprintf("%s: %s", msg, err))
 }
 func (bot *MarketMakerBot) logWarnf(format string, args ...any) {
@@ -407,18 +492,15 @@ func (bot *MarketMakerBot) logWarnf(format string, args ...any) {
 }
 
 func (bot *MarketMakerBot) PrepareDB() {
-	_, err := bot.db.getLastHeights()
-	if err == nil || !strings.HasPrefix(err.Error(), "no such table") {
-		return
+	applied, err := bot.db.Migrate()
+	for _, m := range applied {
+		log.Infof("DB migration applied: %d (%s)", m.Version, m.Name)
 	}
-
-	log.Info("init DB, sync schemas ...")
-	if err = bot.db.syncSchemas(); err != nil {
-		log.Fatal(err)
+	if err != nil {
+		log.Fatal("failed to migrate DB: ", err)
 	}
-	log.Info("init last BCH & sBCH heights ...")
-	if err = bot.db.initLastHeights(0, 0); err != nil {
-		log.Fatal(err)
+	if err = bot.db.initScanState(); err != nil {
+		log.Fatal("failed to init scan state: ", err)
 	}
 }
 
@@ -427,21 +509,42 @@ func (bot *MarketMakerBot) GetUTXOs() ([]btcjson.ListUnspentResult, error) {
 }
 
 func (bot *MarketMakerBot) Loop() {
+	for !bot.recoverPendingTxs() {
+		time.Sleep(2 * time.Second)
+	}
 	for {
 		log.Info("---------- ", time.Now(), "' ----------")
-		bot.updatePrices()
-		bot.refundLockedSbch()
-		gotNewBlocks := bot.scanBchBlocks()
-		bot.refundLockedBCH(gotNewBlocks)
-		bot.handleBchUserDeposits()
-		bot.unlockBchUserDeposits()
-		bot.scanSbchEvents()
-		bot.handleSbchUserDeposits()
-		bot.unlockSbchUserDeposits()
+		if bot.sbchGasOracle != nil {
+			bot.runStage("refreshSbchGasPrice", bot.refreshSbchGasPrice)
+		}
+		bot.runStage("checkPendingSbchTxs", bot.checkPendingSbchTxs)
+		bot.runStage("updatePrices", bot.updatePrices)
+		bot.runStage("refundLockedSbch", bot.refundLockedSbch)
+		gotNewBlocks := false
+		bot.runStage("scanBchBlocks", func() { gotNewBlocks = bot.scanBchBlocks() })
+		bot.runStage("scanBchMempool", bot.scanBchMempoolTxs)
+		bot.runStage("refundLockedBCH", func() { bot.refundLockedBCH(gotNewBlocks) })
+		bot.runStage("handleBchUserDeposits", bot.handleBchUserDeposits)
+		bot.runStage("unlockBchUserDeposits", bot.unlockBchUserDeposits)
+		bot.runStage("scanSbchEvents", bot.scanSbchEvents)
+		bot.runStage("handleSbchUserDeposits", bot.handleSbchUserDeposits)
+		bot.runStage("releaseUtxoReservations", bot.releaseUtxoReservations)
+		if bot.cpfpBumper != nil && !bot.isSlaveMode {
+			bot.runStage("bumpStuckBchLockTxs", bot.bumpStuckBchLockTxs)
+		}
+		if bot.consolidator != nil && !bot.isSlaveMode {
+			bot.runStage("consolidateUtxos", bot.consolidateUtxos)
+		}
+		bot.runStage("unlockSbchUserDeposits", bot.unlockSbchUserDeposits)
 		time.Sleep(2 * time.Second)
 	}
 }
 
+func (bot *MarketMakerBot) runStage(name string, stage func()) {
+	defer bot.metrics.observeStage(name, time.Now())
+	stage()
+}
+
 func (bot *MarketMakerBot) updatePrices() {
 	now := time.Now().Unix()
 	if now-bot.lastPricesUpdatedAt < priceUpdateInterval {
@@ -460,6 +563,10 @@ func (bot *MarketMakerBot) updatePrices() {
 	bot.bchPrice = weiToSats(botInfo.BchPrice)
 	bot.sbchPrice = weiToSats(botInfo.SbchPrice)
 	log.Info("new BCH price: ", bot.bchPrice, " , new sBCH price: ", bot.sbchPrice)
+
+	if bot.priceFeed != nil && !bot.isSlaveMode {
+		bot.updatePricesFromFeed(botInfo.Intro)
+	}
 }
 
 // scan & handle BCH blocks
@@ -478,6 +585,7 @@ func (bot *MarketMakerBot) scanBchBlocks() (gotNewBlocks bool) {
 		return
 	}
 	log.Info("latest BCH height: ", latestBlockNum)
+	bot.metrics.setChainTipHeight(chainBch, uint64(latestBlockNum))
 
 	safeNewBlockNum := latestBlockNum - int64(bot.bchConfirmations)
 	if bot.bchConfirmations > 0 {
@@ -509,8 +617,24 @@ func (bot *MarketMakerBot) handleBchBlock(h int64) bool {
 	}
 	log.Info("got BCH block#", h)
 
+	if !bot.checkBchParentHash(uint64(h), block) {
+		return false
+	}
+
 	bot.handleBchDepositTxs(uint64(h), block)
-	bot.handleBchReceiptTxs(block)
+	bot.handleBchReceiptTxs(uint64(h), block)
+	bot.handleBchRefundTxs(uint64(h), block)
+
+	err = bot.db.addBchBlock(uint64(h), block.Hash, block.PreviousHash)
+	if err != nil {
+		log.Fatal("DB error, failed to save BCH block hash: ", err)
+	}
+	if h > bchBlocksToKeep {
+		err = bot.db.deleteBchBlocksBelow(uint64(h) - bchBlocksToKeep)
+		if err != nil {
+			bot.logError("DB error, failed to delete old BCH block hashes: ", err)
+		}
+	}
 
 	err = bot.db.setLastBchHeight(uint64(h))
 	if err != nil {
@@ -520,6 +644,118 @@ func (bot *MarketMakerBot) handleBchBlock(h int64) bool {
 	return true
 }
 
+// compare the parent hash of block#h with the saved hash of block#(h-1),
+// roll back to the fork point if they do not match
+func (bot *MarketMakerBot) checkBchParentHash(h uint64, block *btcjson.GetBlockVerboseTxResult) bool {
+	parent, err := bot.db.getBchBlock(h - 1)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Fatal("DB error, failed to get BCH block hash: ", err)
+		}
+		return true // parent not scanned by this bot
+	}
+	if parent.Hash == block.PreviousHash {
+		return true
+	}
+
+	bot.logWarnf("BCH reorg detected at block#%d, parent hash: %s, saved hash: %s",
+		h, block.PreviousHash, parent.Hash)
+	bot.handleBchReorg(h - 1)
+	return false
+}
+
+// walk back from block#h to the fork point, then revert the records touched by orphaned blocks
+func (bot *MarketMakerBot) handleBchReorg(h uint64) {
+	forkH := h
+	for ; forkH > 0; forkH-- {
+		if h-forkH >= maxBchReorgDepth {
+			log.Fatal("BCH reorg is too deep, manual intervention required, block#", h)
+		}
+
+		saved, err := bot.db.getBchBlock(forkH)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Fatal("DB error, failed to get BCH block hash: ", err)
+			}
+			break // no saved hash, take it as the fork point
+		}
+
+		hash, err := bot.bchCli.GetBlockHash(int64(forkH))
+		if err != nil {
+			bot.logError("RPC error, failed to get BCH block hash: ", err)
+			return
+		}
+		if hash == saved.Hash {
+			break
+		}
+	}
+	log.Info("BCH fork point: block#", forkH)
+
+	if !bot.revertBchBlocksAbove(forkH) {
+		return
+	}
+
+	err := bot.db.deleteBchBlocksAbove(forkH)
+	if err != nil {
+		log.Fatal("DB error, failed to delete orphaned BCH block hashes: ", err)
+	}
+	err = bot.db.setLastBchHeight(forkH)
+	if err != nil {
+		log.Fatal("DB error, failed to update last BCH height: ", err)
+	}
+}
+
+// revert or re-verify records touched by orphaned blocks
+func (bot *MarketMakerBot) revertBchBlocksAbove(forkH uint64) bool {
+	b2sRecords, err := bot.db.getBch2SbchRecordsLockedAbove(forkH)
+	if err != nil {
+		bot.logError("DB error, failed to get BCH2SBCH records: ", err)
+		return false
+	}
+	for _, record := range b2sRecords {
+		log.Info("BCH2SBCH record locked in orphaned block: ", toJSON(record))
+		switch record.Status {
+		case Bch2SbchStatusNew, Bch2SbchStatusTooLateToLockSbch, Bch2SbchStatusPriceChanged,
+			Bch2SbchStatusInsufficientLiquidity:
+			// nothing is sent, the record will be recreated if its lock tx is mined again
+			err = bot.db.deleteBch2SbchRecord(record.withReason("BCH lock tx orphaned"))
+			if err != nil {
+				bot.logError("DB error, failed to delete BCH2SBCH record: ", err)
+				return false
+			}
+		default:
+			// sBCH is already locked, the lock tx must still be known by the node
+			_, err = bot.bchCli.GetTxConfirmations(record.BchLockTxHash)
+			if err != nil {
+				bot.logWarnf("BCH lock tx disappeared after reorg! hashLock: %s, txHash: %s, err: %s",
+					record.HashLock, record.BchLockTxHash, err.Error())
+			}
+		}
+	}
+
+	s2bRecords, err := bot.db.getSbch2BchRecordsUnlockedAbove(forkH)
+	if err != nil {
+		bot.logError("DB error, failed to get SBCH2BCH records: ", err)
+		return false
+	}
+	for _, record := range s2bRecords {
+		log.Info("SBCH2BCH record unlocked in orphaned block: ", toJSON(record))
+		if record.Status != Sbch2BchStatusSecretRevealed {
+			// sBCH is already unlocked
+			continue
+		}
+		// the status will be changed again if the unlock tx is mined again
+		err = bot.db.updateSbch2BchRecord(record.RevertStatusToBchLocked().
+			withReason("BCH unlock tx orphaned"))
+		if err != nil {
+			bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+			return false
+		}
+	}
+
+	return true
+}
+
 // find and handle BCH lock txs
 func (bot *MarketMakerBot) handleBchDepositTxs(h uint64, block *btcjson.GetBlockVerboseTxResult) {
 	deposits := htlcbch.GetHtlcLocksInfo(block)
@@ -534,37 +770,25 @@ func (bot *MarketMakerBot) handleBchDepositTxs(h uint64, block *btcjson.GetBlock
 // create bch2sbch records (status=new)
 func (bot *MarketMakerBot) handleBchDepositTxB2S(h uint64, deposit *htlcbch.HtlcLockInfo) {
 	log.Info("handleBchDepositTxB2S")
-	if !bytes.Equal(deposit.RecipientPkh, bot.bchPkh) {
-		log.Info("not send to me, recipientPkh: ",
-			toHex(deposit.RecipientPkh))
+	if !bot.isAcceptableBchDeposit(deposit) {
 		return
 	}
-	if deposit.Expiration != bot.bchTimeLock {
-		log.Infof("invalid expiration: %d != %d",
-			deposit.Expiration, bot.bchTimeLock)
-		return
-	}
-	if deposit.PenaltyBPS != bot.penaltyRatio {
-		log.Infof("invalid penaltyRatio: %d != %d",
-			deposit.PenaltyBPS, bot.penaltyRatio)
-		return
-	}
-	if deposit.Value < bot.minSwapVal ||
-		(bot.maxSwapVal > 0 && deposit.Value > bot.maxSwapVal) {
 
-		log.Infof("value out of range: %d ∉ [%d, %d]",
-			deposit.Value, bot.minSwapVal, bot.maxSwapVal)
-		return
-	}
-	if deposit.ExpectedPrice > bot.bchPrice {
-		log.Infof("expected BCH price is too high: %d > %d",
-			deposit.ExpectedPrice, bot.bchPrice)
+	// lock tx mined again after reorg
+	if record, err := bot.db.getBch2SbchRecordByBchLockOutPoint(deposit.TxHash, deposit.Vout); err == nil {
+		log.Info("BCH2SBCH record exists, update BchLockHeight: ", record.BchLockHeight, " => ", h)
+		record.BchLockHeight = h
+		err = bot.db.updateBch2SbchRecord(record)
+		if err != nil {
+			bot.logError("DB error, failed to update BCH2SBCH record: ", err)
+		}
 		return
 	}
 
 	err := bot.db.addBch2SbchRecord(&Bch2SbchRecord{
 		BchLockHeight:  h,
 		BchLockTxHash:  deposit.TxHash,
+		BchLockVout:    deposit.Vout,
 		Value:          deposit.Value,
 		BchPrice:       deposit.ExpectedPrice,
 		RecipientPkh:   toHex(deposit.RecipientPkh),
@@ -580,6 +804,38 @@ func (bot *MarketMakerBot) handleBchDepositTxB2S(h uint64, deposit *htlcbch.Htlc
 	}
 }
 
+// returns false if the deposit is not sent to the bot, or does not match its swap params
+func (bot *MarketMakerBot) isAcceptableBchDeposit(deposit *htlcbch.HtlcLockInfo) bool {
+	if !bytes.Equal(deposit.RecipientPkh, bot.bchPkh) {
+		log.Info("not send to me, recipientPkh: ",
+			toHex(deposit.RecipientPkh))
+		return false
+	}
+	if deposit.Expiration != bot.bchTimeLock {
+		log.Infof("invalid expiration: %d != %d",
+			deposit.Expiration, bot.bchTimeLock)
+		return false
+	}
+	if deposit.PenaltyBPS != bot.penaltyRatio {
+		log.Infof("invalid penaltyRatio: %d != %d",
+			deposit.PenaltyBPS, bot.penaltyRatio)
+		return false
+	}
+	if deposit.Value < bot.minSwapVal ||
+		(bot.maxSwapVal > 0 && deposit.Value > bot.maxSwapVal) {
+
+		log.Infof("value out of range: %d ∉ [%d, %d]",
+			deposit.Value, bot.minSwapVal, bot.maxSwapVal)
+		return false
+	}
+	if deposit.ExpectedPrice > bot.bchPrice {
+		log.Infof("expected BCH price is too high: %d > %d",
+			deposit.ExpectedPrice, bot.bchPrice)
+		return false
+	}
+	return true
+}
+
 // for sbch2bch record, change status from New to BchLocked
 func (bot *MarketMakerBot) handleBchDepositTxS2B(h uint64, deposit *htlcbch.HtlcLockInfo) {
 	if !bot.isSlaveMode {
@@ -602,7 +858,7 @@ func (bot *MarketMakerBot) handleBchDepositTxS2B(h uint64, deposit *htlcbch.Htlc
 
 	// TODO: add more checks
 
-	record.UpdateStatusToBchLocked(deposit.TxHash)
+	record.UpdateStatusToBchLocked(deposit.TxHash, deposit.Vout)
 	err = bot.db.updateSbch2BchRecord(record)
 	if err != nil {
 		bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
@@ -610,19 +866,146 @@ func (bot *MarketMakerBot) handleBchDepositTxS2B(h uint64, deposit *htlcbch.Htlc
 }
 
 // find and handle BCH unlock txs
-func (bot *MarketMakerBot) handleBchReceiptTxs(block *btcjson.GetBlockVerboseTxResult) {
+func (bot *MarketMakerBot) handleBchReceiptTxs(h uint64, block *btcjson.GetBlockVerboseTxResult) {
 	receipts := htlcbch.GetHtlcUnlocksInfo(block)
 	log.Info("HTLC receipts: ", len(receipts))
 	for _, receipt := range receipts {
 		log.Info("HTLC receipt:", toJSON(receipt))
-		bot.handleBchReceiptTx(receipt)
+		bot.handleBchReceiptTx(h, receipt)
+	}
+}
+
+// find BCH unlock txs in mempool, so that secrets are revealed before the txs are mined,
+// and BCH lock txs in mempool if zero-conf deposits are accepted
+func (bot *MarketMakerBot) scanBchMempoolTxs() {
+	if !bot.scanBchMempool && bot.zeroConfMaxSwapVal == 0 {
+		return
+	}
+
+	log.Info("scan BCH mempool ...")
+	txIDs, err := bot.bchCli.GetMempoolTxIDs()
+	if err != nil {
+		bot.logError("RPC error, failed to get BCH mempool: ", err)
+		return
+	}
+	log.Info("BCH mempool txs: ", len(txIDs))
+
+	mempoolTxs := make(map[string]struct{}, len(txIDs))
+	parsedTxs := make(map[string]struct{}, len(txIDs))
+	for _, txID := range txIDs {
+		mempoolTxs[txID] = struct{}{}
+		if _, ok := bot.bchMempoolTxs[txID]; ok {
+			parsedTxs[txID] = struct{}{}
+			continue
+		}
+
+		tx, err := bot.bchCli.GetTx(txID)
+		if err != nil {
+			// the tx may be mined or evicted, try again next time if it is still in mempool
+			log.Info("failed to get BCH tx: ", txID, ", err: ", err.Error())
+			continue
+		}
+		parsedTxs[txID] = struct{}{}
+
+		if bot.scanBchMempool {
+			for _, receipt := range htlcbch.ParseHtlcUnlockTx(*tx) {
+				log.Info("HTLC receipt in mempool: ", toJSON(receipt))
+				bot.handleBchReceiptTx(0, receipt)
+			}
+		}
+		if bot.zeroConfMaxSwapVal > 0 {
+			for _, deposit := range htlcbch.ParseHtlcLockTx(*tx) {
+				log.Info("HTLC deposit in mempool: ", toJSON(deposit))
+				if !bot.handleBchMempoolDepositTx(deposit) {
+					delete(parsedTxs, txID) // try again if other unconfirmed deposits are dropped or mined
+				}
+			}
+		}
+	}
+	bot.bchMempoolTxs = parsedTxs
+
+	if bot.zeroConfMaxSwapVal > 0 {
+		bot.checkUnconfirmedBchDeposits(mempoolTxs)
 	}
 }
 
-// for sbch2bch records, change status from BchLocked to SecretRevealed
-func (bot *MarketMakerBot) handleBchReceiptTx(receipt *htlcbch.HtlcUnlockInfo) {
+// create bch2sbch records (status=new, BchLockHeight=0) if the risk limits are not exceeded,
+// otherwise the deposit will be handled after its lock tx is mined.
+// returns false if the deposit should be checked again later
+func (bot *MarketMakerBot) handleBchMempoolDepositTx(deposit *htlcbch.HtlcLockInfo) bool {
+	if !bot.isAcceptableBchDeposit(deposit) {
+		return true // rejected, it is checked again after its lock tx is mined
+	}
+	if deposit.Value > bot.zeroConfMaxSwapVal {
+		log.Infof("value is too large for zero-conf: %d > %d",
+			deposit.Value, bot.zeroConfMaxSwapVal)
+		return true
+	}
+
+	totalVal, err := bot.db.getUnconfirmedBch2SbchValue()
+	if err != nil {
+		bot.logError("DB error, failed to get total value of unconfirmed BCH deposits: ", err)
+		return false
+	}
+	if totalVal+deposit.Value > bot.zeroConfMaxTotalVal {
+		log.Infof("total value of unconfirmed BCH deposits is too large: %d + %d > %d",
+			totalVal, deposit.Value, bot.zeroConfMaxTotalVal)
+		return false
+	}
+
+	bot.handleBchDepositTxB2S(0, deposit)
+	return true
+}
+
+// revert bch2sbch records whose lock txs are dropped from mempool (e.g. double-spent) before mined
+func (bot *MarketMakerBot) checkUnconfirmedBchDeposits(mempoolTxs map[string]struct{}) {
+	records, err := bot.db.getUnconfirmedBch2SbchRecords(bot.dbQueryLimit)
+	if err != nil {
+		bot.logError("DB error, failed to get unconfirmed BCH2SBCH records: ", err)
+		return
+	}
+	log.Info("unconfirmed BCH user deposits: ", len(records))
+
+	for _, record := range records {
+		if _, ok := mempoolTxs[record.BchLockTxHash]; ok {
+			continue
+		}
+		_, err = bot.bchCli.GetTxConfirmations(record.BchLockTxHash)
+		if err == nil {
+			continue // mined, BchLockHeight will be updated after the block is scanned
+		}
+		if !isTxNotFoundErr(err) {
+			bot.logError("RPC error, failed to get tx confirmations: ", err)
+			continue
+		}
+
+		switch record.Status {
+		case Bch2SbchStatusNew, Bch2SbchStatusTooLateToLockSbch, Bch2SbchStatusPriceChanged,
+			Bch2SbchStatusInsufficientLiquidity:
+			log.Info("BCH lock tx dropped from mempool: ", toJSON(record))
+			err = bot.db.deleteBch2SbchRecord(record.withReason("BCH lock tx dropped from mempool"))
+			if err != nil {
+				bot.logError("DB error, failed to delete BCH2SBCH record: ", err)
+			}
+		default:
+			if _, ok := bot.droppedBchLockTxs[record.BchLockTxHash]; ok {
+				continue
+			}
+			if bot.droppedBchLockTxs == nil {
+				bot.droppedBchLockTxs = map[string]struct{}{}
+			}
+			bot.droppedBchLockTxs[record.BchLockTxHash] = struct{}{}
+			bot.logWarnf("BCH lock tx dropped from mempool after sBCH is locked! hashLock: %s, txHash: %s",
+				record.HashLock, record.BchLockTxHash)
+		}
+	}
+}
+
+// for sbch2bch records, change status from BchLocked to SecretRevealed,
+// h is 0 if the unlock tx is found in mempool
+func (bot *MarketMakerBot) handleBchReceiptTx(h uint64, receipt *htlcbch.HtlcUnlockInfo) {
 	log.Info("handleBchReceiptTx")
-	record, err := bot.db.getSbch2BchRecordByBchLockTxHash(receipt.PrevTxHash)
+	record, err := bot.db.getSbch2BchRecordByBchLockOutPoint(receipt.PrevTxHash, receipt.PrevVout)
 	if err != nil {
 		log.Infof("can not get Sbch2BchRecord, BchLockTxHash=%s",
 			receipt.TxHash)
@@ -642,74 +1025,351 @@ func (bot *MarketMakerBot) handleBchReceiptTx(receipt *htlcbch.HtlcUnlockInfo) {
 	//	continue
 	//}
 
-	record.UpdateStatusToSecretRevealed(receipt.Secret, receipt.TxHash)
-	err = bot.db.updateSbch2BchRecord(record)
+	switch record.Status {
+	case Sbch2BchStatusSecretRevealed, Sbch2BchStatusSbchUnlocked:
+		if record.Secret != receipt.Secret {
+			return
+		}
+		// the secret was found in mempool, reconcile with the mined unlock tx
+		if h == 0 || (record.BchUnlockHeight == h && record.BchUnlockTxHash == receipt.TxHash) {
+			return
+		}
+		log.Info("BCH unlock tx mined, hashLock: ", hashLock, ", txHash: ", receipt.TxHash)
+		record.BchUnlockTxHash = receipt.TxHash
+		record.BchUnlockHeight = h
+	default:
+		if h == 0 {
+			record.withReason("found BCH unlock tx in mempool")
+		}
+		record.UpdateStatusToSecretRevealed(receipt.Secret, receipt.TxHash)
+		record.BchUnlockHeight = h
+	}
+	err = bot.db.updateSbch2BchRecord(record)
+	if err != nil {
+		bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+	}
+}
+
+// find and handle BCH refund txs
+func (bot *MarketMakerBot) handleBchRefundTxs(h uint64, block *btcjson.GetBlockVerboseTxResult) {
+	refunds := htlcbch.GetHtlcRefundsInfo(block)
+	log.Info("HTLC refunds: ", len(refunds))
+	for _, refund := range refunds {
+		log.Info("HTLC refund: ", toJSON(refund))
+		bot.handleBchRefundTxB2S(h, refund)
+		bot.handleBchRefundTxS2B(h, refund)
+	}
+}
+
+// user refunded BCH
+func (bot *MarketMakerBot) handleBchRefundTxB2S(h uint64, refund *htlcbch.HtlcRefundInfo) {
+	record, err := bot.db.getBch2SbchRecordByBchLockOutPoint(refund.PrevTxHash, refund.PrevVout)
+	if err != nil {
+		return
+	}
+	log.Info("handleBchRefundTxB2S")
+
+	switch record.Status {
+	case Bch2SbchStatusNew, Bch2SbchStatusTooLateToLockSbch, Bch2SbchStatusPriceChanged,
+		Bch2SbchStatusInsufficientLiquidity:
+		record.UpdateStatusToBchRefundedByUser(refund.TxHash)
+	case Bch2SbchStatusSecretRevealed:
+		bot.logWarnf("BCH refunded by user before unlocked by bot! hashLock: %s, txHash: %s",
+			record.HashLock, refund.TxHash)
+		record.UpdateStatusToBchRefundedByUser(refund.TxHash)
+	case Bch2SbchStatusBchUnlocked:
+		if record.BchUnlockTxHash != "?" {
+			return
+		}
+		// unlockBchUserDeposits found the UTXO spent by this refund tx
+		bot.logWarnf("BCH refunded by user before unlocked by bot! hashLock: %s, txHash: %s",
+			record.HashLock, refund.TxHash)
+		record.BchUnlockTxHash = ""
+		record.UpdateStatusToBchRefundedByUser(refund.TxHash)
+	case Bch2SbchStatusSbchLocked:
+		// sBCH is still locked, the status will be changed to SbchRefunded by refundLockedSbch
+		bot.logWarnf("BCH refunded by user while sBCH is locked! hashLock: %s, txHash: %s",
+			record.HashLock, refund.TxHash)
+		record.BchRefundTxHash = refund.TxHash
+	default:
+		return
+	}
+
+	err = bot.db.updateBch2SbchRecord(record.withReason("found BCH refund tx"))
+	if err != nil {
+		bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
+	}
+}
+
+// bot refunded BCH
+func (bot *MarketMakerBot) handleBchRefundTxS2B(h uint64, refund *htlcbch.HtlcRefundInfo) {
+	record, err := bot.db.getSbch2BchRecordByBchLockOutPoint(refund.PrevTxHash, refund.PrevVout)
+	if err != nil {
+		return
+	}
+	log.Info("handleBchRefundTxS2B")
+
+	if record.Status == Sbch2BchStatusBchRefunded && record.BchRefundTxHash == refund.TxHash {
+		return // already confirmed
+	}
+	if record.Status != Sbch2BchStatusBchLocked && record.Status != Sbch2BchStatusBchRefunded {
+		return
+	}
+
+	// refunded by the other bot (master or slave), or by a refund tx not sent by this bot
+	record.UpdateStatusToBchRefunded(refund.TxHash)
+	err = bot.db.updateSbch2BchRecord(record.withReason("found BCH refund tx"))
+	if err != nil {
+		bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+	}
+}
+
+func (bot *MarketMakerBot) scanSbchEvents() {
+	log.Info("scan sBCH events ...")
+	lastBlockNum, err := bot.db.getLastSbchHeight()
+	if err != nil {
+		log.Fatal("failed to get last height of smartBCH from DB: ", err)
+		return
+	}
+	log.Info("last sBCH height: ", lastBlockNum)
+
+	latestBlockNum, err := bot.sbchCli.getBlockNumber()
+	if err != nil {
+		bot.logError("failed to get height of smartBCH: ", err)
+		return
+	}
+	log.Info("latest sBCH height: ", latestBlockNum)
+	bot.metrics.setChainTipHeight(chainSbch, latestBlockNum)
+
+	if latestBlockNum < uint64(bot.sbchConfirmations) {
+		return
+	}
+	newBlockNum := latestBlockNum - uint64(bot.sbchConfirmations)
+
+	if lastBlockNum == 0 {
+		lastBlockNum = newBlockNum - 1
+		log.Info("init last sBCH height: ", lastBlockNum)
+	} else if !bot.checkSbchLastHash(lastBlockNum) {
+		return
+	}
+
+	for fromH := lastBlockNum + 1; fromH <= newBlockNum; fromH += sbchBlockBatch {
+		toH := fromH + sbchBlockBatch - 1
+		if toH > newBlockNum {
+			toH = newBlockNum
+		}
+		if !bot.handleSbchEvents(fromH, toH) {
+			break
+		}
+	}
+}
+
+func (bot *MarketMakerBot) handleSbchEvents(fromH, toH uint64) bool {
+	logs, err := bot.sbchCli.getHtlcLogs(fromH, toH)
+	if err != nil {
+		bot.logError("failed to get smartBCH logs: ", err)
+		return false
+	}
+	log.Infof("sBCH logs (block#%d ~ block#%d): %d",
+		fromH, toH, len(logs))
+
+	header, err := bot.sbchCli.getBlockHeader(toH)
+	if err != nil {
+		bot.logError(fmt.Sprintf("failed to get smartBCH block#%d: ", toH), err)
+		return false
+	}
+	for _, ethLog := range logs {
+		if ethLog.BlockNumber == toH && ethLog.BlockHash != header.Hash {
+			// reorg happened between the two queries, try again later
+			bot.logWarnf("sBCH block hash mismatch, block#%d, log: %s, header: %s",
+				toH, ethLog.BlockHash.String(), header.Hash.String())
+			return false
+		}
+	}
+
+	for _, ethLog := range logs {
+		log.Info("sBCH log: ", toJSON(ethLog))
+		if ethLog.Removed {
+			log.Info("log is removed, skip")
+			continue
+		}
+		switch ethLog.Topics[0] {
+		case htlcsbch.LockEventId:
+			bot.handleSbchLockEventS2B(ethLog)
+			bot.handleSbchLockEventB2S(ethLog)
+		case htlcsbch.UnlockEventId:
+			bot.handleSbchUnlockEvent(ethLog)
+		case htlcsbch.RefundEventId:
+			bot.handleSbchRefundEventS2B(ethLog)
+			bot.handleSbchRefundEventB2S(ethLog)
+		}
+	}
+
+	err = bot.db.addSbchBlock(toH, header.Hash.String(), uint64(header.Timestamp))
+	if err != nil {
+		log.Fatal("DB error, failed to save sBCH block hash: ", err)
+	}
+	if toH > sbchBlocksToKeep {
+		err = bot.db.deleteSbchBlocksBelow(toH - sbchBlocksToKeep)
+		if err != nil {
+			bot.logError("DB error, failed to delete old sBCH block hashes: ", err)
+		}
+	}
+
+	err = bot.db.setLastSbchHeight(toH)
+	if err != nil {
+		log.Fatal("DB error, failed to update last sBCH height: ", err)
+	}
+
+	return true
+}
+
+// compare the saved hash of the last scanned sBCH block with the one on chain,
+// rewind to the fork point if they do not match
+func (bot *MarketMakerBot) checkSbchLastHash(lastH uint64) bool {
+	saved, err := bot.db.getSbchBlock(lastH)
 	if err != nil {
-		bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Fatal("DB error, failed to get sBCH block hash: ", err)
+		}
+		return true // not scanned by this bot
 	}
-}
 
-func (bot *MarketMakerBot) scanSbchEvents() {
-	log.Info("scan sBCH events ...")
-	lastBlockNum, err := bot.db.getLastSbchHeight()
+	ok, err := bot.isSbchBlockOnChain(saved)
 	if err != nil {
-		log.Fatal("failed to get last height of smartBCH from DB: ", err)
-		return
+		bot.logError(fmt.Sprintf("failed to get smartBCH block#%d: ", lastH), err)
+		return false
 	}
-	log.Info("last sBCH height: ", lastBlockNum)
+	if ok {
+		return true
+	}
+
+	bot.logWarnf("sBCH reorg detected at block#%d, saved hash: %s", lastH, saved.Hash)
+	bot.handleSbchReorg(lastH)
+	return false
+}
 
-	newBlockNum, err := bot.sbchCli.getBlockNumber()
+func (bot *MarketMakerBot) isSbchBlockOnChain(saved *SbchBlock) (bool, error) {
+	header, err := bot.sbchCli.getBlockHeader(saved.Height)
 	if err != nil {
-		bot.logError("failed to get height of smartBCH: ", err)
-		return
+		return false, err
 	}
-	log.Info("latest sBCH height: ", newBlockNum)
+	return header.Hash.String() == saved.Hash, nil
+}
 
-	if lastBlockNum == 0 {
-		lastBlockNum = newBlockNum - 1
-		log.Info("init last sBCH height: ", lastBlockNum)
+// walk back through saved block ranges to the fork point, then reconcile records created from orphaned logs
+func (bot *MarketMakerBot) handleSbchReorg(lastH uint64) {
+	savedBlocks, err := bot.db.getSbchBlocksBelow(lastH, maxSbchReorgDepth)
+	if err != nil {
+		log.Fatal("DB error, failed to get sBCH block hashes: ", err)
 	}
 
-	blockBatch := uint64(200)
-	for fromH := lastBlockNum + 1; fromH <= newBlockNum; fromH += blockBatch {
-		toH := fromH + blockBatch - 1
-		if toH > newBlockNum {
-			toH = newBlockNum
+	var forkBlock *SbchBlock
+	for _, saved := range savedBlocks {
+		ok, err := bot.isSbchBlockOnChain(saved)
+		if err != nil {
+			bot.logError(fmt.Sprintf("failed to get smartBCH block#%d: ", saved.Height), err)
+			return
 		}
-		if !bot.handleSbchEvents(fromH, toH) {
+		if ok {
+			forkBlock = saved
 			break
 		}
 	}
+	if forkBlock == nil {
+		log.Fatal("sBCH reorg is too deep, manual intervention required, block#", lastH)
+	}
+	log.Info("sBCH fork point: block#", forkBlock.Height)
+
+	if !bot.reconcileSbchRecordsAfter(forkBlock.Time) {
+		return
+	}
+
+	err = bot.db.deleteSbchBlocksAbove(forkBlock.Height)
+	if err != nil {
+		log.Fatal("DB error, failed to delete orphaned sBCH block hashes: ", err)
+	}
+	err = bot.db.setLastSbchHeight(forkBlock.Height)
+	if err != nil {
+		log.Fatal("DB error, failed to update last sBCH height: ", err)
+	}
 }
 
-func (bot *MarketMakerBot) handleSbchEvents(fromH, toH uint64) bool {
-	logs, err := bot.sbchCli.getHtlcLogs(fromH, toH)
+// revert records whose sBCH lock txs are no longer present on chain
+func (bot *MarketMakerBot) reconcileSbchRecordsAfter(forkTime uint64) bool {
+	s2bRecords, err := bot.db.getSbch2BchRecordsSbchLockedAfter(forkTime)
 	if err != nil {
-		bot.logError("failed to get smartBCH logs: ", err)
+		bot.logError("DB error, failed to get SBCH2BCH records: ", err)
 		return false
 	}
-	log.Infof("sBCH logs (block#%d ~ block#%d): %d",
-		fromH, toH, len(logs))
+	for _, record := range s2bRecords {
+		present, err := bot.isSbchTxPresent(record.SbchLockTxHash)
+		if err != nil {
+			bot.logError("RPC error, failed to get sBCH tx receipt: ", err)
+			return false
+		}
+		if present {
+			continue
+		}
 
-	for _, ethLog := range logs {
-		log.Info("sBCH log: ", toJSON(ethLog))
-		switch ethLog.Topics[0] {
-		case htlcsbch.LockEventId:
-			bot.handleSbchLockEventS2B(ethLog)
-			bot.handleSbchLockEventB2S(ethLog)
-		case htlcsbch.UnlockEventId:
-			bot.handleSbchUnlockEvent(ethLog)
+		log.Info("SBCH2BCH record locked in orphaned block: ", toJSON(record))
+		switch record.Status {
+		case Sbch2BchStatusNew, Sbch2BchStatusTooLateToLockBch, Sbch2BchStatusPriceChanged,
+			Sbch2BchStatusInsufficientLiquidity:
+			// nothing is sent, the record will be recreated if its lock tx is mined again
+			err = bot.db.deleteSbch2BchRecord(record.withReason("sBCH lock tx orphaned"))
+			if err != nil {
+				bot.logError("DB error, failed to delete SBCH2BCH record: ", err)
+				return false
+			}
+		default:
+			bot.logWarnf("sBCH lock tx disappeared after reorg! hashLock: %s, txHash: %s",
+				record.HashLock, record.SbchLockTxHash)
 		}
 	}
 
-	err = bot.db.setLastSbchHeight(toH)
+	b2sRecords, err := bot.db.getBch2SbchRecordsSbchLockedAfter(forkTime)
 	if err != nil {
-		log.Fatal("DB error, failed to update last sBCH height: ", err)
+		bot.logError("DB error, failed to get BCH2SBCH records: ", err)
+		return false
+	}
+	for _, record := range b2sRecords {
+		if record.Status != Bch2SbchStatusSbchLocked {
+			// the secret is revealed, it's safe to unlock BCH
+			continue
+		}
+		present, err := bot.isSbchTxPresent(record.SbchLockTxHash)
+		if err != nil {
+			bot.logError("RPC error, failed to get sBCH tx receipt: ", err)
+			return false
+		}
+		if present {
+			continue
+		}
+
+		log.Info("BCH2SBCH record locked in orphaned block: ", toJSON(record))
+		err = bot.db.updateBch2SbchRecord(record.RevertStatusToNew().
+			withReason("sBCH lock tx orphaned"))
+		if err != nil {
+			bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
+			return false
+		}
 	}
 
 	return true
 }
 
+func (bot *MarketMakerBot) isSbchTxPresent(txHash string) (bool, error) {
+	_, err := bot.sbchCli.getTxReceipt(gethcmn.HexToHash(txHash))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ethereum.NotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
 // find sBCH lock events, create sbch2bch records (status = new)
 func (bot *MarketMakerBot) handleSbchLockEventS2B(ethLog gethtypes.Log) {
 	lockLog := htlcsbch.ParseHtlcLockLog(ethLog)
@@ -793,12 +1453,9 @@ func (bot *MarketMakerBot) handleSbchLockEventS2B(ethLog gethtypes.Log) {
 	}
 }
 
-// bch2sbch record: New => SbchLocked
+// bch2sbch record: New => SbchLocked,
+// the lock tx is sent by master, or by this bot before it is restarted
 func (bot *MarketMakerBot) handleSbchLockEventB2S(ethLog gethtypes.Log) {
-	if !bot.isSlaveMode {
-		return
-	}
-
 	lockLog := htlcsbch.ParseHtlcLockLog(ethLog)
 	if lockLog == nil {
 		return
@@ -812,7 +1469,7 @@ func (bot *MarketMakerBot) handleSbchLockEventB2S(ethLog gethtypes.Log) {
 		return
 	}
 
-	log.Info("got a sBCH Lock log (slave): ", toJSON(lockLog))
+	log.Info("got a sBCH Lock log (B2S): ", toJSON(lockLog))
 
 	record, err := bot.db.getBch2SbchRecordByHashLock(toHex(lockLog.HashLock[:]))
 	if err != nil {
@@ -872,7 +1529,103 @@ func (bot *MarketMakerBot) handleSbchUnlockEvent(ethLog gethtypes.Log) {
 	}
 }
 
-// bch2sbch records: New => SbchLocked|TooLateToLockSbch
+// user refunded sBCH
+// sbch2bch records: New|TooLateToLockBch|PriceChanged|InsufficientLiquidity => SbchRefundedByUser,
+// SecretRevealed => SbchRefundedByUser with a warning (the bot is too late to unlock sBCH),
+// BchLocked is left to refundLockedBCH with a warning, only SbchRefundTxHash is set
+func (bot *MarketMakerBot) handleSbchRefundEventS2B(ethLog gethtypes.Log) {
+	refundLog := htlcsbch.ParseHtlcRefundLog(ethLog)
+	if refundLog == nil {
+		return
+	}
+
+	hashLock := toHex(refundLog.HashLock[:])
+	record, err := bot.db.getSbch2BchRecordByHashLock(hashLock)
+	if err != nil {
+		log.Infof("can not get Sbch2BchRecord, hashLock=%s", hashLock)
+		return
+	}
+	log.Info("got a sBCH Refund log: ", toJSON(refundLog))
+
+	// the same hashLock may be used by bot's lock in BCH2SBCH direction
+	state, err := bot.sbchCli.getSwapState(gethcmn.HexToAddress(record.SbchSenderAddr), refundLog.HashLock)
+	if err != nil {
+		bot.logError("RPC error, failed to get swap state: ", err)
+		return
+	}
+	if state != SwapRefunded {
+		log.Info("swap of user is not refunded, hashLock: ", hashLock)
+		return
+	}
+
+	txHash := toHex(refundLog.TxHash[:])
+	switch record.Status {
+	case Sbch2BchStatusNew, Sbch2BchStatusTooLateToLockBch, Sbch2BchStatusPriceChanged,
+		Sbch2BchStatusInsufficientLiquidity:
+		record.UpdateStatusToSbchRefundedByUser(txHash)
+	case Sbch2BchStatusSecretRevealed:
+		bot.logWarnf("sBCH refunded by user before unlocked by bot! hashLock: %s, txHash: %s",
+			hashLock, txHash)
+		record.UpdateStatusToSbchRefundedByUser(txHash)
+	case Sbch2BchStatusBchLocked:
+		// BCH is still locked, the status will be changed to BchRefunded by refundLockedBCH
+		bot.logWarnf("sBCH refunded by user while BCH is locked! hashLock: %s, txHash: %s",
+			hashLock, txHash)
+		record.SbchRefundTxHash = txHash
+	default:
+		return
+	}
+
+	err = bot.db.updateSbch2BchRecord(record)
+	if err != nil {
+		bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+	}
+}
+
+// bot refunded sBCH
+func (bot *MarketMakerBot) handleSbchRefundEventB2S(ethLog gethtypes.Log) {
+	refundLog := htlcsbch.ParseHtlcRefundLog(ethLog)
+	if refundLog == nil {
+		return
+	}
+
+	hashLock := toHex(refundLog.HashLock[:])
+	record, err := bot.db.getBch2SbchRecordByHashLock(hashLock)
+	if err != nil {
+		log.Infof("can not get Bch2SbchRecord, hashLock=%s", hashLock)
+		return
+	}
+	log.Info("got a sBCH Refund log: ", toJSON(refundLog))
+
+	txHash := toHex(refundLog.TxHash[:])
+	if record.Status == Bch2SbchStatusSbchRefunded && record.SbchRefundTxHash == txHash {
+		return // already confirmed
+	}
+	if record.Status != Bch2SbchStatusSbchLocked && record.Status != Bch2SbchStatusSbchRefunded {
+		return
+	}
+
+	// the same hashLock may be used by user's lock in SBCH2BCH direction
+	state, err := bot.sbchCli.getSwapState(bot.sbchAddr, refundLog.HashLock)
+	if err != nil {
+		bot.logError("RPC error, failed to get swap state: ", err)
+		return
+	}
+	if state != SwapRefunded {
+		log.Info("swap of bot is not refunded, hashLock: ", hashLock)
+		return
+	}
+
+	// refunded by the other bot (master or slave), or the refund tx hash is unknown ("?")
+	record.withReason("found sBCH refund log")
+	record.UpdateStatusToSbchRefunded(txHash)
+	err = bot.db.updateBch2SbchRecord(record)
+	if err != nil {
+		bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
+	}
+}
+
+// bch2sbch records: New => SbchLocked|TooLateToLockSbch|InsufficientLiquidity
 func (bot *MarketMakerBot) handleBchUserDeposits() {
 	if bot.isSlaveMode {
 		return
@@ -885,14 +1638,30 @@ func (bot *MarketMakerBot) handleBchUserDeposits() {
 		return
 	}
 	log.Info("unhandled BCH user deposits: ", len(records))
+	if len(records) == 0 {
+		return
+	}
+
+	balance, err := bot.sbchCli.getBotBalance()
+	if err != nil {
+		bot.logError("RPC error, failed to get sBCH balance: ", err)
+		return
+	}
+	// sBCH locked by pending txs is still in the balance, it is reserved until the txs are mined
+	bot.liquidity.update(DirectionBch2Sbch, weiToSats(balance), bot.getPendingSbchLocks())
 
 	for _, record := range records {
+		if bot.hasPendingSbchTx(txTypeLock, record.HashLock) {
+			continue
+		}
 		log.Info("handle BCH user deposit: ", toJSON(record))
 
 		if record.BchPrice > bot.bchPrice {
 			log.Infof("BCH price changed, expected price: %d, current price: %d",
 				record.BchPrice, bot.bchPrice)
 			record.Status = Bch2SbchStatusPriceChanged
+			record.withReason(fmt.Sprintf("expected price: %d, current price: %d",
+				record.BchPrice, bot.bchPrice))
 			err = bot.db.updateBch2SbchRecord(record)
 			if err != nil {
 				bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
@@ -913,6 +1682,8 @@ func (bot *MarketMakerBot) handleBchUserDeposits() {
 				", confirmations: ", confirmations,
 				", timeLock: ", record.TimeLock)
 			record.Status = Bch2SbchStatusTooLateToLockSbch
+			record.withReason(fmt.Sprintf("confirmations: %d, timeLock: %d",
+				confirmations, record.TimeLock))
 			err = bot.db.updateBch2SbchRecord(record)
 			if err != nil {
 				bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
@@ -927,36 +1698,42 @@ func (bot *MarketMakerBot) handleBchUserDeposits() {
 		log.Info("sbchTimeLock: ", sbchTimeLock,
 			" , bchPrice: ", bot.bchPrice, " , sbchVal: ", sbchVal)
 
-		txHash, err := bot.sbchCli.lockSbchToHtlc(
+		if available, ok := bot.liquidity.reserve(DirectionBch2Sbch, record.HashLock, sbchVal); !ok {
+			log.Infof("insufficient sBCH liquidity, needed: %d, available: %d", sbchVal, available)
+			record.Status = Bch2SbchStatusInsufficientLiquidity
+			record.withReason(fmt.Sprintf("sBCH needed: %d, available: %d", sbchVal, available))
+			err = bot.db.updateBch2SbchRecord(record)
+			if err != nil {
+				bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
+			}
+			continue
+		}
+
+		tx, err := bot.sbchCli.lockSbchToHtlc(
 			gethcmn.HexToAddress(record.SenderEvmAddr),
 			gethcmn.HexToHash(record.HashLock),
 			sbchTimeLock,
 			satsToWei(sbchVal),
 		)
+		if err == nil {
+			err = bot.sendSbchTx(tx, txTypeLock, record.ID, record.HashLock, sbchVal, sbchTimeLock)
+		}
 		if err != nil {
 			bot.logError("RPC error, failed to lock sBCH to HTLC: ", err)
+			bot.metrics.incTxsFailed(chainSbch, txTypeLock)
+			bot.liquidity.release(DirectionBch2Sbch, record.HashLock)
 			continue
 		}
+		bot.metrics.incTxsSent(chainSbch, txTypeLock)
 
-		log.Info("lock sBCH successful",
+		// the status will be changed by checkPendingSbchTxs once the tx is mined
+		log.Info("sBCH lock tx sent",
 			", hashLock: ", record.HashLock,
-			", txHash: ", txHash.String())
-
-		txTime, err := bot.sbchCli.getTxTime(*txHash)
-		if err != nil {
-			bot.logError("RPC error, failed to get sBCH tx time:", err)
-			txTime = uint64(time.Now().Unix())
-		}
-
-		record.UpdateStatusToSbchLocked(toHex(txHash[:]), txTime)
-		err = bot.db.updateBch2SbchRecord(record)
-		if err != nil {
-			bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
-		}
+			", txHash: ", tx.Hash().String())
 	}
 }
 
-// sbch2bch records: New => BchLocked|TooLateToLockSbch
+// sbch2bch records: New => BchLocked|TooLateToLockSbch|InsufficientLiquidity
 func (bot *MarketMakerBot) handleSbchUserDeposits() {
 	if bot.isSlaveMode {
 		return
@@ -977,6 +1754,28 @@ func (bot *MarketMakerBot) handleSbchUserDeposits() {
 		return
 	}
 	log.Info("unhandled sBCH user deposits: ", len(records))
+	if len(records) == 0 {
+		return
+	}
+
+	reservedUtxos, err := bot.getReservedUtxos()
+	if err != nil {
+		bot.logError("DB error, failed to get reserved UTXOs: ", err)
+		return
+	}
+	utxos, err := bot.bchCli.GetAllUTXOs()
+	if err != nil {
+		bot.logError("RPC error, failed to get UTXOs: ", err)
+		return
+	}
+	freeBch := uint64(0)
+	for _, utxo := range utxos {
+		if !reservedUtxos[utxoKey(utxo.TxID, utxo.Vout)] {
+			freeBch += uint64(utxoAmtToSats(utxo.Amount))
+		}
+	}
+	// UTXOs spent by sent lock txs are not free, so no reservations are committed
+	bot.liquidity.update(DirectionSbch2Bch, freeBch, nil)
 
 	for _, record := range records {
 		log.Info("SBCH2BCH record: ", toJSON(record))
@@ -985,6 +1784,8 @@ func (bot *MarketMakerBot) handleSbchUserDeposits() {
 			log.Infof("sBCH price changed, expected price: %d, current price: %d",
 				record.SbchPrice, bot.sbchPrice)
 			record.Status = Sbch2BchStatusPriceChanged
+			record.withReason(fmt.Sprintf("expected price: %d, current price: %d",
+				record.SbchPrice, bot.sbchPrice))
 			err = bot.db.updateSbch2BchRecord(record)
 			if err != nil {
 				bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
@@ -994,22 +1795,6 @@ func (bot *MarketMakerBot) handleSbchUserDeposits() {
 
 		// val * sbchPrice / 1e8
 		bchVal := int64(mulByPrice(record.Value, record.SbchPrice))
-		utxos, err := bot.bchCli.GetUTXOs(bchVal+5000, 10)
-		if err != nil {
-			bot.logError("failed to get UTXOs: ", err)
-			continue
-		}
-		log.Info("sBCH price: ", bot.sbchPrice,
-			", bchVal: ", bchVal, ", UTXOs:", toJSON(utxos))
-
-		inputs := make([]htlcbch.InputInfo, len(utxos))
-		for i, utxo := range utxos {
-			inputs[i] = htlcbch.InputInfo{
-				TxID:   gethcmn.FromHex(utxo.TxID),
-				Vout:   utxo.Vout,
-				Amount: utxoAmtToSats(utxo.Amount),
-			}
-		}
 
 		currTime, err := bot.sbchCli.getBlockTimeLatest()
 		if err != nil {
@@ -1022,6 +1807,8 @@ func (bot *MarketMakerBot) handleSbchUserDeposits() {
 		if uint32(timeElapsed) > bot.sbchTimeLock/3 {
 			log.Info("too late to lock BCH, time elapsed: ", timeElapsed, ", timeLock: ", record.TimeLock)
 			record.Status = Sbch2BchStatusTooLateToLockBch
+			record.withReason(fmt.Sprintf("time elapsed: %d, timeLock: %d",
+				timeElapsed, record.TimeLock))
 			err = bot.db.updateSbch2BchRecord(record)
 			if err != nil {
 				bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
@@ -1032,6 +1819,18 @@ func (bot *MarketMakerBot) handleSbchUserDeposits() {
 			log.Info("time elapsed: ", timeElapsed, ", timeLock: ", record.TimeLock)
 		}
 
+		bchNeeded := uint64(bchVal) + bchLockFeeReserve
+		if available, ok := bot.liquidity.reserve(DirectionSbch2Bch, record.HashLock, bchNeeded); !ok {
+			log.Infof("insufficient BCH liquidity, needed: %d, available: %d", bchNeeded, available)
+			record.Status = Sbch2BchStatusInsufficientLiquidity
+			record.withReason(fmt.Sprintf("BCH needed: %d, available: %d", bchNeeded, available))
+			err = bot.db.updateSbch2BchRecord(record)
+			if err != nil {
+				bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+			}
+			continue
+		}
+
 		bchTimeLock := sbchTimeLockToBlocks(record.TimeLock) / 2
 		log.Info("BCH timeLock: ", bchTimeLock)
 
@@ -1044,39 +1843,114 @@ func (bot *MarketMakerBot) handleSbchUserDeposits() {
 		)
 		if err != nil {
 			bot.logError("failed to create HTLC covenant: ", err)
+			bot.liquidity.release(DirectionSbch2Bch, record.HashLock)
 			continue
 		}
+		if !bot.lockBch(record, covenant, bchVal, reservedUtxos) {
+			bot.liquidity.release(DirectionSbch2Bch, record.HashLock)
+		}
+	}
+}
 
-		tx, err := covenant.MakeLockTx(
-			bot.bchPrivKey,
-			inputs,
-			bchVal,
-			bot.bchLockMinerFeeRate,
-		)
-		if err != nil {
-			bot.logError("failed to create BCH tx: ", err)
-			continue
+// lockBch sends the BCH lock tx of the record, returns false if the tx is not sent
+func (bot *MarketMakerBot) lockBch(record *Sbch2BchRecord, covenant *htlcbch.HtlcCovenant,
+	bchVal int64, reservedUtxos map[string]bool) bool {
+
+	// the covenant is needed to estimate the size of lock tx
+	baseTxSize, err := covenant.GetLockTxBaseSize()
+	if err != nil {
+		bot.logError("failed to estimate size of BCH tx: ", err)
+		return false
+	}
+	feeRate := bot.getBchFeeRate(txTypeLock)
+	utxos, err := bot.bchCli.GetUTXOs(coinSelectionParams{
+		outAmt:     bchVal,
+		feeRate:    int64(feeRate),
+		baseTxSize: baseTxSize,
+		inputSize:  htlcbch.P2PKHInputSize,
+		changeSize: htlcbch.P2PKHOutputSize,
+		dustAmt:    htlcbch.DustAmt,
+		maxInputs:  bchLockTxMaxInputs,
+	}, reservedUtxos)
+	if err != nil {
+		bot.logError("failed to get UTXOs: ", err)
+		return false
+	}
+	log.Info("sBCH price: ", bot.sbchPrice,
+		", bchVal: ", bchVal, ", UTXOs:", toJSON(utxos))
+
+	inputs := make([]htlcbch.InputInfo, len(utxos))
+	for i, utxo := range utxos {
+		inputs[i] = htlcbch.InputInfo{
+			TxID:   gethcmn.FromHex(utxo.TxID),
+			Vout:   utxo.Vout,
+			Amount: utxoAmtToSats(utxo.Amount),
 		}
-		log.Info("BCH tx hex: ", htlcbch.MsgTxToHex(tx))
+	}
 
-		txHash, err := bot.bchCli.SendTx(tx)
-		if err != nil {
-			bot.logError("failed to send BCH tx: ", err)
+	tx, err := covenant.MakeLockTx(
+		bot.bchPrivKey,
+		inputs,
+		bchVal,
+		feeRate,
+	)
+	if err != nil {
+		bot.logError("failed to create BCH tx: ", err)
+		return false
+	}
+	log.Info("BCH tx hex: ", htlcbch.MsgTxToHex(tx))
 
-			// more debug info
-			//prevPkScript, _ := htlcbch.PayToPubKeyHashPkScript(bot.bchPkh)
-			//log.Infof("meep debug --tx=%s --idx=%d --amt=%d --pkscript=%s",
-			//	htlcbch.MsgTxToHex(tx), 0, utxoAmtToSats(utxo.Amount), toHex(prevPkScript))
-			continue
+	// reserve UTXOs and save the tx before sending it,
+	// the records are locked or the UTXOs are released by recoverPendingTxs if the bot crashes here
+	lockTxHash := tx.TxHash().String()
+	err = bot.reserveUtxos(utxos, lockTxHash, record.HashLock)
+	if err != nil {
+		bot.logError("DB error, failed to reserve UTXOs: ", err)
+		return false
+	}
+	err = bot.db.addPendingTx(newBchLockPendingTx(tx, record))
+	if err != nil {
+		bot.logError("DB error, failed to save pending tx: ", err)
+		if err := bot.db.deleteUtxoReservationsBySpendingTx(lockTxHash); err != nil {
+			bot.logError("DB error, failed to release UTXOs: ", err)
 		}
-		log.Info("BCH tx sent, hash: ", txHash.String())
+		return false
+	}
 
-		record.UpdateStatusToBchLocked(txHash.String())
-		err = bot.db.updateSbch2BchRecord(record)
-		if err != nil {
-			bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+	txHash, err := bot.bchCli.SendTx(tx)
+	if err != nil {
+		bot.logError("failed to send BCH tx: ", err)
+		bot.metrics.incTxsFailed(chainBch, txTypeLock)
+		if err := bot.db.deletePendingTxs([]string{lockTxHash}); err != nil {
+			bot.logError("DB error, failed to delete pending tx: ", err)
+		}
+		if err := bot.db.deleteUtxoReservationsBySpendingTx(lockTxHash); err != nil {
+			bot.logError("DB error, failed to release UTXOs: ", err)
 		}
+
+		// more debug info
+		//prevPkScript, _ := htlcbch.PayToPubKeyHashPkScript(bot.bchPkh)
+		//log.Infof("meep debug --tx=%s --idx=%d --amt=%d --pkscript=%s",
+		//	htlcbch.MsgTxToHex(tx), 0, utxoAmtToSats(utxo.Amount), toHex(prevPkScript))
+		return false
+	}
+	log.Info("BCH tx sent, hash: ", txHash.String())
+	bot.metrics.incTxsSent(chainBch, txTypeLock)
+	for _, utxo := range utxos {
+		reservedUtxos[utxoKey(utxo.TxID, utxo.Vout)] = true
+	}
+
+	bot.liquidity.commit(DirectionSbch2Bch, record.HashLock)
+	record.UpdateStatusToBchLocked(txHash.String(), 0)
+	err = bot.db.updateSbch2BchRecord(record)
+	if err != nil {
+		bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+	}
+	err = bot.db.deletePendingTxs([]string{lockTxHash})
+	if err != nil {
+		bot.logError("DB error, failed to delete pending tx: ", err)
 	}
+	return true
 }
 
 // bch2sbch records: SecretRevealed => BchUnlocked
@@ -1120,11 +1994,12 @@ func (bot *MarketMakerBot) unlockBchUserDeposits() {
 		p2shAddr, _ := covenant.GetP2SHAddress()
 		log.Info("covenant: ", p2shAddr)
 
+		feeRate := bot.getBchFeeRate(txTypeUnlock)
 		tx, err := covenant.MakeUnlockTx(
 			gethcmn.FromHex(record.BchLockTxHash),
-			0,
+			record.BchLockVout,
 			int64(record.Value),
-			bot.bchUnlockMinerFeeRate,
+			feeRate,
 			gethcmn.FromHex(record.Secret),
 		)
 		if err != nil {
@@ -1134,13 +2009,26 @@ func (bot *MarketMakerBot) unlockBchUserDeposits() {
 		log.Info("tx: ", htlcbch.MsgTxToHex(tx))
 
 		txHashStr := "?"
-		if txHash, err := bot.bchCli.SendTx(tx); err == nil {
+		if txHash, err := bot.sendBchTxMergingUtxos(tx, feeRate, func(inputs []htlcbch.InputInfo) (*wire.MsgTx, error) {
+			return covenant.MakeUnlockTxWithInputs(
+				gethcmn.FromHex(record.BchLockTxHash),
+				record.BchLockVout,
+				int64(record.Value),
+				feeRate,
+				gethcmn.FromHex(record.Secret),
+				bot.bchPrivKey,
+				inputs,
+			)
+		}); err == nil {
 			log.Info("BCH unlock tx sent, hash: ", txHash.String())
 			txHashStr = txHash.String()
+			bot.metrics.incTxsSent(chainBch, txTypeUnlock)
 		} else {
 			bot.logError("failed to unlock BCH: ", err)
+			bot.metrics.incTxsFailed(chainBch, txTypeUnlock)
 			if isUtxoSpentErr(err) {
 				log.Info("UTXO is spent by others")
+				record.withReason("UTXO is spent by others")
 			} else {
 				continue
 			}
@@ -1181,26 +2069,34 @@ func (bot *MarketMakerBot) unlockSbchUserDeposits() {
 			}
 		}
 
+		if bot.hasPendingSbchTx(txTypeUnlock, record.HashLock) {
+			continue
+		}
+
 		sender := gethcmn.HexToAddress(record.SbchSenderAddr)
 		hashLock := gethcmn.HexToHash(record.HashLock)
 		secret := gethcmn.HexToHash(record.Secret)
 
-		txHashStr := "?"
-		if txHash, err := bot.sbchCli.unlockSbchFromHtlc(sender, hashLock, secret); err == nil {
-			txHashStr = toHex(txHash[:])
-			log.Info("sBCH unlock tx sent, hash: ", txHashStr)
-		} else {
-			bot.logError("RPC error, failed to unlock sBCH: ", err)
+		tx, err := bot.sbchCli.unlockSbchFromHtlc(sender, hashLock, secret)
+		if err == nil {
+			err = bot.sendSbchTx(tx, txTypeUnlock, record.ID, record.HashLock, 0, record.TimeLock)
+		}
+		if err == nil {
+			// the status will be changed by checkPendingSbchTxs once the tx is mined
+			log.Info("sBCH unlock tx sent, hash: ", tx.Hash().String())
+			bot.metrics.incTxsSent(chainSbch, txTypeUnlock)
+			continue
+		}
 
-			state, _ := bot.sbchCli.getSwapState(sender, hashLock)
-			if state == SwapUnlocked {
-				log.Info("swap is unlockd")
-			} else {
-				continue
-			}
+		bot.logError("RPC error, failed to unlock sBCH: ", err)
+		bot.metrics.incTxsFailed(chainSbch, txTypeUnlock)
+		state, _ := bot.sbchCli.getSwapState(sender, hashLock)
+		if state != SwapUnlocked {
+			continue
 		}
+		log.Info("swap is unlockd")
 
-		record.UpdateStatusToSbchUnlocked(txHashStr)
+		record.UpdateStatusToSbchUnlocked("?")
 		err = bot.db.updateSbch2BchRecord(record)
 		if err != nil {
 			bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
@@ -1263,11 +2159,12 @@ func (bot *MarketMakerBot) refundLockedBCH(gotNewBlocks bool) {
 
 		// val * sbchPrice / 1e8
 		bchVal := int64(mulByPrice(record.Value, record.SbchPrice))
+		feeRate := bot.getBchFeeRate(txTypeRefund)
 		tx, err := covenant.MakeRefundTx(
 			gethcmn.FromHex(record.BchLockTxHash),
-			0,
+			record.BchLockVout,
 			bchVal,
-			bot.bchRefundMinerFeeRate,
+			feeRate,
 		)
 		if err != nil {
 			bot.logError("failed to make refund tx: ", err)
@@ -1275,20 +2172,29 @@ func (bot *MarketMakerBot) refundLockedBCH(gotNewBlocks bool) {
 		}
 		log.Info("refund tx: ", htlcbch.MsgTxToHex(tx))
 
-		txHashStr := "?"
-		if txHash, err := bot.bchCli.SendTx(tx); err == nil {
-			log.Info("BCH refund tx sent, hash: ", txHash.String())
-			txHashStr = txHash.String()
-		} else {
+		txHash, err := bot.sendBchTxMergingUtxos(tx, feeRate, func(inputs []htlcbch.InputInfo) (*wire.MsgTx, error) {
+			return covenant.MakeRefundTxWithInputs(
+				gethcmn.FromHex(record.BchLockTxHash),
+				record.BchLockVout,
+				bchVal,
+				feeRate,
+				bot.bchPrivKey,
+				inputs,
+			)
+		})
+		if err != nil {
 			bot.logError("failed to refund BCH: ", err)
+			bot.metrics.incTxsFailed(chainBch, txTypeRefund)
 			if isUtxoSpentErr(err) {
-				log.Info("UTXO is spent by others")
-			} else {
-				continue
+				// the status will be changed by handleBchRefundTxS2B or handleBchReceiptTx
+				log.Info("UTXO is spent by others, wait for the spending tx to be mined")
 			}
+			continue
 		}
+		log.Info("BCH refund tx sent, hash: ", txHash.String())
+		bot.metrics.incTxsSent(chainBch, txTypeRefund)
 
-		record.UpdateStatusToBchRefunded(txHashStr)
+		record.UpdateStatusToBchRefunded(txHash.String())
 		err = bot.db.updateSbch2BchRecord(record)
 		if err != nil {
 			bot.logError("DB error, failed to save SBCH2BCH record: ", err)
@@ -1338,24 +2244,31 @@ func (bot *MarketMakerBot) refundLockedSbch() {
 			continue
 		}
 
-		hashLock := gethcmn.HexToHash(record.HashLock)
+		if bot.hasPendingSbchTx(txTypeRefund, record.HashLock) {
+			continue
+		}
 
-		txHashStr := "?"
-		if txHash, err := bot.sbchCli.refundSbchFromHtlc(bot.sbchAddr, hashLock); err == nil {
-			txHashStr = toHex(txHash.Bytes())
-			log.Info("sBCH refund tx sent, hash: ", txHashStr)
-		} else {
-			bot.logError("RPC error, failed to refund sBCH: ", err)
+		hashLock := gethcmn.HexToHash(record.HashLock)
+		tx, err := bot.sbchCli.refundSbchFromHtlc(bot.sbchAddr, hashLock)
+		if err == nil {
+			err = bot.sendSbchTx(tx, txTypeRefund, record.ID, record.HashLock, 0, sbchTimeLock)
+		}
+		if err == nil {
+			// the status will be changed by checkPendingSbchTxs once the tx is mined
+			log.Info("sBCH refund tx sent, hash: ", tx.Hash().String())
+			bot.metrics.incTxsSent(chainSbch, txTypeRefund)
+			continue
+		}
 
-			state, _ := bot.sbchCli.getSwapState(bot.sbchAddr, hashLock)
-			if state == SwapRefunded {
-				log.Info("swap is refunded")
-			} else {
-				continue
-			}
+		bot.logError("RPC error, failed to refund sBCH: ", err)
+		bot.metrics.incTxsFailed(chainSbch, txTypeRefund)
+		state, _ := bot.sbchCli.getSwapState(bot.sbchAddr, hashLock)
+		if state != SwapRefunded {
+			continue
 		}
+		log.Info("swap is refunded")
 
-		record.UpdateStatusToSbchRefunded(txHashStr)
+		record.UpdateStatusToSbchRefunded("?")
 		err = bot.db.updateBch2SbchRecord(record)
 		if err != nil {
 			bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
@@ -2,6 +2,7 @@ package bot
 
 import (
 	"crypto/sha256"
+	"errors"
 	"strconv"
 	"testing"
 	"time"
@@ -11,7 +12,9 @@ import (
 	gethcmn "github.com/ethereum/go-ethereum/common"
 	gethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/btcjson"
 	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/chaincfg/chainhash"
 	"github.com/gcash/bchd/wire"
 	"github.com/gcash/bchutil"
 
@@ -131,6 +134,209 @@ func TestBch2Sbch_userLockBch(t *testing.T) {
 	require.Equal(t, Bch2SbchStatusNew, record0.Status)
 }
 
+func TestBch2Sbch_userLockBch_multiOutputs(t *testing.T) {
+	_botPkh := testBchPkh
+	_userPkh := gethAddrBytes("user")
+	_timeLock := uint16(100)
+	_penaltyBPS := uint16(500)
+	_evmAddr := gethAddrBytes("evm")
+
+	var txOuts []*wire.TxOut
+	txOuts = append(txOuts, &wire.TxOut{Value: 1000000, PkScript: newP2SHPkScript(gethAddrBytes("change"))})
+	for i := 0; i < 2; i++ {
+		hashLock := gethHash32Bytes("hash" + strconv.Itoa(i))
+		covenant, err := htlcbch.NewMainnetCovenant(_userPkh, _botPkh, hashLock, _timeLock, _penaltyBPS)
+		require.NoError(t, err)
+		scriptHash, err := covenant.GetRedeemScriptHash()
+		require.NoError(t, err)
+		txOuts = append(txOuts,
+			&wire.TxOut{Value: int64(1000000 * (i + 1)), PkScript: newP2SHPkScript(scriptHash)},
+			&wire.TxOut{PkScript: newHtlcDepositOpRet(_botPkh, _userPkh, hashLock, _timeLock, _penaltyBPS, _evmAddr, 1e8)},
+		)
+	}
+	_tx := &wire.MsgTx{TxIn: []*wire.TxIn{}, TxOut: txOuts}
+
+	_db := initDB(t, 123, 456)
+	_bchCli := newMockBchClient(124, 126)
+	_bchCli.blocks[126] = &wire.MsgBlock{Transactions: []*wire.MsgTx{_tx}}
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       _bchCli,
+		bchPkh:       _botPkh,
+		bchTimeLock:  _timeLock,
+		penaltyRatio: _penaltyBPS,
+		bchPrice:     1e8,
+		sbchPrice:    1e8,
+	}
+	_bot.scanBchBlocks()
+
+	// every HTLC output is accepted
+	records, err := _db.getBch2SbchRecordsByStatus(Bch2SbchStatusNew, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	for i, record := range records {
+		require.Equal(t, _tx.TxHash().String(), record.BchLockTxHash)
+		require.Equal(t, uint32(2*i+1), record.BchLockVout)
+		require.Equal(t, uint64(1000000*(i+1)), record.Value)
+		require.Equal(t, toHex(gethHash32Bytes("hash"+strconv.Itoa(i))), record.HashLock)
+	}
+
+	// only the record of the refunded HTLC output is updated
+	_bot.handleBchRefundTxB2S(127, &htlcbch.HtlcRefundInfo{
+		PrevTxHash: _tx.TxHash().String(),
+		PrevVout:   3,
+		TxHash:     "refundtx",
+	})
+	record, err := _db.getBch2SbchRecordByHashLock(records[0].HashLock)
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusNew, record.Status)
+	record, err = _db.getBch2SbchRecordByHashLock(records[1].HashLock)
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusBchRefundedByUser, record.Status)
+	require.Equal(t, "refundtx", record.BchRefundTxHash)
+}
+
+func TestBch2Sbch_userLockBch_zeroConf(t *testing.T) {
+	_botPkh := testBchPkh
+	_userPkh := gethAddrBytes("user")
+	_timeLock := uint16(100)
+	_penaltyBPS := uint16(500)
+	_evmAddr := gethAddrBytes("evm")
+
+	makeLockTx := func(hashLock []byte, val int64) *wire.MsgTx {
+		covenant, err := htlcbch.NewMainnetCovenant(_userPkh, _botPkh, hashLock, _timeLock, _penaltyBPS)
+		require.NoError(t, err)
+		scriptHash, err := covenant.GetRedeemScriptHash()
+		require.NoError(t, err)
+		return &wire.MsgTx{
+			TxIn: []*wire.TxIn{},
+			TxOut: []*wire.TxOut{
+				{Value: val, PkScript: newP2SHPkScript(scriptHash)},
+				{PkScript: newHtlcDepositOpRet(_botPkh, _userPkh, hashLock, _timeLock, _penaltyBPS, _evmAddr, 1e8)},
+			},
+		}
+	}
+	_tx1 := makeLockTx(gethHash32Bytes("hash1"), 1500000)
+	_tx2 := makeLockTx(gethHash32Bytes("hash2"), 1500000)
+	_tx3 := makeLockTx(gethHash32Bytes("hash3"), 2500000) // exceeds per-swap limit
+	_tx4 := makeLockTx(gethHash32Bytes("hash4"), 1000000)
+
+	_db := initDB(t, 123, 456)
+	_bchCli := newMockBchClient(122, 126)
+	_bchCli.mempool = []*wire.MsgTx{_tx1, _tx2, _tx3}
+	_bot := &MarketMakerBot{
+		db:                  _db,
+		dbQueryLimit:        100,
+		bchCli:              _bchCli,
+		bchPkh:              _botPkh,
+		bchTimeLock:         _timeLock,
+		penaltyRatio:        _penaltyBPS,
+		bchPrice:            1e8,
+		sbchPrice:           1e8,
+		zeroConfMaxSwapVal:  2000000,
+		zeroConfMaxTotalVal: 2500000,
+		errLogQueue:         newErrLogQueue(100),
+	}
+
+	getRecord := func(tx *wire.MsgTx) *Bch2SbchRecord {
+		record, err := _db.getBch2SbchRecordByBchLockTxHash(tx.TxHash().String())
+		if err != nil {
+			return nil
+		}
+		return record
+	}
+
+	// tx2 exceeds total limit
+	_bot.scanBchMempoolTxs()
+	require.NotNil(t, getRecord(_tx1))
+	require.Equal(t, uint64(0), getRecord(_tx1).BchLockHeight)
+	require.Nil(t, getRecord(_tx2))
+	require.Nil(t, getRecord(_tx3))
+
+	// tx1 mined, tx2 accepted
+	_bchCli.mempool = []*wire.MsgTx{_tx2, _tx3}
+	_bchCli.blocks[126] = &wire.MsgBlock{Transactions: []*wire.MsgTx{_tx1}}
+	_bchCli.confirmations[_tx1.TxHash().String()] = 1
+	_bot.scanBchBlocks()
+	_bot.scanBchMempoolTxs()
+	require.Equal(t, uint64(126), getRecord(_tx1).BchLockHeight)
+	require.NotNil(t, getRecord(_tx2))
+	require.Equal(t, uint64(0), getRecord(_tx2).BchLockHeight)
+	require.Nil(t, getRecord(_tx3))
+
+	// tx2 dropped after sBCH is locked, tx4 accepted
+	record2 := getRecord(_tx2)
+	require.NoError(t, _db.updateBch2SbchRecord(record2.UpdateStatusToSbchLocked("sbchlock", 1234)))
+	_bchCli.mempool = []*wire.MsgTx{_tx3, _tx4}
+	_bchCli.confirmations[_tx2.TxHash().String()] = -1
+	_bot.scanBchMempoolTxs()
+	_bot.scanBchMempoolTxs()
+	require.Equal(t, Bch2SbchStatusSbchLocked, getRecord(_tx2).Status)
+	require.NotNil(t, getRecord(_tx4))
+	require.Len(t, _bot.errLogQueue.removeErrLogs(100), 1)
+
+	// tx4 dropped
+	_bchCli.mempool = nil
+	_bchCli.confirmations[_tx4.TxHash().String()] = -1
+	_bot.scanBchMempoolTxs()
+	require.Nil(t, getRecord(_tx4))
+	require.NotNil(t, getRecord(_tx2))
+	require.Nil(t, getRecord(_tx3))
+}
+
+func TestBch2Sbch_userLockBch_zeroConfRejected(t *testing.T) {
+	_botPkh := testBchPkh
+	_userPkh := gethAddrBytes("user")
+	_timeLock := uint16(100)
+	_penaltyBPS := uint16(500)
+	_evmAddr := gethAddrBytes("evm")
+
+	makeLockTx := func(recipientPkh, hashLock []byte, timeLock uint16, val int64) *wire.MsgTx {
+		covenant, err := htlcbch.NewMainnetCovenant(_userPkh, recipientPkh, hashLock, timeLock, _penaltyBPS)
+		require.NoError(t, err)
+		scriptHash, err := covenant.GetRedeemScriptHash()
+		require.NoError(t, err)
+		return &wire.MsgTx{
+			TxIn: []*wire.TxIn{},
+			TxOut: []*wire.TxOut{
+				{Value: val, PkScript: newP2SHPkScript(scriptHash)},
+				{PkScript: newHtlcDepositOpRet(recipientPkh, _userPkh, hashLock, timeLock, _penaltyBPS, _evmAddr, 1e8)},
+			},
+		}
+	}
+	_tx1 := makeLockTx(_botPkh, gethHash32Bytes("hash1"), _timeLock, 1500000)
+	_tx2 := makeLockTx(gethAddrBytes("other"), gethHash32Bytes("hash2"), _timeLock, 1500000) // not sent to the bot
+	_tx3 := makeLockTx(_botPkh, gethHash32Bytes("hash3"), _timeLock/2, 1500000)              // invalid expiration
+
+	_db := initDB(t, 123, 456)
+	_bchCli := newMockBchClient(122, 126)
+	_bchCli.mempool = []*wire.MsgTx{_tx1, _tx2, _tx3}
+	_bot := &MarketMakerBot{
+		db:                  _db,
+		dbQueryLimit:        100,
+		bchCli:              _bchCli,
+		bchPkh:              _botPkh,
+		bchTimeLock:         _timeLock,
+		penaltyRatio:        _penaltyBPS,
+		bchPrice:            1e8,
+		sbchPrice:           1e8,
+		zeroConfMaxSwapVal:  2000000,
+		zeroConfMaxTotalVal: 2500000,
+		errLogQueue:         newErrLogQueue(100),
+	}
+
+	// tx2 and tx3 are rejected for good, not retried even if they exceed the total limit
+	_bot.scanBchMempoolTxs()
+	_, err := _db.getBch2SbchRecordByBchLockTxHash(_tx1.TxHash().String())
+	require.NoError(t, err)
+	for _, tx := range []*wire.MsgTx{_tx2, _tx3} {
+		_, err = _db.getBch2SbchRecordByBchLockTxHash(tx.TxHash().String())
+		require.Error(t, err)
+	}
+	require.Len(t, _bot.bchMempoolTxs, 3)
+}
+
 func TestBch2Sbch_userLockBch_invalidParams(t *testing.T) {
 	_userPkh := gethAddrBytes("user")
 	_hashLock := gethHash32Bytes("hash")
@@ -263,6 +469,7 @@ func TestBch2Sbch_botLockSbch(t *testing.T) {
 
 	_bchCli := newMockBchClient(124, 125)
 	_sbchCli := newMockSbchClient(457, 999, 0)
+	_sbchCli.balance = satsToWei(1e8)
 	_bot := &MarketMakerBot{
 		db:           _db,
 		dbQueryLimit: 100,
@@ -275,9 +482,23 @@ func TestBch2Sbch_botLockSbch(t *testing.T) {
 		sbchPrice:    _botSbchPrice,
 	}
 	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 1)
+	require.Len(t, _bot.pendingSbchTxs, 1)
 
+	// lock tx is not mined yet
+	_bot.handleBchUserDeposits()
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _sbchCli.sentTxs, 1)
 	unhandled, err := _db.getBch2SbchRecordsByStatus(Bch2SbchStatusNew, 100)
 	require.NoError(t, err)
+	require.Len(t, unhandled, 1)
+
+	// mined
+	_sbchCli.mineSentTxs()
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 0)
+	unhandled, err = _db.getBch2SbchRecordsByStatus(Bch2SbchStatusNew, 100)
+	require.NoError(t, err)
 	require.Len(t, unhandled, 0)
 
 	bchLocked, err := _db.getBch2SbchRecordsByStatus(Bch2SbchStatusSbchLocked, 100)
@@ -295,9 +516,98 @@ func TestBch2Sbch_botLockSbch(t *testing.T) {
 	require.Equal(t, toHex(_scriptHash), record0.HtlcScriptHash)
 	require.Equal(t, "", record0.Secret)
 	require.Equal(t, "", record0.BchUnlockTxHash)
+	require.Equal(t, toHex(_sbchCli.sentTxs[0].Hash().Bytes()), record0.SbchLockTxHash)
 	require.Equal(t, Bch2SbchStatusSbchLocked, record0.Status)
 }
 
+func TestBch2Sbch_botLockSbch_insufficientLiquidity(t *testing.T) {
+	_botPkh := gethAddrBytes("bot")
+	_botBchPrice := uint64(1e8)
+
+	_db := initDB(t, 123, 456)
+	for i, val := range []uint64{6e7, 5e7, 4e7} {
+		require.NoError(t, _db.addBch2SbchRecord(&Bch2SbchRecord{
+			BchLockHeight:  123,
+			BchLockTxHash:  toHex(gethHash32Bytes("bchlock" + strconv.Itoa(i))),
+			Value:          val,
+			BchPrice:       _botBchPrice,
+			RecipientPkh:   toHex(_botPkh),
+			SenderPkh:      toHex(gethAddrBytes("user")),
+			HashLock:       toHex(gethHash32Bytes("hash" + strconv.Itoa(i))),
+			TimeLock:       100,
+			SenderEvmAddr:  toHex(gethAddrBytes("evm")),
+			HtlcScriptHash: toHex(gethAddrBytes("htlc" + strconv.Itoa(i))),
+			Status:         Bch2SbchStatusNew,
+		}))
+	}
+
+	_sbchCli := newMockSbchClient(457, 999, 0)
+	_sbchCli.balance = satsToWei(1e8)
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       newMockBchClient(124, 125),
+		sbchCli:      _sbchCli,
+		bchPrivKey:   testBchPrivKey,
+		bchPkh:       _botPkh,
+		bchTimeLock:  72,
+		bchPrice:     _botBchPrice,
+		sbchPrice:    1e8,
+	}
+	_bot.handleBchUserDeposits()
+	_sbchCli.mineSentTxs()
+	_bot.checkPendingSbchTxs()
+
+	records, err := _db.getBch2SbchRecordsByStatus(Bch2SbchStatusSbchLocked, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint64(6e7), records[0].Value)
+	require.Equal(t, uint64(4e7), records[1].Value)
+
+	records, err = _db.getBch2SbchRecordsByStatus(Bch2SbchStatusInsufficientLiquidity, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, uint64(5e7), records[0].Value)
+	transitions, err := _db.GetStatusTransitions(records[0].HashLock)
+	require.NoError(t, err)
+	require.Equal(t, "sBCH needed: 50000000, available: 40000000", transitions[len(transitions)-1].Reason)
+
+	// reservations are kept until the next round, the mined lock txs are not deducted from the balance yet
+	book := _bot.liquidity.snapshot()[DirectionBch2Sbch]
+	require.Equal(t, uint64(1e8), book.Free)
+	require.Equal(t, uint64(1e8), book.Reserved)
+	require.Len(t, book.Reservations, 2)
+
+	// insufficient liquidity is final, the user will refund BCH
+	_bot.handleBchRefundTxB2S(130, &htlcbch.HtlcRefundInfo{
+		PrevTxHash: records[0].BchLockTxHash,
+		TxHash:     "refundtx",
+	})
+	record, err := _db.getBch2SbchRecordByHashLock(records[0].HashLock)
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusBchRefundedByUser, record.Status)
+}
+
+func TestBch2Sbch_botLockSbch_sendFailed(t *testing.T) {
+	_bot, _sbchCli := newTestBotWithNewBch2SbchRecords(t, 2)
+	_sbchCli.balance = satsToWei(1.5e7) // enough for one record only
+	_sbchCli.sendTxErrOnce = errors.New("failed to send tx")
+
+	// the liquidity reserved for the first record is released, so the second one is locked
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 1)
+	require.False(t, _bot.hasPendingSbchTx(txTypeLock, toHex(gethHash32Bytes("hash0"))))
+	require.True(t, _bot.hasPendingSbchTx(txTypeLock, toHex(gethHash32Bytes("hash1"))))
+	records, err := _bot.db.getBch2SbchRecordsByStatus(Bch2SbchStatusNew, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	book := _bot.liquidity.snapshot()[DirectionBch2Sbch]
+	require.Equal(t, uint64(1.5e7), book.Free)
+	require.Equal(t, uint64(1e7), book.Reserved)
+	require.Equal(t, []*Reservation{{toHex(gethHash32Bytes("hash1")), 1e7}}, book.Reservations)
+}
+
 func TestBch2Sbch_botLockSbch_priceChanged(t *testing.T) {
 	_val := uint64(12345678)
 	_txHash := gethHash32Bytes("bchlock")
@@ -422,6 +732,7 @@ func TestBch2Sbch_botLockSbch_tooLate(t *testing.T) {
 		db:           _db,
 		dbQueryLimit: 100,
 		bchCli:       _bchCli,
+		sbchCli:      newMockSbchClient(457, 999, 0),
 		bchPrivKey:   testBchPrivKey,
 		bchPkh:       _botPkh,
 		bchTimeLock:  72,
@@ -627,6 +938,9 @@ func TestBch2Sbch_botRefundSbch(t *testing.T) {
 	}
 
 	_bot.refundLockedSbch()
+	require.Len(t, _sbchCli.sentTxs, 1)
+	_sbchCli.mineSentTxs()
+	_bot.checkPendingSbchTxs()
 
 	secretRevealed, err := _db.getBch2SbchRecordsByStatus(Bch2SbchStatusSbchRefunded, 100)
 	require.NoError(t, err)
@@ -642,11 +956,129 @@ func TestBch2Sbch_botRefundSbch(t *testing.T) {
 	require.Equal(t, toHex(_scriptHash), record0.HtlcScriptHash)
 	require.Equal(t, toHex(_sbchLockTxHash), record0.SbchLockTxHash)
 	require.Equal(t, "", record0.BchUnlockTxHash)
-	require.Equal(t, "a2834f77f929353179fe8b7fc1e792f02fe56ebfcaa2b5eb55484818b6397a49",
-		record0.SbchRefundTxHash)
+	require.Equal(t, toHex(_sbchCli.sentTxs[0].Hash().Bytes()), record0.SbchRefundTxHash)
 	require.Equal(t, Bch2SbchStatusSbchRefunded, record0.Status)
 }
 
+func TestBch2Sbch_handleSbchRefundEvent(t *testing.T) {
+	_hashLock1 := gethHash32("hashlock1") // refunded by slave
+	_hashLock2 := gethHash32("hashlock2") // refund tx hash is unknown
+	_hashLock3 := gethHash32("hashlock3") // refunded by user in SBCH2BCH direction
+	_refundTxHash1 := gethHash32("refund1")
+	_refundTxHash2 := gethHash32("refund2")
+	_refundTxHash3 := gethHash32("refund3")
+
+	_db := initDB(t, 123, 456)
+	for i, hashLock := range []gethcmn.Hash{_hashLock1, _hashLock2, _hashLock3} {
+		record := createFakeBch2SbchRecord(uint(i + 1))
+		record.HashLock = toHex(hashLock[:])
+		require.NoError(t, _db.addBch2SbchRecord(record))
+		record.UpdateStatusToSbchLocked("sbchlock"+strconv.Itoa(i), 1234)
+		if hashLock == _hashLock2 {
+			record.UpdateStatusToSbchRefunded("?")
+		}
+		require.NoError(t, _db.updateBch2SbchRecord(record))
+	}
+
+	_sbchCli := newMockSbchClient(457, 999, 0)
+	_sbchCli.setSwapState(testEvmAddr, _hashLock1, SwapRefunded)
+	_sbchCli.setSwapState(testEvmAddr, _hashLock2, SwapRefunded)
+	_sbchCli.logs[458] = []gethtypes.Log{
+		{TxHash: _refundTxHash1, Topics: []gethcmn.Hash{htlcsbch.RefundEventId, _hashLock1}},
+		{TxHash: _refundTxHash2, Topics: []gethcmn.Hash{htlcsbch.RefundEventId, _hashLock2}},
+		{TxHash: _refundTxHash3, Topics: []gethcmn.Hash{htlcsbch.RefundEventId, _hashLock3}},
+	}
+
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		sbchCli:      _sbchCli,
+		sbchAddr:     testEvmAddr,
+		errLogQueue:  newErrLogQueue(100),
+	}
+	_bot.scanSbchEvents()
+
+	record1, err := _db.getBch2SbchRecordByHashLock(toHex(_hashLock1[:]))
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusSbchRefunded, record1.Status)
+	require.Equal(t, toHex(_refundTxHash1[:]), record1.SbchRefundTxHash)
+
+	record2, err := _db.getBch2SbchRecordByHashLock(toHex(_hashLock2[:]))
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusSbchRefunded, record2.Status)
+	require.Equal(t, toHex(_refundTxHash2[:]), record2.SbchRefundTxHash)
+
+	record3, err := _db.getBch2SbchRecordByHashLock(toHex(_hashLock3[:]))
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusSbchLocked, record3.Status)
+	require.Equal(t, "", record3.SbchRefundTxHash)
+}
+
+func TestBch2Sbch_userRefundBch(t *testing.T) {
+	_bchLockTxHash1 := bchHash32("bchlock1") // New
+	_bchLockTxHash2 := bchHash32("bchlock2") // SbchLocked
+	_bchLockTxHash3 := bchHash32("bchlock3") // SecretRevealed
+	_bchLockTxHash4 := bchHash32("bchlock4") // BchUnlocked, but UTXO is spent by others
+
+	_db := initDB(t, 123, 456)
+	_bchCli := newMockBchClient(122, 129)
+	_bchCli.blocks[127] = &wire.MsgBlock{}
+	for i, lockTxHash := range []chainhash.Hash{_bchLockTxHash1, _bchLockTxHash2, _bchLockTxHash3, _bchLockTxHash4} {
+		record := createFakeBch2SbchRecord(uint(i + 1))
+		record.BchLockTxHash = lockTxHash.String()
+		require.NoError(t, _db.addBch2SbchRecord(record))
+		switch lockTxHash {
+		case _bchLockTxHash2:
+			record.UpdateStatusToSbchLocked("sbchlock", 1234)
+		case _bchLockTxHash3:
+			record.UpdateStatusToSecretRevealed("secret", "sbchunlock")
+		case _bchLockTxHash4:
+			record.UpdateStatusToBchUnlocked("?")
+		}
+		require.NoError(t, _db.updateBch2SbchRecord(record))
+
+		_bchCli.blocks[127].Transactions = append(_bchCli.blocks[127].Transactions,
+			makeBchRefundTx(t, lockTxHash.String()))
+	}
+
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       _bchCli,
+		bchPkh:       testBchPkh,
+		errLogQueue:  newErrLogQueue(100),
+	}
+	_bot.scanBchBlocks()
+
+	refundTxs := _bchCli.blocks[127].Transactions
+	record1, err := _db.getBch2SbchRecordByBchLockTxHash(_bchLockTxHash1.String())
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusBchRefundedByUser, record1.Status)
+	require.Equal(t, refundTxs[0].TxHash().String(), record1.BchRefundTxHash)
+
+	record2, err := _db.getBch2SbchRecordByBchLockTxHash(_bchLockTxHash2.String())
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusSbchLocked, record2.Status)
+	require.Equal(t, refundTxs[1].TxHash().String(), record2.BchRefundTxHash)
+
+	record3, err := _db.getBch2SbchRecordByBchLockTxHash(_bchLockTxHash3.String())
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusBchRefundedByUser, record3.Status)
+	require.Equal(t, refundTxs[2].TxHash().String(), record3.BchRefundTxHash)
+
+	record4, err := _db.getBch2SbchRecordByBchLockTxHash(_bchLockTxHash4.String())
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusBchRefundedByUser, record4.Status)
+	require.Equal(t, refundTxs[3].TxHash().String(), record4.BchRefundTxHash)
+	require.Equal(t, "", record4.BchUnlockTxHash)
+
+	require.Len(t, _bot.errLogQueue.removeErrLogs(100), 3)
+
+	transitions, err := _db.GetStatusTransitions(record1.HashLock)
+	require.NoError(t, err)
+	require.Equal(t, "found BCH refund tx", transitions[len(transitions)-1].Reason)
+}
+
 func TestBch2Sbch_handleSbchLockEvent_slaveMode(t *testing.T) {
 	_val := uint64(12345678)
 	_txHash := gethHash32Bytes("bchlock")
@@ -963,7 +1395,7 @@ func TestSbch2Bch_botLockBch(t *testing.T) {
 		Status:           Sbch2BchStatusNew,
 	}))
 
-	_bchCli := &MockBchClient{}
+	_bchCli := &MockBchClient{utxos: []btcjson.ListUnspentResult{{Amount: 1}}}
 	_sbchCli := newMockSbchClient(457, 500, _lockTime+60)
 	_bot := &MarketMakerBot{
 		db:           _db,
@@ -992,13 +1424,165 @@ func TestSbch2Bch_botLockBch(t *testing.T) {
 	require.Equal(t, toHex(_hashLock), record0.HashLock)
 	require.Equal(t, uint32(36000), record0.TimeLock)
 	require.Equal(t, toHex(_scriptHash), record0.HtlcScriptHash)
-	require.Equal(t, "c0c8dc27968d3324c310da653917949163c49c081c9b3a0622bd305b1c7635e7",
+	require.Equal(t, "3202e7d0b6302e79cfeea76a272fb3dcb6e5041b4346e4f308aa60f594a16d45",
 		record0.BchLockTxHash)
 	require.Equal(t, "", record0.Secret)
 	require.Equal(t, "", record0.SbchUnlockTxHash)
 	require.Equal(t, Sbch2BchStatusBchLocked, record0.Status)
 }
 
+func TestSbch2Bch_botLockBch_insufficientLiquidity(t *testing.T) {
+	_lockTime := uint64(1683248875)
+	_timeLock := uint32(36000)
+
+	_db := initDB(t, 123, 456)
+	for i, val := range []uint64{3e7, 8e7} {
+		require.NoError(t, _db.addSbch2BchRecord(&Sbch2BchRecord{
+			SbchLockTime:    _lockTime,
+			SbchLockTxHash:  toHex(gethHash32Bytes("sbchlocktx" + strconv.Itoa(i))),
+			Value:           val,
+			SbchPrice:       1e8,
+			SbchSenderAddr:  gethAddr("uevm").String(),
+			BchRecipientPkh: toHex(gethAddrBytes("ubch")),
+			HashLock:        toHex(gethHash32Bytes("hashlock" + strconv.Itoa(i))),
+			TimeLock:        _timeLock,
+			HtlcScriptHash:  toHex(gethAddrBytes("htlc" + strconv.Itoa(i))),
+			Status:          Sbch2BchStatusNew,
+		}))
+	}
+
+	_bchCli := &MockBchClient{
+		utxos:     []btcjson.ListUnspentResult{{Amount: 1}},
+		sendTxErr: errors.New("failed to send tx"),
+	}
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       _bchCli,
+		bchPrivKey:   testBchPrivKey,
+		bchPkh:       testBchPkh,
+		sbchCli:      newMockSbchClient(457, 500, _lockTime+60),
+		sbchAddr:     testEvmAddr,
+		sbchTimeLock: _timeLock,
+		bchPrice:     1e8,
+		sbchPrice:    1e8,
+		errLogQueue:  newErrLogQueue(100),
+	}
+	_bot.handleSbchUserDeposits()
+
+	// the liquidity of the failed first record is released, so the second one is not rejected
+	records, err := _db.getSbch2BchRecordsByStatus(Sbch2BchStatusNew, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	book := _bot.liquidity.snapshot()[DirectionSbch2Bch]
+	require.Equal(t, uint64(1e8), book.Free)
+	require.Equal(t, uint64(0), book.Reserved)
+	require.Len(t, book.Reservations, 0)
+
+	_bchCli.sendTxErr = nil
+	_bot.handleSbchUserDeposits()
+	records, err = _db.getSbch2BchRecordsByStatus(Sbch2BchStatusBchLocked, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, uint64(3e7), records[0].Value)
+
+	records, err = _db.getSbch2BchRecordsByStatus(Sbch2BchStatusInsufficientLiquidity, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, uint64(8e7), records[0].Value)
+
+	book = _bot.liquidity.snapshot()[DirectionSbch2Bch]
+	require.Equal(t, uint64(1e8-3e7-bchLockFeeReserve), book.Free)
+	require.Len(t, book.Reservations, 0)
+}
+
+func TestSbch2Bch_botLockBch_utxoReservation(t *testing.T) {
+	_lockTime := uint64(1683248875)
+	_timeLock := uint32(36000)
+
+	_db := initDB(t, 123, 456)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, _db.addSbch2BchRecord(&Sbch2BchRecord{
+			SbchLockTime:    _lockTime,
+			SbchLockTxHash:  toHex(gethHash32Bytes("sbchlocktx" + strconv.Itoa(i))),
+			Value:           1e7,
+			SbchPrice:       1e8,
+			SbchSenderAddr:  gethAddr("uevm").String(),
+			BchRecipientPkh: toHex(gethAddrBytes("ubch")),
+			HashLock:        toHex(gethHash32Bytes("hashlock" + strconv.Itoa(i))),
+			TimeLock:        _timeLock,
+			HtlcScriptHash:  toHex(gethAddrBytes("htlc" + strconv.Itoa(i))),
+			Status:          Sbch2BchStatusNew,
+		}))
+	}
+
+	_bchCli := newMockBchClient(122, 129)
+	_bchCli.utxos = []btcjson.ListUnspentResult{{Amount: 1}}
+	_bchCli.sendTxErr = errors.New("failed to send tx")
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       _bchCli,
+		bchPrivKey:   testBchPrivKey,
+		bchPkh:       testBchPkh,
+		sbchCli:      newMockSbchClient(457, 500, _lockTime+60),
+		sbchAddr:     testEvmAddr,
+		sbchTimeLock: _timeLock,
+		bchPrice:     1e8,
+		sbchPrice:    1e8,
+		errLogQueue:  newErrLogQueue(100),
+	}
+
+	// reservations are released if the lock tx is not sent
+	_bot.handleSbchUserDeposits()
+	reservations, err := _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 0)
+
+	// the fake UTXO is reserved by the first lock tx, so the second record is retried
+	_bchCli.sendTxErr = nil
+	_bot.handleSbchUserDeposits()
+	locked, err := _db.getSbch2BchRecordsByStatus(Sbch2BchStatusBchLocked, 100)
+	require.NoError(t, err)
+	require.Len(t, locked, 1)
+	unhandled, err := _db.getSbch2BchRecordsByStatus(Sbch2BchStatusNew, 100)
+	require.NoError(t, err)
+	require.Len(t, unhandled, 1)
+
+	reservations, err = _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 1)
+	require.Equal(t, locked[0].BchLockTxHash, reservations[0].SpendingTxHash)
+	require.Equal(t, locked[0].HashLock, reservations[0].HashLock)
+	require.Equal(t, uint64(2*1e7), reservations[0].Value)
+
+	// the lock tx is still in mempool
+	_bot.releaseUtxoReservations()
+	reservations, err = _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 1)
+
+	// the lock tx is confirmed
+	_bchCli.confirmations[locked[0].BchLockTxHash] = 1
+	_bot.releaseUtxoReservations()
+	reservations, err = _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 0)
+
+	_bot.handleSbchUserDeposits()
+	locked, err = _db.getSbch2BchRecordsByStatus(Sbch2BchStatusBchLocked, 100)
+	require.NoError(t, err)
+	require.Len(t, locked, 2)
+
+	// the lock tx is dropped
+	_bchCli.confirmations[locked[1].BchLockTxHash] = -1
+	_bot.releaseUtxoReservations()
+	reservations, err = _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 0)
+}
+
 func TestSbch2Bch_botLockBch_priceChanged(t *testing.T) {
 	_sbchLockTxHash := gethHash32Bytes("sbchlocktx")
 	_val := uint64(12345678)
@@ -1195,6 +1779,78 @@ func TestSbch2Bch_userUnlockBch(t *testing.T) {
 	require.Equal(t, Sbch2BchStatusSecretRevealed, record0.Status)
 }
 
+func TestSbch2Bch_userUnlockBch_mempool(t *testing.T) {
+	_secret := gethHash32Bytes("secret")
+	_hashLock := gethcmn.FromHex(secretToHashLock(_secret))
+	_timeLock := uint16(888)
+	_userBchPkh := gethAddrBytes("ubch")
+	_bchLockTxHash := bchHash32("bchlocktx")
+
+	c, err := htlcbch.NewMainnetCovenant(
+		testBchPkh,
+		_userBchPkh,
+		_hashLock,
+		_timeLock,
+		0,
+	)
+	require.NoError(t, err)
+	_unlockTx, err := c.MakeUnlockTx(gethcmn.FromHex(_bchLockTxHash.String()), 0, 12345678, 1, _secret)
+	require.NoError(t, err)
+
+	_db := initDB(t, 123, 456)
+	record := createFakeSbch2BchRecord(1)
+	record.HashLock = toHex(_hashLock)
+	record.SbchSenderAddr = gethAddr("uevm").String()
+	require.NoError(t, _db.addSbch2BchRecord(record))
+	require.NoError(t, _db.updateSbch2BchRecord(record.UpdateStatusToBchLocked(_bchLockTxHash.String(), 0)))
+
+	_bchCli := newMockBchClient(122, 129)
+	_bchCli.mempool = []*wire.MsgTx{_unlockTx}
+	_bot := &MarketMakerBot{
+		db:             _db,
+		dbQueryLimit:   100,
+		bchCli:         _bchCli,
+		sbchCli:        &MockSbchClient{},
+		bchPkh:         testBchPkh,
+		scanBchMempool: true,
+	}
+
+	// found in mempool
+	_bot.scanBchMempoolTxs()
+	_bot.scanBchMempoolTxs()
+	require.Len(t, _bot.bchMempoolTxs, 1)
+	record, err = _db.getSbch2BchRecordByHashLock(toHex(_hashLock))
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusSecretRevealed, record.Status)
+	require.Equal(t, toHex(_secret), record.Secret)
+	require.Equal(t, _unlockTx.TxHash().String(), record.BchUnlockTxHash)
+	require.Equal(t, uint64(0), record.BchUnlockHeight)
+
+	_bot.unlockSbchUserDeposits()
+	_bot.sbchCli.(*MockSbchClient).mineSentTxs()
+	_bot.checkPendingSbchTxs()
+	record, err = _db.getSbch2BchRecordByHashLock(toHex(_hashLock))
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusSbchUnlocked, record.Status)
+
+	// mined
+	_bchCli.mempool = nil
+	_bchCli.blocks[127] = &wire.MsgBlock{Transactions: []*wire.MsgTx{_unlockTx}}
+	_bot.scanBchMempoolTxs()
+	_bot.scanBchBlocks()
+	require.Len(t, _bot.bchMempoolTxs, 0)
+	record, err = _db.getSbch2BchRecordByHashLock(toHex(_hashLock))
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusSbchUnlocked, record.Status)
+	require.Equal(t, _unlockTx.TxHash().String(), record.BchUnlockTxHash)
+	require.Equal(t, uint64(127), record.BchUnlockHeight)
+
+	transitions, err := _db.GetStatusTransitions(toHex(_hashLock))
+	require.NoError(t, err)
+	require.Len(t, transitions, 4) // New, BchLocked, SecretRevealed, SbchUnlocked
+	require.Equal(t, "found BCH unlock tx in mempool", transitions[2].Reason)
+}
+
 func TestSbch2Bch_botUnlockSbch(t *testing.T) {
 	_sbchLockTxHash := gethHash32Bytes("sbchlocktx")
 	_val := uint64(12345678)
@@ -1235,6 +1891,8 @@ func TestSbch2Bch_botUnlockSbch(t *testing.T) {
 	}
 
 	_bot.unlockSbchUserDeposits()
+	_sbchCli.mineSentTxs()
+	_bot.checkPendingSbchTxs()
 
 	records, err := _db.getSbch2BchRecordsByStatus(Sbch2BchStatusSbchUnlocked, 100)
 	require.NoError(t, err)
@@ -1251,8 +1909,7 @@ func TestSbch2Bch_botUnlockSbch(t *testing.T) {
 	require.Equal(t, _bchLockTxHash.String(), record0.BchLockTxHash)
 	require.Equal(t, _bchUnlockTxHash.String(), record0.BchUnlockTxHash)
 	require.Equal(t, toHex(_secret), record0.Secret)
-	require.Equal(t, "0000000000000000000000000000000000000000000000006b636f6c68736168",
-		record0.SbchUnlockTxHash)
+	require.Equal(t, toHex(_sbchCli.sentTxs[0].Hash().Bytes()), record0.SbchUnlockTxHash)
 	require.Equal(t, Sbch2BchStatusSbchUnlocked, record0.Status)
 }
 
@@ -1330,6 +1987,155 @@ func TestSbch2Bch_botRefundBch(t *testing.T) {
 	require.Equal(t, Sbch2BchStatusBchRefunded, record0.Status)
 }
 
+func TestSbch2Bch_botRefundBch_utxoSpent(t *testing.T) {
+	_bchLockTxHash := bchHash32("bchlocktx")
+
+	_db := initDB(t, 123, 456)
+	record := createFakeSbch2BchRecord(1)
+	record.HashLock = toHex(gethHash32Bytes("hashlock"))
+	record.BchRecipientPkh = toHex(gethAddrBytes("ubch"))
+	record.TimeLock = 72000
+	require.NoError(t, _db.addSbch2BchRecord(record))
+	require.NoError(t, _db.updateSbch2BchRecord(record.UpdateStatusToBchLocked(_bchLockTxHash.String(), 0)))
+
+	_bchCli := newMockBchClient(122, 129)
+	_bchCli.confirmations[_bchLockTxHash.String()] = 61
+	_bchCli.sendTxErr = errors.New("-25: Missing inputs")
+
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       _bchCli,
+		bchPrivKey:   testBchPrivKey,
+		bchPkh:       testBchPkh,
+		bchAddr:      testBchAddr,
+		errLogQueue:  newErrLogQueue(100),
+	}
+
+	// the refund tx hash is unknown until the spending tx is found
+	_bot.refundLockedBCH(true)
+	record, err := _db.getSbch2BchRecordByBchLockOutPoint(_bchLockTxHash.String(), 0)
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusBchLocked, record.Status)
+	require.Equal(t, "", record.BchRefundTxHash)
+
+	// refunded by the other bot
+	_refundTx := makeBchRefundTx(t, _bchLockTxHash.String())
+	_bchCli.blocks[127] = &wire.MsgBlock{Transactions: []*wire.MsgTx{_refundTx}}
+	_bot.scanBchBlocks()
+
+	record, err = _db.getSbch2BchRecordByBchLockOutPoint(_bchLockTxHash.String(), 0)
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusBchRefunded, record.Status)
+	require.Equal(t, _refundTx.TxHash().String(), record.BchRefundTxHash)
+}
+
+func TestSbch2Bch_handleBchRefundTx(t *testing.T) {
+	_bchLockTxHash1 := bchHash32("bchlock1") // BchLocked, refunded by the other bot
+	_bchLockTxHash2 := bchHash32("bchlock2") // BchRefunded, refunded by this bot
+	_bchLockTxHash3 := bchHash32("bchlock3") // BchRefunded, another refund tx is mined
+
+	_db := initDB(t, 123, 456)
+	_bchCli := newMockBchClient(122, 129)
+	_bchCli.blocks[127] = &wire.MsgBlock{}
+	var refundTxs []*wire.MsgTx
+	for i, lockTxHash := range []chainhash.Hash{_bchLockTxHash1, _bchLockTxHash2, _bchLockTxHash3} {
+		refundTx := makeBchRefundTx(t, lockTxHash.String())
+		refundTxs = append(refundTxs, refundTx)
+		_bchCli.blocks[127].Transactions = append(_bchCli.blocks[127].Transactions, refundTx)
+
+		record := createFakeSbch2BchRecord(uint(i + 1))
+		require.NoError(t, _db.addSbch2BchRecord(record))
+		record.UpdateStatusToBchLocked(lockTxHash.String(), 0)
+		switch lockTxHash {
+		case _bchLockTxHash2:
+			record.UpdateStatusToBchRefunded(refundTx.TxHash().String())
+		case _bchLockTxHash3:
+			record.UpdateStatusToBchRefunded("bchrefund3")
+		}
+		require.NoError(t, _db.updateSbch2BchRecord(record))
+	}
+
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       _bchCli,
+		bchPkh:       testBchPkh,
+		errLogQueue:  newErrLogQueue(100),
+	}
+	_bot.scanBchBlocks()
+
+	for i, lockTxHash := range []chainhash.Hash{_bchLockTxHash1, _bchLockTxHash2, _bchLockTxHash3} {
+		record, err := _db.getSbch2BchRecordByBchLockOutPoint(lockTxHash.String(), 0)
+		require.NoError(t, err)
+		require.Equal(t, Sbch2BchStatusBchRefunded, record.Status)
+		require.Equal(t, refundTxs[i].TxHash().String(), record.BchRefundTxHash)
+	}
+
+	transitions, err := _db.GetStatusTransitions("2")
+	require.NoError(t, err)
+	require.Len(t, transitions, 2) // New, BchRefunded, confirming the refund tx adds no transition
+}
+
+func TestSbch2Bch_userRefundSbch(t *testing.T) {
+	_userEvmAddr := gethAddr("uevm")
+	_hashLock1 := gethHash32("hashlock1") // TooLateToLockBch
+	_hashLock2 := gethHash32("hashlock2") // BchLocked
+	_hashLock3 := gethHash32("hashlock3") // refunded by bot in BCH2SBCH direction
+	_refundTxHash1 := gethHash32("refund1")
+	_refundTxHash2 := gethHash32("refund2")
+	_refundTxHash3 := gethHash32("refund3")
+
+	_db := initDB(t, 123, 456)
+	for i, hashLock := range []gethcmn.Hash{_hashLock1, _hashLock2, _hashLock3} {
+		record := createFakeSbch2BchRecord(uint(i + 1))
+		record.HashLock = toHex(hashLock[:])
+		record.SbchSenderAddr = toHex(_userEvmAddr[:])
+		require.NoError(t, _db.addSbch2BchRecord(record))
+		if hashLock == _hashLock2 {
+			record.UpdateStatusToBchLocked("bchlock", 0)
+		} else {
+			record.Status = Sbch2BchStatusTooLateToLockBch
+		}
+		require.NoError(t, _db.updateSbch2BchRecord(record))
+	}
+
+	_sbchCli := newMockSbchClient(457, 999, 0)
+	_sbchCli.setSwapState(_userEvmAddr, _hashLock1, SwapRefunded)
+	_sbchCli.setSwapState(_userEvmAddr, _hashLock2, SwapRefunded)
+	_sbchCli.setSwapState(testEvmAddr, _hashLock3, SwapRefunded)
+	_sbchCli.logs[458] = []gethtypes.Log{
+		{TxHash: _refundTxHash1, Topics: []gethcmn.Hash{htlcsbch.RefundEventId, _hashLock1}},
+		{TxHash: _refundTxHash2, Topics: []gethcmn.Hash{htlcsbch.RefundEventId, _hashLock2}},
+		{TxHash: _refundTxHash3, Topics: []gethcmn.Hash{htlcsbch.RefundEventId, _hashLock3}},
+	}
+
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		sbchCli:      _sbchCli,
+		sbchAddr:     testEvmAddr,
+		errLogQueue:  newErrLogQueue(100),
+	}
+	_bot.scanSbchEvents()
+
+	record1, err := _db.getSbch2BchRecordByHashLock(toHex(_hashLock1[:]))
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusSbchRefundedByUser, record1.Status)
+	require.Equal(t, toHex(_refundTxHash1[:]), record1.SbchRefundTxHash)
+
+	record2, err := _db.getSbch2BchRecordByHashLock(toHex(_hashLock2[:]))
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusBchLocked, record2.Status)
+	require.Equal(t, toHex(_refundTxHash2[:]), record2.SbchRefundTxHash)
+	require.Len(t, _bot.errLogQueue.removeErrLogs(100), 1)
+
+	record3, err := _db.getSbch2BchRecordByHashLock(toHex(_hashLock3[:]))
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusTooLateToLockBch, record3.Status)
+	require.Equal(t, "", record3.SbchRefundTxHash)
+}
+
 func TestSbch2Bch_handleBchDepositTxS2B(t *testing.T) {
 	_botPkh := testBchPkh
 	_userPkh := gethAddrBytes("user")
@@ -1408,3 +2214,226 @@ func TestSbch2Bch_handleBchDepositTxS2B(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, bchLockedRecords, 1)
 }
+
+func TestBchReorg(t *testing.T) {
+	_botPkh := testBchPkh
+	_userPkh := gethAddrBytes("user")
+	_hashLock := gethHash32Bytes("hash")
+	_timeLock := uint16(100)
+	_penaltyBPS := uint16(500)
+	_evmAddr := gethAddrBytes("evm")
+	_secret := gethHash32Bytes("secret")
+	_hashLock2 := gethcmn.FromHex(secretToHashLock(_secret))
+	_userBchPkh := gethAddrBytes("ubch")
+	_bchLockTxHash := bchHash32("bchlocktx")
+
+	covenant, err := htlcbch.NewMainnetCovenant(_userPkh, _botPkh, _hashLock, _timeLock, _penaltyBPS)
+	require.NoError(t, err)
+	scriptHash, err := covenant.GetRedeemScriptHash()
+	require.NoError(t, err)
+
+	covenant2, err := htlcbch.NewMainnetCovenant(_botPkh, _userBchPkh, _hashLock2, 888, 0)
+	require.NoError(t, err)
+	scriptHash2, err := covenant2.GetRedeemScriptHash()
+	require.NoError(t, err)
+	sigScript2, err := covenant2.BuildUnlockSigScript(_secret)
+	require.NoError(t, err)
+
+	_db := initDB(t, 123, 456)
+	require.NoError(t, _db.addSbch2BchRecord(&Sbch2BchRecord{
+		SbchLockTime:    uint64(time.Now().Unix()),
+		SbchLockTxHash:  toHex(gethHash32Bytes("sbchlocktx")),
+		Value:           12345678,
+		SbchPrice:       1e8,
+		SbchSenderAddr:  gethAddr("uevm").String(),
+		BchRecipientPkh: toHex(_userBchPkh),
+		HashLock:        toHex(_hashLock2),
+		TimeLock:        888,
+		HtlcScriptHash:  toHex(scriptHash2),
+		BchLockTxHash:   _bchLockTxHash.String(),
+		Status:          Sbch2BchStatusBchLocked,
+	}))
+
+	lockTx := &wire.MsgTx{
+		TxIn: []*wire.TxIn{},
+		TxOut: []*wire.TxOut{
+			{
+				Value:    12345678,
+				PkScript: newP2SHPkScript(scriptHash),
+			},
+			{
+				PkScript: newHtlcDepositOpRet(_botPkh, _userPkh, _hashLock, _timeLock, _penaltyBPS, _evmAddr, 1e8),
+			},
+		},
+	}
+	unlockTx := &wire.MsgTx{
+		TxIn: []*wire.TxIn{
+			{
+				PreviousOutPoint: wire.OutPoint{Hash: _bchLockTxHash},
+				SignatureScript:  sigScript2,
+			},
+		},
+		TxOut: []*wire.TxOut{},
+	}
+
+	_bchCli := newMockBchClient(122, 128)
+	_bchCli.blocks[126] = &wire.MsgBlock{Transactions: []*wire.MsgTx{lockTx}}
+	_bchCli.blocks[127] = &wire.MsgBlock{Transactions: []*wire.MsgTx{unlockTx}}
+
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       _bchCli,
+		bchPrivKey:   testBchPrivKey,
+		bchPkh:       _botPkh,
+		bchTimeLock:  _timeLock,
+		penaltyRatio: _penaltyBPS,
+		bchPrice:     1e8,
+		sbchPrice:    1e8,
+		errLogQueue:  newErrLogQueue(100),
+	}
+	_bot.scanBchBlocks()
+
+	b2sRecord, err := _db.getBch2SbchRecordByHashLock(toHex(_hashLock))
+	require.NoError(t, err)
+	require.Equal(t, uint64(126), b2sRecord.BchLockHeight)
+	s2bRecord, err := _db.getSbch2BchRecordByHashLock(toHex(_hashLock2))
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusSecretRevealed, s2bRecord.Status)
+	require.Equal(t, uint64(127), s2bRecord.BchUnlockHeight)
+
+	// blocks 126~128 are orphaned, the lock tx is mined again in block 127
+	_bchCli.reorg(126)
+	_bchCli.hTo = 129
+	_bchCli.blocks[127] = &wire.MsgBlock{Transactions: []*wire.MsgTx{lockTx}}
+	_bchCli.blocks[129] = &wire.MsgBlock{}
+
+	_bot.scanBchBlocks()
+	lastH, err := _db.getLastBchHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(125), lastH)
+	_, err = _db.getBch2SbchRecordByHashLock(toHex(_hashLock))
+	require.ErrorContains(t, err, "record not found")
+	s2bRecord, err = _db.getSbch2BchRecordByHashLock(toHex(_hashLock2))
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusBchLocked, s2bRecord.Status)
+	require.Equal(t, "", s2bRecord.Secret)
+	require.Equal(t, "", s2bRecord.BchUnlockTxHash)
+	require.Equal(t, uint64(0), s2bRecord.BchUnlockHeight)
+
+	_bot.scanBchBlocks()
+	lastH, err = _db.getLastBchHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(129), lastH)
+	b2sRecord, err = _db.getBch2SbchRecordByHashLock(toHex(_hashLock))
+	require.NoError(t, err)
+	require.Equal(t, uint64(127), b2sRecord.BchLockHeight)
+	require.Equal(t, Bch2SbchStatusNew, b2sRecord.Status)
+}
+
+func TestSbchReorg(t *testing.T) {
+	_sbchLockTxHash := gethHash32("sbchlocktx")
+	_userEvmAddr := gethAddr("uevm")
+	_hashLock := gethHash32("hashlock")
+	_val := satsToWeiBytes32(12345678)
+	_userBchPkh := gethAddrBytes("ubch")
+	_createdAt := int64ToBytes32(987600000)
+	_timeLock := int64ToBytes32(987600000 + 12*3600)
+	_penaltyBPS := int64ToBytes32(500)
+
+	_db := initDB(t, 123, 456)
+	require.NoError(t, _db.addBch2SbchRecord(&Bch2SbchRecord{
+		BchLockHeight:  123,
+		BchLockTxHash:  toHex(gethHash32Bytes("bchlock")),
+		Value:          12345678,
+		BchPrice:       1e8,
+		RecipientPkh:   toHex(testBchPkh),
+		SenderPkh:      toHex(gethAddrBytes("user")),
+		HashLock:       toHex(gethHash32Bytes("hashlock2")),
+		TimeLock:       100,
+		SenderEvmAddr:  toHex(gethAddrBytes("evm")),
+		HtlcScriptHash: toHex(gethAddrBytes("htlc")),
+		SbchLockTxHash: toHex(gethHash32Bytes("sbchlocktx2")),
+		SbchLockTxTime: 987600001,
+		Status:         Bch2SbchStatusSbchLocked,
+	}))
+
+	_sbchCli := newMockSbchClient(457, 460, 987500000)
+	_sbchCli.logs[459] = []gethtypes.Log{
+		{
+			BlockNumber: 459,
+			TxHash:      _sbchLockTxHash,
+			Topics: []gethcmn.Hash{
+				htlcsbch.LockEventId,
+				gethAddrToHash32(_userEvmAddr),
+				gethAddrToHash32(testEvmAddr),
+			},
+			Data: joinBytes(_hashLock.Bytes(), _timeLock, _val, rightPad0(_userBchPkh, 12),
+				_createdAt, _penaltyBPS, satsToWeiBytes32(1e8)),
+		},
+	}
+	_bot := &MarketMakerBot{
+		db:                _db,
+		dbQueryLimit:      100,
+		sbchCli:           _sbchCli,
+		sbchAddr:          testEvmAddr,
+		bchPkh:            testBchPkh,
+		sbchTimeLock:      12 * 3600,
+		sbchConfirmations: 2,
+		penaltyRatio:      500,
+		bchPrice:          1e8,
+		sbchPrice:         1e8,
+		errLogQueue:       newErrLogQueue(100),
+	}
+
+	_bot.scanSbchEvents()
+	lastH, err := _db.getLastSbchHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(458), lastH)
+	records, err := _db.getSbch2BchRecordsByStatus(Sbch2BchStatusNew, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 0)
+
+	_sbchCli.hTo = 602
+	_bot.scanSbchEvents()
+	lastH, err = _db.getLastSbchHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(600), lastH)
+	records, err = _db.getSbch2BchRecordsByStatus(Sbch2BchStatusNew, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	// blocks from 459 are orphaned, the lock tx disappears
+	_sbchCli.reorg(459)
+	_sbchCli.hTo = 603
+	_bot.scanSbchEvents()
+	lastH, err = _db.getLastSbchHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(458), lastH)
+	records, err = _db.getSbch2BchRecordsByStatus(Sbch2BchStatusNew, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 0)
+	b2sRecord, err := _db.getBch2SbchRecordByHashLock(toHex(gethHash32Bytes("hashlock2")))
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusNew, b2sRecord.Status)
+	require.Equal(t, "", b2sRecord.SbchLockTxHash)
+
+	_bot.scanSbchEvents()
+	lastH, err = _db.getLastSbchHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(601), lastH)
+}
+
+func makeBchRefundTx(t *testing.T, bchLockTxHash string) *wire.MsgTx {
+	c, err := htlcbch.NewMainnetCovenant(
+		gethAddrBytes("sender"),
+		gethAddrBytes("recipient"),
+		gethHash32Bytes("hashlock"),
+		100,
+		500,
+	)
+	require.NoError(t, err)
+	tx, err := c.MakeRefundTx(gethcmn.FromHex(bchLockTxHash), 0, 1e8, 1)
+	require.NoError(t, err)
+	return tx
+}
@@ -1,33 +1,39 @@
 package bot
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/rand"
 	"net/url"
 	"strings"
-
-	"golang.org/x/exp/slices"
+	"time"
 
 	"github.com/gcash/bchd/btcjson"
 	"github.com/gcash/bchd/chaincfg/chainhash"
 	"github.com/gcash/bchd/rpcclient"
 	"github.com/gcash/bchd/wire"
 	"github.com/gcash/bchutil"
-
-	log "github.com/sirupsen/logrus"
 )
 
 type IBchClient interface {
 	GetBlockCount() (int64, error)
+	GetBlockHash(height int64) (string, error)
 	GetBlock(height int64) (*btcjson.GetBlockVerboseTxResult, error)
-	GetUTXOs(minVal, maxCount int64) ([]btcjson.ListUnspentResult, error)
+	GetUTXOs(params coinSelectionParams, reserved map[string]bool) ([]btcjson.ListUnspentResult, error)
 	GetAllUTXOs() ([]btcjson.ListUnspentResult, error)
 	GetTxConfirmations(txHashHex string) (int64, error)
+	GetMempoolTxIDs() ([]string, error)
+	GetTx(txHashHex string) (*btcjson.TxRawResult, error)
 	SendTx(tx *wire.MsgTx) (*chainhash.Hash, error)
+	EstimateFeeRate() (uint64, error)      // sats/byte
+	GetMempoolMinFeeRate() (uint64, error) // sats/byte
 }
 
 type BchClient struct {
 	client  *rpcclient.Client
 	botAddr bchutil.Address
+	rnd     *rand.Rand // used by coin selection
 }
 
 func NewBchClient(rpcUrlStr string, botAddr bchutil.Address) (*BchClient, error) {
@@ -50,13 +56,25 @@ func NewBchClient(rpcUrlStr string, botAddr bchutil.Address) (*BchClient, error)
 		return nil, err
 	}
 
-	return &BchClient{client: client, botAddr: botAddr}, nil
+	return &BchClient{
+		client:  client,
+		botAddr: botAddr,
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
 }
 
 func (c *BchClient) GetBlockCount() (int64, error) {
 	return c.client.GetBlockCount()
 }
 
+func (c *BchClient) GetBlockHash(height int64) (string, error) {
+	blockHash, err := c.client.GetBlockHash(height)
+	if err != nil {
+		return "", err
+	}
+	return blockHash.String(), nil
+}
+
 func (c *BchClient) GetBlock(height int64) (*btcjson.GetBlockVerboseTxResult, error) {
 	blockHash, err := c.client.GetBlockHash(height)
 	if err != nil {
@@ -76,7 +94,8 @@ func (c *BchClient) GetAllUTXOs() ([]btcjson.ListUnspentResult, error) {
 		minConf, maxConf, []bchutil.Address{c.botAddr})
 }
 
-func (c *BchClient) GetUTXOs(minVal, maxCount int64) ([]btcjson.ListUnspentResult, error) {
+// reserved UTXOs (see utxoKey) are not selected
+func (c *BchClient) GetUTXOs(params coinSelectionParams, reserved map[string]bool) ([]btcjson.ListUnspentResult, error) {
 	minConf := 0 //
 	maxConf := 9999999
 	allUTXOs, err := c.client.ListUnspentMinMaxAddresses(
@@ -85,60 +104,93 @@ func (c *BchClient) GetUTXOs(minVal, maxCount int64) ([]btcjson.ListUnspentResul
 		return nil, err
 	}
 
-	return findUTXOs(allUTXOs, minVal, maxCount)
+	return selectCoins(allUTXOs, reserved, params, c.rnd)
 }
 
-func findUTXOs(allUTXOs []btcjson.ListUnspentResult,
-	minVal, maxCount int64) ([]btcjson.ListUnspentResult, error) {
-
-	// try to find one
-	for _, unspent := range allUTXOs {
-		val := utxoAmtToSats(unspent.Amount)
-		if val >= minVal {
-			return []btcjson.ListUnspentResult{unspent}, nil
-		}
+func (c *BchClient) GetTxConfirmations(txHashHex string) (int64, error) {
+	var txHash chainhash.Hash
+	err := chainhash.Decode(&txHash, txHashHex)
+	if err != nil {
+		return 0, err
 	}
 
-	// sort by value DESC
-	slices.SortFunc(allUTXOs, func(a, b btcjson.ListUnspentResult) bool {
-		return a.Amount > b.Amount
-	})
-
-	var totalAmt int64
-	var utxos []btcjson.ListUnspentResult
-	for _, unspent := range allUTXOs {
-		totalAmt += utxoAmtToSats(unspent.Amount)
-		utxos = append(utxos, unspent)
-		if totalAmt >= minVal {
-			break
-		}
+	tx, err := c.client.GetRawTransactionVerbose(&txHash)
+	if err != nil {
+		return 0, err
 	}
+	return int64(tx.Confirmations), nil
+}
 
-	if totalAmt >= minVal && len(utxos) <= int(maxCount) {
-		return utxos, nil
+func (c *BchClient) GetMempoolTxIDs() ([]string, error) {
+	txHashes, err := c.client.GetRawMempool()
+	if err != nil {
+		return nil, err
 	}
-
-	log.Info("allUTXOs:", toJSON(allUTXOs))
-	return nil, fmt.Errorf(
-		"no available UTXOs (minVal: %d sats, maxCount: %d)", minVal, maxCount)
+	txIDs := make([]string, len(txHashes))
+	for i, txHash := range txHashes {
+		txIDs[i] = txHash.String()
+	}
+	return txIDs, nil
 }
 
-func (c *BchClient) GetTxConfirmations(txHashHex string) (int64, error) {
+func (c *BchClient) GetTx(txHashHex string) (*btcjson.TxRawResult, error) {
 	var txHash chainhash.Hash
 	err := chainhash.Decode(&txHash, txHashHex)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.GetRawTransactionVerbose(&txHash)
+}
+
+func (c *BchClient) SendTx(tx *wire.MsgTx) (*chainhash.Hash, error) {
+	return c.client.SendRawTransaction(tx, false)
+}
+
+// estimatefee of BCHN takes no args
+func (c *BchClient) EstimateFeeRate() (uint64, error) {
+	resp, err := c.client.RawRequest("estimatefee", nil)
 	if err != nil {
 		return 0, err
 	}
+	var feePerKB float64 // in BCH
+	err = json.Unmarshal(resp, &feePerKB)
+	if err != nil {
+		return 0, err
+	}
+	if feePerKB <= 0 {
+		return 0, fmt.Errorf("invalid fee estimation: %f", feePerKB)
+	}
+	return bchPerKBToSatsPerByte(feePerKB), nil
+}
 
-	tx, err := c.client.GetRawTransactionVerbose(&txHash)
+// the larger one of mempoolminfee and minrelaytxfee
+func (c *BchClient) GetMempoolMinFeeRate() (uint64, error) {
+	resp, err := c.client.RawRequest("getmempoolinfo", nil)
 	if err != nil {
 		return 0, err
 	}
-	return int64(tx.Confirmations), nil
+	var info struct {
+		MempoolMinFee float64 `json:"mempoolminfee"` // in BCH/kB
+		MinRelayTxFee float64 `json:"minrelaytxfee"` // in BCH/kB
+	}
+	err = json.Unmarshal(resp, &info)
+	if err != nil {
+		return 0, err
+	}
+	feePerKB := info.MempoolMinFee
+	if info.MinRelayTxFee > feePerKB {
+		feePerKB = info.MinRelayTxFee
+	}
+	return bchPerKBToSatsPerByte(feePerKB), nil
 }
 
-func (c *BchClient) SendTx(tx *wire.MsgTx) (*chainhash.Hash, error) {
-	return c.client.SendRawTransaction(tx, false)
+// rounded up
+func bchPerKBToSatsPerByte(feePerKB float64) uint64 {
+	return uint64(math.Ceil(math.Round(feePerKB*1e8) / 1000))
+}
+
+func utxoKey(txID string, vout uint32) string {
+	return fmt.Sprintf("%s:%d", txID, vout)
 }
 
 func isUtxoSpentErr(err error) bool {
@@ -148,3 +200,10 @@ func isUtxoSpentErr(err error) bool {
 		strings.Contains(msg, "-27: transaction already in block chain") ||
 		strings.Contains(msg, "-25: Missing inputs")
 }
+
+func isTxNotFoundErr(err error) bool {
+	msg := err.Error()
+
+	return strings.Contains(msg, "-5: No such mempool or blockchain transaction") || // BCHN
+		strings.Contains(msg, "-5: No information available about transaction") // bchd
+}
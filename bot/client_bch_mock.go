@@ -7,6 +7,8 @@ import (
 	"github.com/gcash/bchd/btcjson"
 	"github.com/gcash/bchd/chaincfg/chainhash"
 	"github.com/gcash/bchd/wire"
+
+	"github.com/smartbch/atomic-swap-bot/htlcbch"
 )
 
 var _ IBchClient = (*MockBchClient)(nil)
@@ -15,7 +17,14 @@ type MockBchClient struct {
 	hFrom         int64
 	hTo           int64
 	blocks        map[int64]*wire.MsgBlock
+	forks         map[int64]int // used to generate different block hashes after reorg
 	confirmations map[string]int64
+	mempool       []*wire.MsgTx
+	utxos         []btcjson.ListUnspentResult // returned by GetAllUTXOs
+	sendTxErr     error
+	sentTxs       []*wire.MsgTx
+	feeRate       uint64 // returned by EstimateFeeRate, 0 means estimatefee fails
+	minFeeRate    uint64 // returned by GetMempoolMinFeeRate, 0 means getmempoolinfo fails
 }
 
 func newMockBchClient(hFrom, hTo int64) *MockBchClient {
@@ -23,6 +32,7 @@ func newMockBchClient(hFrom, hTo int64) *MockBchClient {
 		hFrom:         hFrom,
 		hTo:           hTo,
 		blocks:        map[int64]*wire.MsgBlock{},
+		forks:         map[int64]int{},
 		confirmations: map[string]int64{},
 	}
 	for h := hFrom; h <= hTo; h++ {
@@ -35,34 +45,99 @@ func (c *MockBchClient) GetBlockCount() (int64, error) {
 	return c.hTo, nil
 }
 
+func (c *MockBchClient) GetBlockHash(height int64) (string, error) {
+	if height < c.hFrom || height > c.hTo {
+		return "", fmt.Errorf("no block#%d", height)
+	}
+	return c.blockHash(height), nil
+}
+
 func (c *MockBchClient) GetBlock(height int64) (*btcjson.GetBlockVerboseTxResult, error) {
 	if height < c.hFrom || height > c.hTo {
 		return nil, fmt.Errorf("no block#%d", height)
 	}
-	return msgBlockToVerbose(c.blocks[height]), nil
+	block := msgBlockToVerbose(c.blocks[height])
+	block.Height = height
+	block.Hash = c.blockHash(height)
+	block.PreviousHash = c.blockHash(height - 1)
+	return block, nil
 }
 
-func (*MockBchClient) GetAllUTXOs() ([]btcjson.ListUnspentResult, error) {
-	return nil, nil
+// replace blocks from height h
+func (c *MockBchClient) reorg(h int64) {
+	for i := h; i <= c.hTo; i++ {
+		c.forks[i]++
+		c.blocks[i] = &wire.MsgBlock{}
+	}
 }
 
-func (c *MockBchClient) GetUTXOs(minVal, maxCount int64) ([]btcjson.ListUnspentResult, error) {
-	return []btcjson.ListUnspentResult{{
+func (c *MockBchClient) blockHash(height int64) string {
+	return chainhash.DoubleHashH([]byte(fmt.Sprintf("%d-%d", height, c.forks[height]))).String()
+}
+
+func (c *MockBchClient) GetAllUTXOs() ([]btcjson.ListUnspentResult, error) {
+	return c.utxos, nil
+}
+
+// always returns the same fake UTXO unless it is reserved
+func (c *MockBchClient) GetUTXOs(params coinSelectionParams, reserved map[string]bool) ([]btcjson.ListUnspentResult, error) {
+	utxo := btcjson.ListUnspentResult{
 		TxID:   gethcmn.Hash{'f', 'a', 'k', 'e', 'u', 't', 'x', 'o'}.String(),
 		Vout:   0,
-		Amount: float64(minVal) * 2 / 1e8,
-	}}, nil
+		Amount: float64(params.outAmt) * 2 / 1e8,
+	}
+	if reserved[utxoKey(utxo.TxID, utxo.Vout)] {
+		return nil, fmt.Errorf("no available UTXOs (outAmt: %d sats, feeRate: %d, maxInputs: %d)",
+			params.outAmt, params.feeRate, params.maxInputs)
+	}
+	return []btcjson.ListUnspentResult{utxo}, nil
 }
 
+// negative confirmations means the tx is not found
 func (c *MockBchClient) GetTxConfirmations(txHashHex string) (int64, error) {
+	if c.confirmations[txHashHex] < 0 {
+		return 0, fmt.Errorf("-5: No such mempool or blockchain transaction")
+	}
 	return c.confirmations[txHashHex], nil
 }
 
+func (c *MockBchClient) GetMempoolTxIDs() ([]string, error) {
+	return cast(c.mempool, func(tx *wire.MsgTx) string { return tx.TxHash().String() }), nil
+}
+
+func (c *MockBchClient) GetTx(txHashHex string) (*btcjson.TxRawResult, error) {
+	for _, tx := range c.mempool {
+		if tx.TxHash().String() == txHashHex {
+			rawTx := msgTxToVerbose(tx)
+			return &rawTx, nil
+		}
+	}
+	return nil, fmt.Errorf("no tx %s", txHashHex)
+}
+
 func (c *MockBchClient) SendTx(tx *wire.MsgTx) (*chainhash.Hash, error) {
+	if c.sendTxErr != nil {
+		return nil, c.sendTxErr
+	}
+	c.sentTxs = append(c.sentTxs, tx)
 	txHash := tx.TxHash()
 	return &txHash, nil
 }
 
+func (c *MockBchClient) EstimateFeeRate() (uint64, error) {
+	if c.feeRate == 0 {
+		return 0, fmt.Errorf("estimatefee failed")
+	}
+	return c.feeRate, nil
+}
+
+func (c *MockBchClient) GetMempoolMinFeeRate() (uint64, error) {
+	if c.minFeeRate == 0 {
+		return 0, fmt.Errorf("getmempoolinfo failed")
+	}
+	return c.minFeeRate, nil
+}
+
 func msgBlockToVerbose(block *wire.MsgBlock) *btcjson.GetBlockVerboseTxResult {
 	return &btcjson.GetBlockVerboseTxResult{
 		Tx: cast(block.Transactions, msgTxToVerbose),
@@ -71,6 +146,7 @@ func msgBlockToVerbose(block *wire.MsgBlock) *btcjson.GetBlockVerboseTxResult {
 func msgTxToVerbose(tx *wire.MsgTx) btcjson.TxRawResult {
 	return btcjson.TxRawResult{
 		Txid: tx.TxHash().String(),
+		Hex:  htlcbch.MsgTxToHex(tx),
 		Vin:  cast(tx.TxIn, txInToVin),
 		Vout: cast(tx.TxOut, txOutToVout),
 	}
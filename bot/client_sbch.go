@@ -5,23 +5,21 @@ import (
 	"crypto/ecdsa"
 	"fmt"
 	"math/big"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/smartbch/atomic-swap-bot/htlcsbch"
 )
 
-const (
-	getReceiptRetryCount = 30
-	getReceiptWaitTime   = 2 * time.Second
-)
-
 const (
 	SwapInvalid = iota
 	SwapLocked
@@ -31,26 +29,53 @@ const (
 
 var _ ISbchClient = (*SbchClient)(nil)
 
+// only the fields used by bot, the hash is returned by node
+// because smartBCH block hash can not be calculated from header fields
+type SbchBlockHeader struct {
+	Number    hexutil.Uint64 `json:"number"`
+	Hash      common.Hash    `json:"hash"`
+	Timestamp hexutil.Uint64 `json:"timestamp"`
+}
+
 type ISbchClient interface {
 	getBlockNumber() (uint64, error)
+	getBlockHeader(h uint64) (*SbchBlockHeader, error)
 	getBlockTimeLatest() (uint64, error)
+	getBotBalance() (*big.Int, error)
 	getTxTime(txHash common.Hash) (uint64, error)
+	getTxReceipt(txHash common.Hash) (*types.Receipt, error)
 	getHtlcLogs(fromBlock, toBlock uint64) ([]types.Log, error)
-	lockSbchToHtlc(userEvmAddr common.Address, hashLock common.Hash, timeLock uint32, amt *big.Int) (*common.Hash, error)
-	unlockSbchFromHtlc(senderAddr common.Address, hashLock common.Hash, secret common.Hash) (*common.Hash, error)
-	refundSbchFromHtlc(senderAddr common.Address, hashLock common.Hash) (*common.Hash, error)
+	getConfirmedNonce() (uint64, error)
+	getPendingNonce() (uint64, error)
+	// txs are returned once they are signed, they are saved by bot before sendTx, receipts are checked by bot
+	lockSbchToHtlc(userEvmAddr common.Address, hashLock common.Hash, timeLock uint32, amt *big.Int) (*types.Transaction, error)
+	unlockSbchFromHtlc(senderAddr common.Address, hashLock common.Hash, secret common.Hash) (*types.Transaction, error)
+	refundSbchFromHtlc(senderAddr common.Address, hashLock common.Hash) (*types.Transaction, error)
+	signReplacementTx(tx *types.Transaction, gasPrice *big.Int) (*types.Transaction, error)
+	sendTx(tx *types.Transaction) error
+	resendTx(tx *types.Transaction) error
+	resetNonce()
+	suggestGasPrice() (*big.Int, error)
+	setGasPrice(gasPrice *big.Int)
 	getSwapState(senderAddr common.Address, hashLock common.Hash) (uint8, error)
 	getMarketMakerInfo(addr common.Address) (*htlcsbch.MarketMakerInfo, error)
+	updateMarketMaker(intro [32]byte, bchPrice, sbchPrice *big.Int) (*types.Transaction, error)
 }
 
 type SbchClient struct {
+	rpcCli   *rpc.Client
 	client   *ethclient.Client
 	timeout  time.Duration
 	privKey  *ecdsa.PrivateKey
 	botAddr  common.Address
 	htlcAddr common.Address
 	chainId  *big.Int
-	gasPrice *big.Int
+	gasPrice *big.Int // used by new txs, updated by sbchGasOracle
+
+	// nonces are assigned locally, so several txs can be in flight,
+	// nextNonce is synced with the pending nonce of the node at startup and after a tx is rejected or dropped
+	nextNonce   uint64
+	nonceSynced bool
 }
 
 func newSbchClient(
@@ -60,14 +85,15 @@ func newSbchClient(
 	gasPrice *big.Int,
 ) (*SbchClient, error) {
 
-	client, err := ethclient.Dial(rawUrl)
+	rpcCli, err := rpc.Dial(rawUrl)
 	if err != nil {
 		return nil, err
 	}
 
 	botAddr := crypto.PubkeyToAddress(privKey.PublicKey)
 	return &SbchClient{
-		client:   client,
+		rpcCli:   rpcCli,
+		client:   ethclient.NewClient(rpcCli),
 		timeout:  timeout,
 		privKey:  privKey,
 		botAddr:  botAddr,
@@ -82,6 +108,18 @@ func (c *SbchClient) getBlockNumber() (uint64, error) {
 	return c.client.BlockNumber(ctx)
 }
 
+func (c *SbchClient) getBlockHeader(h uint64) (*SbchBlockHeader, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+	defer cancelFn()
+
+	var header *SbchBlockHeader
+	err := c.rpcCli.CallContext(ctx, &header, "eth_getBlockByNumber", hexutil.EncodeUint64(h), false)
+	if err == nil && header == nil {
+		err = ethereum.NotFound
+	}
+	return header, err
+}
+
 func (c *SbchClient) getBlockTimeLatest() (uint64, error) {
 	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
 	defer cancelFn()
@@ -92,6 +130,12 @@ func (c *SbchClient) getBlockTimeLatest() (uint64, error) {
 	return header.Time, nil
 }
 
+func (c *SbchClient) getBotBalance() (*big.Int, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+	defer cancelFn()
+	return c.client.BalanceAt(ctx, c.botAddr, nil)
+}
+
 func (c *SbchClient) getTxTime(txHash common.Hash) (uint64, error) {
 	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
 	defer cancelFn()
@@ -170,7 +214,7 @@ func (c *SbchClient) lockSbchToHtlc(
 	hashLock common.Hash,
 	timeLock uint32,
 	amt *big.Int,
-) (*common.Hash, error) {
+) (*types.Transaction, error) {
 	bchAddr := common.Address{}
 	log.Info("lock sBCH to HTLC",
 		", userEvmAddr: ", userEvmAddr.String(),
@@ -191,7 +235,7 @@ func (c *SbchClient) unlockSbchFromHtlc(
 	senderAddr common.Address,
 	hashLock common.Hash,
 	secret common.Hash,
-) (*common.Hash, error) {
+) (*types.Transaction, error) {
 	log.Info("unlock sBCH from HTLC",
 		", hashLock: ", hashLock.String(),
 		", secret: ", secret.String())
@@ -207,7 +251,7 @@ func (c *SbchClient) unlockSbchFromHtlc(
 func (c *SbchClient) refundSbchFromHtlc(
 	senderAddr common.Address,
 	hashLock common.Hash,
-) (*common.Hash, error) {
+) (*types.Transaction, error) {
 	log.Info("refund sBCH from HTLC",
 		", hashLock: ", hashLock.String())
 
@@ -218,13 +262,30 @@ func (c *SbchClient) refundSbchFromHtlc(
 	return c.callHtlc(big.NewInt(0), data)
 }
 
-func (c *SbchClient) callHtlc(val *big.Int, data []byte) (*common.Hash, error) {
+// call updateMarketMaker()
+func (c *SbchClient) updateMarketMaker(
+	intro [32]byte,
+	bchPrice, sbchPrice *big.Int,
+) (*types.Transaction, error) {
+	log.Info("update market maker",
+		", bchPrice: ", bchPrice.String(),
+		", sbchPrice: ", sbchPrice.String())
+
+	data, err := htlcsbch.PackUpdateMarketMaker(intro, bchPrice, sbchPrice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack calldata: %w", err)
+	}
+	return c.callHtlc(big.NewInt(0), data)
+}
+
+// callHtlc signs the tx with the next nonce, the nonce is not used until the tx is sent by sendTx
+func (c *SbchClient) callHtlc(val *big.Int, data []byte) (*types.Transaction, error) {
 	chainID, err := c.getChainId()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chain ID: %w", err)
 	}
 
-	nonce, err := c.getNonce(c.botAddr)
+	nonce, err := c.getNextNonce()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get nonce: %w", err)
 	}
@@ -253,23 +314,32 @@ func (c *SbchClient) callHtlc(val *big.Int, data []byte) (*common.Hash, error) {
 		return nil, fmt.Errorf("failed to sign tx: %w", err)
 	}
 
-	err = c.sendTx(tx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send tx: %w", err)
-	}
-
-	txHash := tx.Hash()
-	log.Info("tx sent, hash: ", txHash.String())
+	log.Info("tx signed, hash: ", tx.Hash().String(), ", nonce: ", nonce)
+	return tx, nil
+}
 
-	receipt, err := c.waitTxReceipt(txHash)
+// sendTx broadcasts a signed tx, the local nonce is increased if the tx uses it
+func (c *SbchClient) sendTx(tx *types.Transaction) error {
+	err := c.broadcastTx(tx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get receipt: %w", err)
+		c.resetNonce() // the local nonce may be out of sync
+		return fmt.Errorf("failed to send tx: %w", err)
 	}
-	if receipt.Status != types.ReceiptStatusSuccessful {
-		return nil, fmt.Errorf("tx failed! tx hash: %s", txHash.String())
+	if c.nonceSynced && tx.Nonce() == c.nextNonce {
+		c.nextNonce++
 	}
 
-	return &txHash, nil
+	log.Info("tx sent, hash: ", tx.Hash().String(), ", nonce: ", tx.Nonce())
+	return nil
+}
+
+// resendTx broadcasts a sent tx again, in case it is dropped by the node
+func (c *SbchClient) resendTx(tx *types.Transaction) error {
+	err := c.broadcastTx(tx)
+	if err != nil && isKnownSbchTxErr(err) {
+		return nil
+	}
+	return err
 }
 
 func (c *SbchClient) getChainId() (*big.Int, error) {
@@ -286,10 +356,73 @@ func (c *SbchClient) getChainId() (*big.Int, error) {
 	return chainId, err
 }
 
-func (c *SbchClient) getNonce(addr common.Address) (uint64, error) {
+// signReplacementTx signs a tx with the same nonce and a higher gas price
+func (c *SbchClient) signReplacementTx(tx *types.Transaction, gasPrice *big.Int) (*types.Transaction, error) {
+	chainID, err := c.getChainId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain ID: %w", err)
+	}
+
+	signer := types.NewEIP155Signer(chainID)
+	newTx, err := types.SignNewTx(c.privKey, signer, &types.LegacyTx{
+		Nonce:    tx.Nonce(),
+		To:       tx.To(),
+		Value:    tx.Value(),
+		Gas:      tx.Gas(),
+		GasPrice: gasPrice,
+		Data:     tx.Data(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign tx: %w", err)
+	}
+	return newTx, nil
+}
+
+func (c *SbchClient) suggestGasPrice() (*big.Int, error) {
 	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
 	defer cancelFn()
-	return c.client.NonceAt(ctx, addr, nil)
+	return c.client.SuggestGasPrice(ctx)
+}
+
+func (c *SbchClient) setGasPrice(gasPrice *big.Int) {
+	c.gasPrice = gasPrice
+}
+
+// returns the nonce of the next tx sent by bot
+func (c *SbchClient) getNextNonce() (uint64, error) {
+	if c.nonceSynced {
+		return c.nextNonce, nil
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+	defer cancelFn()
+	nonce, err := c.client.PendingNonceAt(ctx, c.botAddr)
+	if err != nil {
+		return 0, err
+	}
+	log.Info("sync sBCH nonce: ", nonce)
+	c.nextNonce = nonce
+	c.nonceSynced = true
+	return nonce, nil
+}
+
+// the local nonce will be synced with the node before the next tx is sent
+func (c *SbchClient) resetNonce() {
+	c.nonceSynced = false
+}
+
+// returns the number of bot's txs which are mined
+func (c *SbchClient) getConfirmedNonce() (uint64, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+	defer cancelFn()
+	return c.client.NonceAt(ctx, c.botAddr, nil)
+}
+
+// returns the number of bot's txs which are mined or in mempool
+func (c *SbchClient) getPendingNonce() (uint64, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+	defer cancelFn()
+	return c.client.PendingNonceAt(ctx, c.botAddr)
 }
 
 func (c *SbchClient) estimateGas(msg ethereum.CallMsg) (uint64, error) {
@@ -298,7 +431,7 @@ func (c *SbchClient) estimateGas(msg ethereum.CallMsg) (uint64, error) {
 	return c.client.EstimateGas(ctx, msg)
 }
 
-func (c *SbchClient) sendTx(tx *types.Transaction) error {
+func (c *SbchClient) broadcastTx(tx *types.Transaction) error {
 	ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
 	defer cancelFn()
 	return c.client.SendTransaction(ctx, tx)
@@ -310,16 +443,8 @@ func (c *SbchClient) getTxReceipt(txHash common.Hash) (*types.Receipt, error) {
 	return c.client.TransactionReceipt(ctx, txHash)
 }
 
-func (c *SbchClient) waitTxReceipt(txHash common.Hash) (receipt *types.Receipt, err error) {
-	log.Info("get tx receipt, hash: ", txHash.String())
-	for i := 0; i < getReceiptRetryCount; i++ {
-		receipt, err = c.getTxReceipt(txHash)
-		if err == ethereum.NotFound {
-			log.Info("tx receipt not ready, wait 2 seconds ...")
-			time.Sleep(getReceiptWaitTime)
-			continue
-		}
-		return
-	}
-	return
+func isKnownSbchTxErr(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "already known") ||
+		strings.Contains(msg, "known transaction")
 }
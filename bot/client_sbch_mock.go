@@ -4,28 +4,49 @@ import (
 	"fmt"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/smartbch/atomic-swap-bot/htlcsbch"
 )
 
 type MockSbchClient struct {
-	ts      uint64
-	hFrom   uint64
-	hTo     uint64
-	logs    map[uint64][]types.Log
-	txTimes map[common.Hash]uint64
+	ts       uint64
+	hFrom    uint64
+	hTo      uint64
+	logs     map[uint64][]types.Log
+	txTimes  map[common.Hash]uint64
+	receipts map[common.Hash]*types.Receipt
+	forks    map[uint64]int // used to generate different block hashes after reorg
+	states   map[common.Address]map[common.Hash]uint8
+	mmInfo   *htlcsbch.MarketMakerInfo
+	balance  *big.Int
+
+	nonce          uint64 // nonce of the next tx
+	confirmedNonce uint64
+	pendingNonce   uint64 // nonce after the txs in mempool, confirmedNonce is used if it is smaller
+	sentTxs        []*types.Transaction
+	resentTxs      []*types.Transaction
+	sendTxErr      error
+	sendTxErrOnce  error // returned by the next sendTx only
+	gasPrice       *big.Int
+	suggestedPrice *big.Int
 }
 
 func newMockSbchClient(hFrom, hTo, ts uint64) *MockSbchClient {
 	cli := &MockSbchClient{
-		ts:      ts,
-		hFrom:   hFrom,
-		hTo:     hTo,
-		logs:    map[uint64][]types.Log{},
-		txTimes: map[common.Hash]uint64{},
+		ts:       ts,
+		hFrom:    hFrom,
+		hTo:      hTo,
+		logs:     map[uint64][]types.Log{},
+		txTimes:  map[common.Hash]uint64{},
+		receipts: map[common.Hash]*types.Receipt{},
+		forks:    map[uint64]int{},
+		states:   map[common.Address]map[common.Hash]uint8{},
 	}
 	return cli
 }
@@ -34,6 +55,32 @@ func (c *MockSbchClient) getBlockNumber() (uint64, error) {
 	return c.hTo, nil
 }
 
+func (c *MockSbchClient) getBlockHeader(h uint64) (*SbchBlockHeader, error) {
+	if h < c.hFrom || h > c.hTo {
+		return nil, ethereum.NotFound
+	}
+	return &SbchBlockHeader{
+		Number:    hexutil.Uint64(h),
+		Hash:      crypto.Keccak256Hash([]byte(fmt.Sprintf("%d-%d", h, c.forks[h]))),
+		Timestamp: hexutil.Uint64(c.ts),
+	}, nil
+}
+
+// replace blocks from height h
+func (c *MockSbchClient) reorg(h uint64) {
+	for i := h; i <= c.hTo; i++ {
+		c.forks[i]++
+		delete(c.logs, i)
+	}
+}
+
+func (c *MockSbchClient) getTxReceipt(txHash common.Hash) (*types.Receipt, error) {
+	if receipt, ok := c.receipts[txHash]; ok {
+		return receipt, nil
+	}
+	return nil, ethereum.NotFound
+}
+
 func (c *MockSbchClient) getBlockTimeLatest() (uint64, error) {
 	return c.ts, nil
 }
@@ -54,40 +101,162 @@ func (c *MockSbchClient) getHtlcLogs(fromBlock, toBlock uint64) ([]types.Log, er
 	return logs, nil
 }
 
+func (c *MockSbchClient) getConfirmedNonce() (uint64, error) {
+	return c.confirmedNonce, nil
+}
+
+func (c *MockSbchClient) getPendingNonce() (uint64, error) {
+	if c.pendingNonce < c.confirmedNonce {
+		return c.confirmedNonce, nil
+	}
+	return c.pendingNonce, nil
+}
+
 func (c *MockSbchClient) lockSbchToHtlc(
 	userEvmAddr common.Address,
 	hashLock common.Hash,
 	timeLock uint32,
 	amt *big.Int,
-) (*common.Hash, error) {
+) (*types.Transaction, error) {
 	log.Info("lockSbchToHtlc:", userEvmAddr, hashLock, timeLock, amt)
-	txHash := common.BytesToHash(reverseBytes(hashLock[:]))
-	return &txHash, nil
+	return c.signHtlcTx(amt, append([]byte("lock"), hashLock[:]...))
 }
 
 func (c *MockSbchClient) unlockSbchFromHtlc(
 	senderAddr common.Address,
 	hashLock common.Hash,
 	secret common.Hash,
-) (*common.Hash, error) {
+) (*types.Transaction, error) {
 	log.Info("unlockSbchFromHtlc:", senderAddr, hashLock, secret)
-	txHash := common.BytesToHash(reverseBytes(hashLock[:]))
-	return &txHash, nil
+	return c.signHtlcTx(big.NewInt(0), append([]byte("unlock"), hashLock[:]...))
 }
 
 func (c *MockSbchClient) refundSbchFromHtlc(
 	senderAddr common.Address,
 	hashLock common.Hash,
-) (*common.Hash, error) {
+) (*types.Transaction, error) {
 	log.Info("refundSbchFromHtlc:", senderAddr, hashLock)
-	txHash := common.BytesToHash(reverseBytes(hashLock[:]))
-	return &txHash, nil
+	return c.signHtlcTx(big.NewInt(0), append([]byte("refund"), hashLock[:]...))
+}
+
+// the tx is not signed, since the mock client does not check signatures
+func (c *MockSbchClient) signHtlcTx(val *big.Int, data []byte) (*types.Transaction, error) {
+	tx := types.NewTx(&types.LegacyTx{
+		Nonce:    c.nonce,
+		Value:    val,
+		GasPrice: c.gasPrice,
+		Data:     data,
+	})
+	return tx, nil
+}
+
+func (c *MockSbchClient) sendTx(tx *types.Transaction) error {
+	if err := c.sendTxErrOnce; err != nil {
+		c.sendTxErrOnce = nil
+		c.resetNonce()
+		return err
+	}
+	if c.sendTxErr != nil {
+		c.resetNonce()
+		return c.sendTxErr
+	}
+	if tx.Nonce() == c.nonce {
+		c.nonce++
+	}
+	c.sentTxs = append(c.sentTxs, tx)
+	return nil
+}
+
+func (c *MockSbchClient) resendTx(tx *types.Transaction) error {
+	if c.sendTxErr != nil {
+		return c.sendTxErr
+	}
+	c.resentTxs = append(c.resentTxs, tx)
+	return nil
+}
+
+func (c *MockSbchClient) signReplacementTx(tx *types.Transaction, gasPrice *big.Int) (*types.Transaction, error) {
+	newTx := types.NewTx(&types.LegacyTx{
+		Nonce:    tx.Nonce(),
+		Value:    tx.Value(),
+		GasPrice: gasPrice,
+		Data:     tx.Data(),
+	})
+	return newTx, nil
+}
+
+func (c *MockSbchClient) suggestGasPrice() (*big.Int, error) {
+	if c.suggestedPrice == nil {
+		return nil, fmt.Errorf("gas price not available")
+	}
+	return c.suggestedPrice, nil
+}
+
+func (c *MockSbchClient) setGasPrice(gasPrice *big.Int) {
+	c.gasPrice = gasPrice
+}
+
+func (c *MockSbchClient) resetNonce() {
+	c.nonce = c.confirmedNonce
+}
+
+// mineTx adds the receipt of a sent tx
+func (c *MockSbchClient) mineTx(tx *types.Transaction, status uint64) {
+	if c.receipts == nil {
+		c.receipts = map[common.Hash]*types.Receipt{}
+	}
+	if c.txTimes == nil {
+		c.txTimes = map[common.Hash]uint64{}
+	}
+	c.receipts[tx.Hash()] = &types.Receipt{Status: status, TxHash: tx.Hash()}
+	c.txTimes[tx.Hash()] = c.ts
+	if tx.Nonce() >= c.confirmedNonce {
+		c.confirmedNonce = tx.Nonce() + 1
+	}
+}
+
+// mineSentTxs adds successful receipts of all sent txs
+func (c *MockSbchClient) mineSentTxs() {
+	for _, tx := range c.sentTxs {
+		if _, ok := c.receipts[tx.Hash()]; !ok {
+			c.mineTx(tx, types.ReceiptStatusSuccessful)
+		}
+	}
 }
 
 func (c *MockSbchClient) getSwapState(senderAddr common.Address, hashLock common.Hash) (uint8, error) {
-	panic("not implemented")
+	return c.states[senderAddr][hashLock], nil
+}
+
+func (c *MockSbchClient) setSwapState(senderAddr common.Address, hashLock common.Hash, state uint8) {
+	if c.states[senderAddr] == nil {
+		c.states[senderAddr] = map[common.Hash]uint8{}
+	}
+	c.states[senderAddr][hashLock] = state
+}
+
+func (c *MockSbchClient) getBotBalance() (*big.Int, error) {
+	if c.balance == nil {
+		return big.NewInt(0), nil
+	}
+	return new(big.Int).Set(c.balance), nil
 }
 
 func (c *MockSbchClient) getMarketMakerInfo(addr common.Address) (*htlcsbch.MarketMakerInfo, error) {
-	panic("not implemented")
+	if c.mmInfo == nil {
+		panic("not implemented")
+	}
+	mm := *c.mmInfo
+	return &mm, nil
+}
+
+func (c *MockSbchClient) updateMarketMaker(
+	intro [32]byte,
+	bchPrice, sbchPrice *big.Int,
+) (*types.Transaction, error) {
+	log.Info("updateMarketMaker:", intro, bchPrice, sbchPrice)
+	c.mmInfo.Intro = intro
+	c.mmInfo.BchPrice = bchPrice
+	c.mmInfo.SbchPrice = sbchPrice
+	return c.signHtlcTx(big.NewInt(0), crypto.Keccak256(bchPrice.Bytes(), sbchPrice.Bytes()))
 }
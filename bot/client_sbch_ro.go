@@ -9,6 +9,11 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// ISbchClientRO only queries the chain, it is also used by the HTTP server and the metrics collector
+type ISbchClientRO interface {
+	getBotBalance() (*big.Int, error)
+}
+
 type SbchClientRO struct {
 	client  *ethclient.Client
 	timeout time.Duration
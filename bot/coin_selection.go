@@ -0,0 +1,264 @@
+package bot
+
+import (
+	"fmt"
+	"math/rand"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/gcash/bchd/btcjson"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	bchLockTxMaxInputs    = 10
+	bnbMaxTries           = 100000
+	knapsackMaxIterations = 1000
+)
+
+// all values are in sats, all sizes are in bytes
+type coinSelectionParams struct {
+	outAmt     int64 // value of the HTLC output
+	feeRate    int64 // sats/byte
+	baseTxSize int64 // size of the tx without inputs and change output
+	inputSize  int64
+	changeSize int64
+	dustAmt    int64 // change is paid as miner fee if its value is not larger than this
+	maxInputs  int
+}
+
+// the selected UTXOs must cover this (minus input fees) if there is no change output
+func (p coinSelectionParams) target() int64 {
+	return p.outAmt + p.baseTxSize*p.feeRate
+}
+
+// adding a change output is not cheaper than paying the excess as miner fee
+func (p coinSelectionParams) costOfChange() int64 {
+	return p.changeSize*p.feeRate + p.dustAmt
+}
+
+type coinCandidate struct {
+	utxo     btcjson.ListUnspentResult
+	effValue int64 // value minus the miner fee for spending it
+}
+
+// selectCoins tries branch-and-bound first to find a change-less solution,
+// and falls back to knapsack which leaves a change output.
+// Reserved UTXOs (see utxoKey) and UTXOs which can not pay their own miner fee are not selected.
+func selectCoins(allUTXOs []btcjson.ListUnspentResult, reserved map[string]bool,
+	params coinSelectionParams, rnd *rand.Rand) ([]btcjson.ListUnspentResult, error) {
+
+	inputFee := params.inputSize * params.feeRate
+	coins := make([]coinCandidate, 0, len(allUTXOs))
+	var totalEffValue int64
+	for _, utxo := range allUTXOs {
+		if reserved[utxoKey(utxo.TxID, utxo.Vout)] {
+			continue
+		}
+		effValue := utxoAmtToSats(utxo.Amount) - inputFee
+		if effValue <= 0 {
+			continue
+		}
+		coins = append(coins, coinCandidate{utxo: utxo, effValue: effValue})
+		totalEffValue += effValue
+	}
+
+	target := params.target()
+	if totalEffValue >= target {
+		// sort by effective value DESC
+		slices.SortFunc(coins, func(a, b coinCandidate) bool {
+			return a.effValue > b.effValue
+		})
+
+		selected := selectCoinsBnB(coins, target, params.costOfChange(), params.maxInputs)
+		if selected == nil {
+			selected = selectCoinsKnapsack(coins, target+params.costOfChange()+1, rnd)
+		}
+		if selected == nil {
+			selected = selectCoinsKnapsack(coins, target, rnd)
+		}
+		if len(selected) > params.maxInputs {
+			selected = selectCoinsGreedy(coins, target)
+		}
+		if selected != nil && len(selected) <= params.maxInputs {
+			return cast(selected, func(c coinCandidate) btcjson.ListUnspentResult { return c.utxo }), nil
+		}
+	}
+
+	log.Info("allUTXOs:", toJSON(allUTXOs))
+	return nil, fmt.Errorf("no available UTXOs (outAmt: %d sats, feeRate: %d, maxInputs: %d)",
+		params.outAmt, params.feeRate, params.maxInputs)
+}
+
+// coins must be sorted by effective value DESC
+type bnbSearch struct {
+	coins      []coinCandidate
+	remaining  []int64 // remaining[i] is the sum of coins[i:]
+	target     int64
+	upperBound int64
+	maxInputs  int
+	tries      int
+
+	selected   []int
+	best       []int
+	bestExcess int64
+}
+
+// selectCoinsBnB searches for the subset whose effective value is within [target, target+costOfChange],
+// the one with the least excess (and then the fewest inputs) wins.
+func selectCoinsBnB(coins []coinCandidate, target, costOfChange int64, maxInputs int) []coinCandidate {
+	s := &bnbSearch{
+		coins:      coins,
+		remaining:  make([]int64, len(coins)+1),
+		target:     target,
+		upperBound: target + costOfChange,
+		maxInputs:  maxInputs,
+	}
+	for i := len(coins) - 1; i >= 0; i-- {
+		s.remaining[i] = s.remaining[i+1] + coins[i].effValue
+	}
+	s.search(0, 0)
+	if s.best == nil {
+		return nil
+	}
+	return cast(s.best, func(i int) coinCandidate { return coins[i] })
+}
+
+func (s *bnbSearch) search(i int, sum int64) {
+	if s.tries >= bnbMaxTries || (s.best != nil && s.bestExcess == 0) {
+		return
+	}
+	s.tries++
+
+	if sum > s.upperBound {
+		return
+	}
+	if sum >= s.target {
+		excess := sum - s.target
+		if s.best == nil || excess < s.bestExcess ||
+			(excess == s.bestExcess && len(s.selected) < len(s.best)) {
+			s.best = append([]int{}, s.selected...)
+			s.bestExcess = excess
+		}
+		return
+	}
+	if i == len(s.coins) || sum+s.remaining[i] < s.target || len(s.selected) >= s.maxInputs {
+		return
+	}
+
+	// include coins[i]
+	s.selected = append(s.selected, i)
+	s.search(i+1, sum+s.coins[i].effValue)
+	s.selected = s.selected[:len(s.selected)-1]
+
+	// exclude coins[i], and skip the following coins with the same value,
+	// including them leads to the same sums which have been explored
+	j := i + 1
+	for j < len(s.coins) && s.coins[j].effValue == s.coins[i].effValue {
+		j++
+	}
+	s.search(j, sum)
+}
+
+// selectCoinsKnapsack is a port of the knapsack solver of Bitcoin Core:
+// the smallest single coin which covers the target competes with a random-approximated best subset of smaller coins.
+func selectCoinsKnapsack(coins []coinCandidate, target int64, rnd *rand.Rand) []coinCandidate {
+	shuffled := append([]coinCandidate{}, coins...)
+	rnd.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	var applicable []coinCandidate
+	var lowestLarger *coinCandidate
+	var totalLower int64
+	for i := range shuffled {
+		coin := shuffled[i]
+		if coin.effValue == target {
+			return []coinCandidate{coin}
+		}
+		if coin.effValue < target {
+			applicable = append(applicable, coin)
+			totalLower += coin.effValue
+		} else if lowestLarger == nil || coin.effValue < lowestLarger.effValue {
+			lowestLarger = &shuffled[i]
+		}
+	}
+
+	if totalLower == target {
+		return applicable
+	}
+	if totalLower < target {
+		if lowestLarger == nil {
+			return nil
+		}
+		return []coinCandidate{*lowestLarger}
+	}
+
+	// sort by effective value DESC
+	slices.SortStableFunc(applicable, func(a, b coinCandidate) bool {
+		return a.effValue > b.effValue
+	})
+	included, bestValue := approximateBestSubset(applicable, totalLower, target, rnd)
+	if lowestLarger != nil && bestValue != target && lowestLarger.effValue <= bestValue {
+		return []coinCandidate{*lowestLarger}
+	}
+
+	var selected []coinCandidate
+	for i, coin := range applicable {
+		if included[i] {
+			selected = append(selected, coin)
+		}
+	}
+	return selected
+}
+
+func approximateBestSubset(coins []coinCandidate, total, target int64,
+	rnd *rand.Rand) (best []bool, bestValue int64) {
+
+	best = make([]bool, len(coins))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue = total
+
+	included := make([]bool, len(coins))
+	for rep := 0; rep < knapsackMaxIterations && bestValue != target; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		var sum int64
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, coin := range coins {
+				// the solver tries random subsets in the first pass,
+				// and fills up the unselected coins in the second pass
+				if (pass == 0 && rnd.Intn(2) == 0) || (pass == 1 && !included[i]) {
+					sum += coin.effValue
+					included[i] = true
+					if sum >= target {
+						reachedTarget = true
+						if sum < bestValue {
+							bestValue = sum
+							copy(best, included)
+						}
+						sum -= coin.effValue
+						included[i] = false
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// selectCoinsGreedy picks the largest coins, it minimizes the number of inputs
+func selectCoinsGreedy(coins []coinCandidate, target int64) []coinCandidate {
+	var sum int64
+	for i, coin := range coins {
+		sum += coin.effValue
+		if sum >= target {
+			return coins[:i+1]
+		}
+	}
+	return nil
+}
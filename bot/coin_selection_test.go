@@ -0,0 +1,177 @@
+package bot
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/exp/slices"
+
+	gethcmn "github.com/ethereum/go-ethereum/common"
+	"github.com/gcash/bchd/btcjson"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartbch/atomic-swap-bot/htlcbch"
+)
+
+func newTestCoinSelectionParams(outAmt int64) coinSelectionParams {
+	return coinSelectionParams{
+		outAmt:     outAmt,
+		feeRate:    1,
+		baseTxSize: 168,
+		inputSize:  htlcbch.P2PKHInputSize,
+		changeSize: htlcbch.P2PKHOutputSize,
+		dustAmt:    htlcbch.DustAmt,
+		maxInputs:  5,
+	}
+}
+
+func newTestUTXO(i int, sats int64) btcjson.ListUnspentResult {
+	return btcjson.ListUnspentResult{
+		TxID:   fmt.Sprintf("tx%d", i),
+		Vout:   uint32(i),
+		Amount: satsToUtxoAmt(uint64(sats)),
+	}
+}
+
+func getTxIDs(utxos []btcjson.ListUnspentResult) []string {
+	txIDs := cast(utxos, func(utxo btcjson.ListUnspentResult) string { return utxo.TxID })
+	slices.Sort(txIDs)
+	return txIDs
+}
+
+func TestSelectCoins(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	params := newTestCoinSelectionParams(100000)
+	allUTXOs := []btcjson.ListUnspentResult{
+		newTestUTXO(0, 1_0000_0000),
+		newTestUTXO(1, 50000+149),
+		newTestUTXO(2, 30000+149),
+		newTestUTXO(3, 50168+149),
+		newTestUTXO(4, 100),
+	}
+
+	// change-less, the target is 100168
+	utxos, err := selectCoins(allUTXOs, nil, params, rnd)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tx1", "tx3"}, getTxIDs(utxos))
+
+	// excess within cost of change
+	allUTXOs[3] = newTestUTXO(3, 50168+149+500)
+	utxos, err = selectCoins(allUTXOs, nil, params, rnd)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tx1", "tx3"}, getTxIDs(utxos))
+
+	// excess is too large for change-less tx, knapsack leaves change
+	allUTXOs[3] = newTestUTXO(3, 50168+149+1000)
+	utxos, err = selectCoins(allUTXOs, nil, params, rnd)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tx1", "tx3"}, getTxIDs(utxos))
+
+	// reserved UTXOs are skipped
+	reserved := map[string]bool{utxoKey("tx1", 1): true}
+	utxos, err = selectCoins(allUTXOs, reserved, params, rnd)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tx0"}, getTxIDs(utxos))
+
+	// UTXOs which can not pay their own miner fee are skipped
+	reserved = map[string]bool{utxoKey("tx0", 0): true}
+	params.outAmt = 50000 + 30000 + 51168 - 168 + 1
+	_, err = selectCoins(allUTXOs, reserved, params, rnd)
+	require.ErrorContains(t, err, "no available UTXOs (outAmt: 131001 sats, feeRate: 1, maxInputs: 5)")
+
+	// too many inputs
+	params.outAmt = 100000
+	params.maxInputs = 1
+	_, err = selectCoins(allUTXOs, reserved, params, rnd)
+	require.ErrorContains(t, err, "no available UTXOs (outAmt: 100000 sats, feeRate: 1, maxInputs: 1)")
+}
+
+func TestSelectCoinsBnB(t *testing.T) {
+	coins := cast([]int64{9, 7, 5, 5, 3, 1}, func(v int64) coinCandidate {
+		return coinCandidate{effValue: v}
+	})
+	getValues := func(coins []coinCandidate) []int64 {
+		return cast(coins, func(c coinCandidate) int64 { return c.effValue })
+	}
+
+	require.Equal(t, []int64{9, 1}, getValues(selectCoinsBnB(coins, 10, 0, 5)))
+	require.Equal(t, []int64{9, 7, 5, 1}, getValues(selectCoinsBnB(coins, 22, 0, 5)))
+	require.Equal(t, []int64{9, 7, 3, 1}, getValues(selectCoinsBnB(coins, 20, 0, 4)))
+	require.Equal(t, []int64{9, 5}, getValues(selectCoinsBnB(coins, 14, 2, 2)))
+	require.Nil(t, selectCoinsBnB(coins, 31, 0, 5))
+	require.Nil(t, selectCoinsBnB(coins, 20, 0, 2))
+}
+
+func TestSelectCoins_random(t *testing.T) {
+	covenant, err := htlcbch.NewMainnetCovenant(testBchPkh, gethAddrBytes("user"),
+		gethHash32Bytes("hash"), 100, 0)
+	require.NoError(t, err)
+	baseTxSize, err := covenant.GetLockTxBaseSize()
+	require.NoError(t, err)
+
+	rnd := rand.New(rand.NewSource(12345))
+	nChangeLess := 0
+	for round := 0; round < 500; round++ {
+		params := newTestCoinSelectionParams(1000 + rnd.Int63n(1_0000_0000))
+		params.feeRate = 1 + rnd.Int63n(3)
+		params.baseTxSize = baseTxSize
+		params.maxInputs = 1 + rnd.Intn(10)
+
+		var allUTXOs []btcjson.ListUnspentResult
+		reserved := map[string]bool{}
+		for i, n := 0, 1+rnd.Intn(30); i < n; i++ {
+			// values are spread over several orders of magnitude
+			sats := 100 + rnd.Int63n(int64(1)<<(10+rnd.Intn(18)))
+			allUTXOs = append(allUTXOs, newTestUTXO(i, sats))
+			if rnd.Intn(5) == 0 {
+				reserved[utxoKey(fmt.Sprintf("tx%d", i), uint32(i))] = true
+			}
+		}
+
+		// the largest free UTXOs tell whether a solution exists
+		var effValues []int64
+		for _, utxo := range allUTXOs {
+			effValue := utxoAmtToSats(utxo.Amount) - params.inputSize*params.feeRate
+			if !reserved[utxoKey(utxo.TxID, utxo.Vout)] && effValue > 0 {
+				effValues = append(effValues, effValue)
+			}
+		}
+		slices.SortFunc(effValues, func(a, b int64) bool { return a > b })
+		var maxEffValue int64
+		for i := 0; i < len(effValues) && i < params.maxInputs; i++ {
+			maxEffValue += effValues[i]
+		}
+
+		utxos, err := selectCoins(allUTXOs, reserved, params, rnd)
+		if maxEffValue < params.target() {
+			require.Error(t, err, round)
+			continue
+		}
+		require.NoError(t, err, round)
+		require.LessOrEqual(t, len(utxos), params.maxInputs, round)
+
+		var effValue int64
+		for _, utxo := range utxos {
+			require.False(t, reserved[utxoKey(utxo.TxID, utxo.Vout)], round)
+			effValue += utxoAmtToSats(utxo.Amount) - params.inputSize*params.feeRate
+		}
+		require.GreaterOrEqual(t, effValue, params.target(), round)
+
+		// the selected UTXOs are enough to make lock tx
+		inputs := cast(utxos, func(utxo btcjson.ListUnspentResult) htlcbch.InputInfo {
+			return htlcbch.InputInfo{
+				TxID:   gethcmn.FromHex(gethHash32(utxo.TxID).String()),
+				Vout:   utxo.Vout,
+				Amount: utxoAmtToSats(utxo.Amount),
+			}
+		})
+		tx, err := covenant.MakeLockTx(testBchPrivKey, inputs, params.outAmt, uint64(params.feeRate))
+		require.NoError(t, err, round)
+		if effValue <= params.target()+params.costOfChange() {
+			require.Len(t, tx.TxOut, 2, round)
+			nChangeLess++
+		}
+	}
+	require.Greater(t, nChangeLess, 0)
+}
@@ -0,0 +1,259 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	gethcmn "github.com/ethereum/go-ethereum/common"
+	"github.com/gcash/bchd/btcjson"
+	"github.com/gcash/bchd/chaincfg/chainhash"
+	"github.com/gcash/bchd/wire"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+
+	"github.com/smartbch/atomic-swap-bot/htlcbch"
+)
+
+type UtxoConsolidatorConfig struct {
+	TargetValue   uint64        // in sats, UTXOs smaller than this are merged into coins of about this value
+	MaxUtxoCount  int           // consolidate if there are more free UTXOs than this
+	MaxInputs     int           // max inputs of each consolidation tx
+	FeeRate       uint64        // sats/byte
+	MaxMempoolTxs int           // consolidate only if BCH mempool has no more txs than this (low-fee period), 0 means no limit
+	CheckInterval time.Duration // min interval between two checks
+}
+
+// UtxoConsolidator merges small UTXOs at the bot's P2PKH address, only used by master bot
+type UtxoConsolidator struct {
+	UtxoConsolidatorConfig
+
+	lastCheckedAt time.Time
+}
+
+func NewUtxoConsolidator(cfg UtxoConsolidatorConfig) (*UtxoConsolidator, error) {
+	if cfg.TargetValue <= htlcbch.DustAmt {
+		return nil, fmt.Errorf("target value is too small: %d", cfg.TargetValue)
+	}
+	if cfg.MaxUtxoCount <= 0 {
+		return nil, fmt.Errorf("invalid max UTXO count: %d", cfg.MaxUtxoCount)
+	}
+	if cfg.MaxInputs < 2 {
+		return nil, fmt.Errorf("invalid max inputs: %d", cfg.MaxInputs)
+	}
+	if cfg.FeeRate == 0 {
+		return nil, fmt.Errorf("invalid fee rate: %d", cfg.FeeRate)
+	}
+	return &UtxoConsolidator{
+		UtxoConsolidatorConfig: cfg,
+	}, nil
+}
+
+// isFragmented returns true if there are too many UTXOs,
+// or the largest UTXOs which can be spent by one lock tx are not enough for the max swap value
+func (uc *UtxoConsolidator) isFragmented(freeUTXOs []btcjson.ListUnspentResult, maxSwapVal uint64) bool {
+	if len(freeUTXOs) > uc.MaxUtxoCount {
+		return true
+	}
+
+	values := cast(freeUTXOs, func(utxo btcjson.ListUnspentResult) int64 {
+		return utxoAmtToSats(utxo.Amount)
+	})
+	slices.SortFunc(values, func(a, b int64) bool { return a > b })
+	var total, spendable int64
+	for i, val := range values {
+		total += val
+		if i < bchLockTxMaxInputs {
+			spendable += val
+		}
+	}
+	needed := int64(maxSwapVal + bchLockFeeReserve)
+	return spendable < needed && total >= needed
+}
+
+// pickUTXOs returns the smallest confirmed UTXOs which are worth merging
+func (uc *UtxoConsolidator) pickUTXOs(freeUTXOs []btcjson.ListUnspentResult) []btcjson.ListUnspentResult {
+	inputFee := int64(htlcbch.P2PKHInputSize * uc.FeeRate)
+	var utxos []btcjson.ListUnspentResult
+	for _, utxo := range freeUTXOs {
+		val := utxoAmtToSats(utxo.Amount)
+		if utxo.Confirmations > 0 && val < int64(uc.TargetValue) && val > inputFee {
+			utxos = append(utxos, utxo)
+		}
+	}
+
+	// sort by value ASC
+	slices.SortFunc(utxos, func(a, b btcjson.ListUnspentResult) bool {
+		return a.Amount < b.Amount
+	})
+	if len(utxos) > uc.MaxInputs {
+		utxos = utxos[:uc.MaxInputs]
+	}
+	return utxos
+}
+
+// merge small UTXOs if they are fragmented and the BCH network is not busy
+func (bot *MarketMakerBot) consolidateUtxos() {
+	uc := bot.consolidator
+	now := time.Now()
+	if now.Sub(uc.lastCheckedAt) < uc.CheckInterval {
+		return
+	}
+	uc.lastCheckedAt = now
+
+	log.Info("check BCH UTXO fragmentation ...")
+	freeUTXOs, ok := bot.getFreeUtxos()
+	if !ok {
+		return
+	}
+	if !uc.isFragmented(freeUTXOs, bot.maxSwapVal) {
+		log.Info("BCH UTXOs are not fragmented, count: ", len(freeUTXOs))
+		return
+	}
+
+	if uc.MaxMempoolTxs > 0 {
+		mempoolTxIDs, err := bot.bchCli.GetMempoolTxIDs()
+		if err != nil {
+			bot.logError("RPC error, failed to get BCH mempool txs: ", err)
+			return
+		}
+		if len(mempoolTxIDs) > uc.MaxMempoolTxs {
+			log.Info("BCH mempool is busy, postpone consolidation, txs: ", len(mempoolTxIDs))
+			return
+		}
+	}
+
+	utxos := uc.pickUTXOs(freeUTXOs)
+	if len(utxos) < 2 {
+		log.Info("no UTXOs to consolidate")
+		return
+	}
+
+	var totalVal int64
+	inputs := toInputInfos(utxos)
+	for _, input := range inputs {
+		totalVal += input.Amount
+	}
+	nOutputs := int(totalVal / int64(uc.TargetValue))
+	if nOutputs == 0 {
+		nOutputs = 1
+	}
+	log.Infof("consolidate %d UTXOs into %d, total value: %d", len(utxos), nOutputs, totalVal)
+
+	tx, err := htlcbch.MakeConsolidationTx(bot.bchPrivKey, inputs, bot.bchAddr, nOutputs, uc.FeeRate)
+	if err != nil {
+		bot.logError("failed to create BCH consolidation tx: ", err)
+		return
+	}
+	log.Info("BCH tx hex: ", htlcbch.MsgTxToHex(tx))
+
+	// reserve UTXOs like lock txs, so they are not selected by lock txs before the tx is confirmed
+	consolidationTxHash := tx.TxHash().String()
+	err = bot.reserveUtxos(utxos, consolidationTxHash, "")
+	if err != nil {
+		bot.logError("DB error, failed to reserve UTXOs: ", err)
+		return
+	}
+
+	txHash, err := bot.bchCli.SendTx(tx)
+	if err != nil {
+		bot.logError("failed to send BCH consolidation tx: ", err)
+		bot.metrics.incTxsFailed(chainBch, txTypeConsolidate)
+		if err := bot.db.deleteUtxoReservationsBySpendingTx(consolidationTxHash); err != nil {
+			bot.logError("DB error, failed to release UTXOs: ", err)
+		}
+		return
+	}
+	log.Info("BCH consolidation tx sent, hash: ", txHash.String())
+	bot.metrics.incTxsSent(chainBch, txTypeConsolidate)
+}
+
+// sendBchTxMergingUtxos sends an unlock/refund tx of the bot which also spends small free UTXOs,
+// so fragmented UTXOs are consolidated without extra txs. The HTLC UTXO must be input#0 of the tx
+// (the covenant checks it), so only the bot's P2PKH UTXOs can be merged, never other HTLC UTXOs.
+// It falls back to the plain tx if UTXOs can not be merged or the merged tx can not be sent.
+func (bot *MarketMakerBot) sendBchTxMergingUtxos(
+	plainTx *wire.MsgTx,
+	feeRate uint64,
+	makeTxWithInputs func(inputs []htlcbch.InputInfo) (*wire.MsgTx, error),
+) (*chainhash.Hash, error) {
+	utxos := bot.pickUtxosToMerge(feeRate)
+	if len(utxos) > 0 {
+		txHash, err := bot.sendTxWithMergedUtxos(utxos, makeTxWithInputs)
+		if err == nil {
+			return txHash, nil
+		}
+		bot.logError("failed to merge UTXOs into BCH tx: ", err)
+	}
+	return bot.bchCli.SendTx(plainTx)
+}
+
+// returns nil if the bot does not consolidate UTXOs, UTXOs are not fragmented,
+// or feeRate is higher than the fee rate of consolidation txs
+func (bot *MarketMakerBot) pickUtxosToMerge(feeRate uint64) []btcjson.ListUnspentResult {
+	uc := bot.consolidator
+	if uc == nil || bot.isSlaveMode || feeRate > uc.FeeRate {
+		return nil
+	}
+	freeUTXOs, ok := bot.getFreeUtxos()
+	if !ok || !uc.isFragmented(freeUTXOs, bot.maxSwapVal) {
+		return nil
+	}
+	return uc.pickUTXOs(freeUTXOs)
+}
+
+func (bot *MarketMakerBot) sendTxWithMergedUtxos(
+	utxos []btcjson.ListUnspentResult,
+	makeTxWithInputs func(inputs []htlcbch.InputInfo) (*wire.MsgTx, error),
+) (*chainhash.Hash, error) {
+	tx, err := makeTxWithInputs(toInputInfos(utxos))
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("merge %d UTXOs into BCH tx: %s", len(utxos), htlcbch.MsgTxToHex(tx))
+
+	// reserve UTXOs like consolidation txs
+	mergingTxHash := tx.TxHash().String()
+	err = bot.reserveUtxos(utxos, mergingTxHash, "")
+	if err != nil {
+		return nil, fmt.Errorf("DB error, failed to reserve UTXOs: %w", err)
+	}
+
+	txHash, err := bot.bchCli.SendTx(tx)
+	if err != nil {
+		if err := bot.db.deleteUtxoReservationsBySpendingTx(mergingTxHash); err != nil {
+			bot.logError("DB error, failed to release UTXOs: ", err)
+		}
+		return nil, err
+	}
+	return txHash, nil
+}
+
+// returns UTXOs which are not reserved by unconfirmed txs, ok is false if RPC or DB failed
+func (bot *MarketMakerBot) getFreeUtxos() (freeUTXOs []btcjson.ListUnspentResult, ok bool) {
+	allUTXOs, err := bot.bchCli.GetAllUTXOs()
+	if err != nil {
+		bot.logError("RPC error, failed to get UTXOs: ", err)
+		return nil, false
+	}
+	reservedUtxos, err := bot.getReservedUtxos()
+	if err != nil {
+		bot.logError("DB error, failed to get UTXO reservations: ", err)
+		return nil, false
+	}
+	for _, utxo := range allUTXOs {
+		if !reservedUtxos[utxoKey(utxo.TxID, utxo.Vout)] {
+			freeUTXOs = append(freeUTXOs, utxo)
+		}
+	}
+	return freeUTXOs, true
+}
+
+func toInputInfos(utxos []btcjson.ListUnspentResult) []htlcbch.InputInfo {
+	return cast(utxos, func(utxo btcjson.ListUnspentResult) htlcbch.InputInfo {
+		return htlcbch.InputInfo{
+			TxID:   gethcmn.FromHex(utxo.TxID),
+			Vout:   utxo.Vout,
+			Amount: utxoAmtToSats(utxo.Amount),
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gcash/bchd/btcjson"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConsolidator(t *testing.T) *UtxoConsolidator {
+	uc, err := NewUtxoConsolidator(UtxoConsolidatorConfig{
+		TargetValue:   1000_0000,
+		MaxUtxoCount:  5,
+		MaxInputs:     4,
+		FeeRate:       1,
+		MaxMempoolTxs: 1,
+		CheckInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	return uc
+}
+
+func newTestConsolidationUTXOs(values ...int64) []btcjson.ListUnspentResult {
+	utxos := make([]btcjson.ListUnspentResult, len(values))
+	for i, val := range values {
+		utxos[i] = btcjson.ListUnspentResult{
+			TxID:          gethHash32(fmt.Sprintf("tx%d", i)).String()[2:],
+			Vout:          uint32(i),
+			Amount:        satsToUtxoAmt(uint64(val)),
+			Confirmations: 1,
+		}
+	}
+	return utxos
+}
+
+func TestNewUtxoConsolidator(t *testing.T) {
+	cfg := UtxoConsolidatorConfig{TargetValue: 546, MaxUtxoCount: 1, MaxInputs: 2, FeeRate: 1}
+	_, err := NewUtxoConsolidator(cfg)
+	require.ErrorContains(t, err, "target value is too small: 546")
+	cfg.TargetValue = 1e6
+	cfg.MaxUtxoCount = 0
+	_, err = NewUtxoConsolidator(cfg)
+	require.ErrorContains(t, err, "invalid max UTXO count: 0")
+	cfg.MaxUtxoCount = 1
+	cfg.MaxInputs = 1
+	_, err = NewUtxoConsolidator(cfg)
+	require.ErrorContains(t, err, "invalid max inputs: 1")
+	cfg.MaxInputs = 2
+	cfg.FeeRate = 0
+	_, err = NewUtxoConsolidator(cfg)
+	require.ErrorContains(t, err, "invalid fee rate: 0")
+	cfg.FeeRate = 1
+	_, err = NewUtxoConsolidator(cfg)
+	require.NoError(t, err)
+}
+
+func TestUtxoConsolidator_isFragmented(t *testing.T) {
+	uc := newTestConsolidator(t)
+	uc.MaxUtxoCount = 20
+
+	// not too many, and the largest 10 UTXOs are enough
+	utxos := newTestConsolidationUTXOs(1e6, 1e6, 1e6, 1e6, 1e6, 1e6, 1e6, 1e6, 1e6, 1e6, 1e6, 1e6)
+	require.False(t, uc.isFragmented(utxos, 1e7-bchLockFeeReserve))
+
+	// the largest 10 UTXOs are not enough
+	require.True(t, uc.isFragmented(utxos, 1e7))
+
+	// all UTXOs are not enough, consolidation does not help
+	require.False(t, uc.isFragmented(utxos, 2e7))
+
+	// too many UTXOs
+	uc.MaxUtxoCount = 11
+	require.True(t, uc.isFragmented(utxos, 1e6))
+}
+
+func TestUtxoConsolidator_pickUTXOs(t *testing.T) {
+	uc := newTestConsolidator(t)
+	utxos := newTestConsolidationUTXOs(5e5, 100, 2e5, 1000_0000, 3e5, 1e5, 4e5)
+	utxos[4].Confirmations = 0
+
+	// small, confirmed, worth spending, value ASC
+	picked := uc.pickUTXOs(utxos)
+	require.Equal(t, []btcjson.ListUnspentResult{utxos[5], utxos[2], utxos[6], utxos[0]}, picked)
+}
+
+func TestConsolidateUtxos(t *testing.T) {
+	_db := initDB(t, 123, 456)
+	bchCli := newMockBchClient(122, 123)
+	bchCli.utxos = newTestConsolidationUTXOs(1e5, 2e5, 3e5, 4e5, 5e5, 6e5, 1000_0000)
+	_bot := &MarketMakerBot{
+		db:           _db,
+		bchCli:       bchCli,
+		bchPrivKey:   testBchPrivKey,
+		bchPkh:       testBchPkh,
+		bchAddr:      testBchAddr,
+		maxSwapVal:   1e8,
+		consolidator: newTestConsolidator(t),
+		errLogQueue:  newErrLogQueue(100),
+	}
+
+	// mempool is busy
+	bchCli.mempool = []*wire.MsgTx{{}, {}}
+	_bot.consolidateUtxos()
+	require.Len(t, bchCli.sentTxs, 0)
+
+	// too soon
+	bchCli.mempool = nil
+	_bot.consolidateUtxos()
+	require.Len(t, bchCli.sentTxs, 0)
+
+	// consolidated
+	_bot.consolidator.lastCheckedAt = time.Time{}
+	_bot.consolidateUtxos()
+	require.Len(t, bchCli.sentTxs, 1)
+	tx := bchCli.sentTxs[0]
+	require.Len(t, tx.TxIn, 4)
+	require.Len(t, tx.TxOut, 1)
+	require.Greater(t, tx.TxOut[0].Value, int64(1e6-1000))
+	pkScript, err := txscript.PayToAddrScript(testBchAddr)
+	require.NoError(t, err)
+	require.Equal(t, pkScript, tx.TxOut[0].PkScript)
+
+	reservations, err := _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 4)
+	for i, r := range reservations {
+		require.Equal(t, bchCli.utxos[i].TxID, r.TxID)
+		require.Equal(t, tx.TxHash().String(), r.SpendingTxHash)
+		require.Equal(t, "", r.HashLock)
+	}
+
+	// reserved UTXOs are not consolidated again
+	_bot.consolidator.lastCheckedAt = time.Time{}
+	_bot.consolidateUtxos()
+	require.Len(t, bchCli.sentTxs, 1)
+
+	// failed to send tx, reservations are released
+	_bot.consolidator.lastCheckedAt = time.Time{}
+	require.NoError(t, _db.deleteUtxoReservationsBySpendingTx(tx.TxHash().String()))
+	bchCli.sendTxErr = fmt.Errorf("-26: txn-mempool-conflict")
+	_bot.consolidateUtxos()
+	require.Len(t, bchCli.sentTxs, 1)
+	reservations, err = _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 0)
+}
+
+func TestUnlockBchUserDeposits_mergeUtxos(t *testing.T) {
+	_bchLockTxHash := gethHash32Bytes("bchlock")
+
+	_db := initDB(t, 123, 456)
+	for i := 0; i < 2; i++ {
+		_secret := gethHash32Bytes(fmt.Sprintf("secret%d", i))
+		_hashLock := sha256.Sum256(_secret)
+		require.NoError(t, _db.addBch2SbchRecord(&Bch2SbchRecord{
+			BchLockHeight:  122,
+			BchLockTxHash:  toHex(_bchLockTxHash),
+			BchLockVout:    uint32(i),
+			Value:          12345678,
+			BchPrice:       1e8,
+			RecipientPkh:   toHex(testBchPkh),
+			SenderPkh:      toHex(gethAddrBytes("user")),
+			HashLock:       toHex(_hashLock[:]),
+			TimeLock:       100,
+			SenderEvmAddr:  toHex(gethAddrBytes("evm")),
+			HtlcScriptHash: toHex(gethAddrBytes("htlc")),
+			SbchLockTxHash: toHex(gethHash32Bytes("sbchlock")),
+			Secret:         toHex(_secret),
+			Status:         Bch2SbchStatusSecretRevealed,
+		}))
+	}
+
+	bchCli := newMockBchClient(122, 123)
+	bchCli.utxos = newTestConsolidationUTXOs(1e5, 2e5, 3e5, 4e5, 5e5, 6e5, 1000_0000)
+	_bot := &MarketMakerBot{
+		db:                    _db,
+		dbQueryLimit:          1,
+		bchCli:                bchCli,
+		bchPrivKey:            testBchPrivKey,
+		bchPkh:                testBchPkh,
+		bchAddr:               testBchAddr,
+		bchUnlockMinerFeeRate: 2,
+		maxSwapVal:            1e8,
+		consolidator:          newTestConsolidator(t),
+		errLogQueue:           newErrLogQueue(100),
+	}
+
+	// fee rate is higher than the consolidation fee rate, UTXOs are not merged
+	_bot.unlockBchUserDeposits()
+	require.Len(t, bchCli.sentTxs, 1)
+	require.Len(t, bchCli.sentTxs[0].TxIn, 1)
+
+	// small UTXOs are merged into the unlock tx, after the HTLC input
+	_bot.bchUnlockMinerFeeRate = 1
+	_bot.unlockBchUserDeposits()
+	require.Len(t, bchCli.sentTxs, 2)
+	tx := bchCli.sentTxs[1]
+	require.Len(t, tx.TxIn, 5)
+	require.Equal(t, toHex(_bchLockTxHash), tx.TxIn[0].PreviousOutPoint.Hash.String())
+	require.Equal(t, uint32(1), tx.TxIn[0].PreviousOutPoint.Index)
+	require.Len(t, tx.TxOut, 1)
+	require.Greater(t, tx.TxOut[0].Value, int64(12345678+1e6-2000))
+	pkScript, err := txscript.PayToAddrScript(testBchAddr)
+	require.NoError(t, err)
+	require.Equal(t, pkScript, tx.TxOut[0].PkScript)
+
+	reservations, err := _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 4)
+	for i, r := range reservations {
+		require.Equal(t, bchCli.utxos[i].TxID, r.TxID)
+		require.Equal(t, tx.TxHash().String(), r.SpendingTxHash)
+	}
+
+	unlocked, err := _db.getBch2SbchRecordsByStatus(Bch2SbchStatusBchUnlocked, 100)
+	require.NoError(t, err)
+	require.Len(t, unlocked, 2)
+	require.Equal(t, tx.TxHash().String(), unlocked[1].BchUnlockTxHash)
+}
@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	gethcmn "github.com/ethereum/go-ethereum/common"
+	"github.com/gcash/bchd/btcjson"
+	"github.com/gcash/bchd/txscript"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/smartbch/atomic-swap-bot/htlcbch"
+)
+
+type CpfpBumperConfig struct {
+	MaxAge      time.Duration // bump lock tx if it is unconfirmed for longer than this, 0 means no limit
+	DeadlineBPS uint64        // bump lock tx if this part of the sBCH time lock has elapsed, 0 means no limit
+	MinFeeRate  uint64        // sats/byte, min fee rate of the parent+child package
+}
+
+// CpfpBumper speeds up unconfirmed BCH lock txs by spending their change outputs, only used by master bot
+type CpfpBumper struct {
+	CpfpBumperConfig
+}
+
+func NewCpfpBumper(cfg CpfpBumperConfig) (*CpfpBumper, error) {
+	if cfg.MaxAge == 0 && cfg.DeadlineBPS == 0 {
+		return nil, fmt.Errorf("neither max age nor deadline is set")
+	}
+	if cfg.DeadlineBPS >= 10000 {
+		return nil, fmt.Errorf("invalid deadline: %d", cfg.DeadlineBPS)
+	}
+	if cfg.MinFeeRate == 0 {
+		return nil, fmt.Errorf("invalid min fee rate: %d", cfg.MinFeeRate)
+	}
+	return &CpfpBumper{CpfpBumperConfig: cfg}, nil
+}
+
+// sentAt is the time the lock tx was sent, currTime is the latest sBCH block time
+func (cb *CpfpBumper) isStuck(record *Sbch2BchRecord, sentAt time.Time, currTime uint64) bool {
+	if cb.MaxAge > 0 && time.Since(sentAt) >= cb.MaxAge {
+		return true
+	}
+	if cb.DeadlineBPS > 0 && record.TimeLock > 0 && currTime > record.SbchLockTime {
+		elapsed := currTime - record.SbchLockTime
+		return elapsed*10000/uint64(record.TimeLock) >= cb.DeadlineBPS
+	}
+	return false
+}
+
+// SBCH2BCH records: BchLocked (unconfirmed lock tx) => BchLocked (lock tx bumped by CPFP)
+func (bot *MarketMakerBot) bumpStuckBchLockTxs() {
+	records, err := bot.db.getSbch2BchRecordsByStatus(Sbch2BchStatusBchLocked, bot.dbQueryLimit)
+	if err != nil {
+		bot.logError("DB error, failed to get SBCH2BCH records: ", err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	// lock txs reserve their inputs until they are confirmed
+	reservations, err := bot.db.getUtxoReservations()
+	if err != nil {
+		bot.logError("DB error, failed to get UTXO reservations: ", err)
+		return
+	}
+	reservedUtxos := map[string]bool{}
+	lockTxInputs := map[string][]*UtxoReservation{} // spendingTxHash => reservations
+	for _, r := range reservations {
+		reservedUtxos[utxoKey(r.TxID, r.Vout)] = true
+		lockTxInputs[r.SpendingTxHash] = append(lockTxInputs[r.SpendingTxHash], r)
+	}
+
+	log.Info("check unconfirmed BCH lock txs ...")
+	currTime, err := bot.sbchCli.getBlockTimeLatest()
+	if err != nil {
+		bot.logError("RPC error, failed to get sBCH time: ", err)
+		return
+	}
+	for _, record := range records {
+		inputs := lockTxInputs[record.BchLockTxHash]
+		if len(inputs) == 0 {
+			continue // confirmed
+		}
+		if !bot.cpfpBumper.isStuck(record, inputs[0].CreatedAt, currTime) {
+			continue
+		}
+		bot.bumpBchLockTx(record, inputs, reservedUtxos)
+	}
+}
+
+func (bot *MarketMakerBot) bumpBchLockTx(record *Sbch2BchRecord,
+	inputs []*UtxoReservation, reservedUtxos map[string]bool) {
+
+	lockTxHash := record.BchLockTxHash
+	lockTx, err := bot.bchCli.GetTx(lockTxHash)
+	if err != nil {
+		bot.logError("RPC error, failed to get BCH lock tx: ", err)
+		return
+	}
+	if lockTx.Confirmations > 0 {
+		return
+	}
+
+	changePkScript, err := txscript.PayToAddrScript(bot.bchAddr)
+	if err != nil {
+		bot.logError("failed to create pkScript: ", err)
+		return
+	}
+	changeVout := -1
+	var changeVal, outVal int64
+	for i, vout := range lockTx.Vout {
+		val := utxoAmtToSats(vout.Value)
+		outVal += val
+		if vout.ScriptPubKey.Hex == toHex(changePkScript) {
+			changeVout, changeVal = i, val
+		}
+	}
+	if changeVout < 0 {
+		log.Info("BCH lock tx has no change output, can not bump it: ", lockTxHash)
+		return
+	}
+	if reservedUtxos[utxoKey(lockTxHash, uint32(changeVout))] {
+		return // already spent by a child tx
+	}
+
+	var inVal int64
+	for _, r := range inputs {
+		inVal += int64(r.Value)
+	}
+	parentFee := inVal - outVal
+	parentSize := int64(len(lockTx.Hex) / 2)
+	feeRate := bot.getBchFeeRate(txTypeLock)
+	if feeRate < bot.cpfpBumper.MinFeeRate {
+		feeRate = bot.cpfpBumper.MinFeeRate
+	}
+	if parentFee >= parentSize*int64(feeRate) {
+		log.Infof("BCH lock tx pays enough fee: %s, fee: %d, size: %d", lockTxHash, parentFee, parentSize)
+		return
+	}
+
+	log.Infof("bump BCH lock tx by CPFP: %s, hashLock: %s, package fee rate: %d",
+		lockTxHash, record.HashLock, feeRate)
+	childTx, err := htlcbch.MakeCpfpTx(bot.bchPrivKey, htlcbch.InputInfo{
+		TxID:   gethcmn.FromHex(lockTxHash),
+		Vout:   uint32(changeVout),
+		Amount: changeVal,
+	}, bot.bchAddr, parentSize, parentFee, feeRate)
+	if err != nil {
+		bot.logError("failed to create BCH CPFP tx: ", err)
+		return
+	}
+	log.Info("BCH tx hex: ", htlcbch.MsgTxToHex(childTx))
+
+	// the change output is reserved until the child tx is confirmed, so it is bumped only once
+	childTxHash := childTx.TxHash().String()
+	changeUtxo := btcjson.ListUnspentResult{
+		TxID:   lockTxHash,
+		Vout:   uint32(changeVout),
+		Amount: satsToUtxoAmt(uint64(changeVal)),
+	}
+	err = bot.reserveUtxos([]btcjson.ListUnspentResult{changeUtxo}, childTxHash, record.HashLock)
+	if err != nil {
+		bot.logError("DB error, failed to reserve UTXOs: ", err)
+		return
+	}
+
+	txHash, err := bot.bchCli.SendTx(childTx)
+	if err != nil {
+		bot.logError("failed to send BCH CPFP tx: ", err)
+		bot.metrics.incTxsFailed(chainBch, txTypeCpfp)
+		if err := bot.db.deleteUtxoReservationsBySpendingTx(childTxHash); err != nil {
+			bot.logError("DB error, failed to release UTXOs: ", err)
+		}
+		return
+	}
+	log.Info("BCH CPFP tx sent, hash: ", txHash.String())
+	bot.metrics.incTxsSent(chainBch, txTypeCpfp)
+	reservedUtxos[utxoKey(lockTxHash, uint32(changeVout))] = true
+}
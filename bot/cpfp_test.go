@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gcash/bchd/wire"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartbch/atomic-swap-bot/htlcbch"
+)
+
+func TestNewCpfpBumper(t *testing.T) {
+	_, err := NewCpfpBumper(CpfpBumperConfig{MinFeeRate: 1})
+	require.ErrorContains(t, err, "neither max age nor deadline is set")
+	_, err = NewCpfpBumper(CpfpBumperConfig{DeadlineBPS: 10000, MinFeeRate: 1})
+	require.ErrorContains(t, err, "invalid deadline: 10000")
+	_, err = NewCpfpBumper(CpfpBumperConfig{MaxAge: time.Hour})
+	require.ErrorContains(t, err, "invalid min fee rate: 0")
+	_, err = NewCpfpBumper(CpfpBumperConfig{MaxAge: time.Hour, MinFeeRate: 1})
+	require.NoError(t, err)
+}
+
+func TestCpfpBumper_isStuck(t *testing.T) {
+	cb, err := NewCpfpBumper(CpfpBumperConfig{MaxAge: time.Hour, DeadlineBPS: 2500, MinFeeRate: 1})
+	require.NoError(t, err)
+	record := &Sbch2BchRecord{SbchLockTime: 10000, TimeLock: 36000}
+
+	require.False(t, cb.isStuck(record, time.Now(), 10000))
+	require.False(t, cb.isStuck(record, time.Now(), 18999))
+	require.True(t, cb.isStuck(record, time.Now(), 19000))
+	require.True(t, cb.isStuck(record, time.Now().Add(-time.Hour), 10000))
+
+	cb.MaxAge = 0
+	require.False(t, cb.isStuck(record, time.Now().Add(-time.Hour), 10000))
+	cb.DeadlineBPS = 0
+	cb.MaxAge = time.Hour
+	require.False(t, cb.isStuck(record, time.Now(), 30000))
+}
+
+func TestBumpStuckBchLockTxs(t *testing.T) {
+	_db := initDB(t, 123, 456)
+	covenant, err := htlcbch.NewMainnetCovenant(testBchPkh, gethAddrBytes("user"),
+		gethHash32Bytes("hashlock"), 100, 0)
+	require.NoError(t, err)
+	utxoTxID := gethHash32("utxo").String()[2:]
+	lockTx, err := covenant.MakeLockTx(testBchPrivKey, []htlcbch.InputInfo{
+		{TxID: gethHash32Bytes("utxo"), Vout: 1, Amount: 100_0000},
+	}, 10_0000, 1)
+	require.NoError(t, err)
+	require.Len(t, lockTx.TxOut, 3)
+	lockTxHash := lockTx.TxHash().String()
+
+	record := createFakeSbch2BchRecord(1)
+	record.HashLock = toHex(gethHash32Bytes("hashlock"))
+	record.SbchLockTime = 10000
+	record.TimeLock = 36000
+	require.NoError(t, _db.addSbch2BchRecord(record))
+	record.UpdateStatusToBchLocked(lockTxHash, 0)
+	require.NoError(t, _db.updateSbch2BchRecord(record))
+	require.NoError(t, _db.addUtxoReservations([]*UtxoReservation{
+		{TxID: utxoTxID, Vout: 1, Value: 100_0000, SpendingTxHash: lockTxHash, HashLock: record.HashLock},
+	}))
+
+	bchCli := newMockBchClient(122, 123)
+	bchCli.mempool = []*wire.MsgTx{lockTx}
+	sbchCli := newMockSbchClient(455, 456, 10000)
+	cb, err := NewCpfpBumper(CpfpBumperConfig{MaxAge: time.Hour, DeadlineBPS: 2500, MinFeeRate: 5})
+	require.NoError(t, err)
+	_bot := &MarketMakerBot{
+		db:           _db,
+		bchCli:       bchCli,
+		sbchCli:      sbchCli,
+		bchPrivKey:   testBchPrivKey,
+		bchPkh:       testBchPkh,
+		bchAddr:      testBchAddr,
+		dbQueryLimit: 100,
+		cpfpBumper:   cb,
+		errLogQueue:  newErrLogQueue(100),
+	}
+
+	// not stuck
+	_bot.bumpStuckBchLockTxs()
+	require.Len(t, bchCli.sentTxs, 0)
+
+	// deadline is near
+	sbchCli.ts = 10000 + 9000
+	_bot.bumpStuckBchLockTxs()
+	require.Len(t, bchCli.sentTxs, 1)
+	childTx := bchCli.sentTxs[0]
+	require.Len(t, childTx.TxIn, 1)
+	require.Equal(t, lockTxHash, childTx.TxIn[0].PreviousOutPoint.Hash.String())
+	require.Equal(t, uint32(2), childTx.TxIn[0].PreviousOutPoint.Index)
+
+	// package fee rate
+	parentSize := int64(len(htlcbch.MsgTxToBytes(lockTx)))
+	parentFee := 100_0000 - lockTx.TxOut[0].Value - lockTx.TxOut[2].Value
+	childSize := int64(len(htlcbch.MsgTxToBytes(childTx)))
+	childFee := lockTx.TxOut[2].Value - childTx.TxOut[0].Value
+	require.InDelta(t, (parentSize+childSize)*5, parentFee+childFee, 5)
+
+	reservations, err := _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 2)
+	require.Equal(t, lockTxHash, reservations[1].TxID)
+	require.Equal(t, childTx.TxHash().String(), reservations[1].SpendingTxHash)
+
+	// bumped only once
+	_bot.bumpStuckBchLockTxs()
+	require.Len(t, bchCli.sentTxs, 1)
+
+	// lock tx confirmed
+	require.NoError(t, _db.deleteUtxoReservationsBySpendingTx(childTx.TxHash().String()))
+	require.NoError(t, _db.deleteUtxoReservationsBySpendingTx(lockTxHash))
+	_bot.bumpStuckBchLockTxs()
+	require.Len(t, bchCli.sentTxs, 1)
+}
@@ -1,12 +1,22 @@
 package bot
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// ErrDuplicateKey is returned when a unique constraint is violated, regardless of the DB driver
+var ErrDuplicateKey = errors.New("duplicate key")
+
 type (
 	Sbch2BchStatus int
 	Bch2SbchStatus int
@@ -20,6 +30,8 @@ const (
 	Bch2SbchStatusSbchRefunded
 	Bch2SbchStatusTooLateToLockSbch
 	Bch2SbchStatusPriceChanged
+	Bch2SbchStatusBchRefundedByUser
+	Bch2SbchStatusInsufficientLiquidity
 )
 
 const (
@@ -30,54 +42,210 @@ const (
 	Sbch2BchStatusBchRefunded
 	Sbch2BchStatusTooLateToLockBch
 	Sbch2BchStatusPriceChanged
+	Sbch2BchStatusSbchRefundedByUser
+	Sbch2BchStatusInsufficientLiquidity
+)
+
+const (
+	DirectionBch2Sbch = "BCH2SBCH"
+	DirectionSbch2Bch = "SBCH2BCH"
+
+	ActorMaster = "master"
+	ActorSlave  = "slave"
+
+	statusNone = -1 // used as FromStatus when record is created, and ToStatus when record is deleted
 )
 
+func (s Bch2SbchStatus) String() string {
+	switch s {
+	case Bch2SbchStatusNew:
+		return "New"
+	case Bch2SbchStatusSbchLocked:
+		return "SbchLocked"
+	case Bch2SbchStatusSecretRevealed:
+		return "SecretRevealed"
+	case Bch2SbchStatusBchUnlocked:
+		return "BchUnlocked"
+	case Bch2SbchStatusSbchRefunded:
+		return "SbchRefunded"
+	case Bch2SbchStatusTooLateToLockSbch:
+		return "TooLateToLockSbch"
+	case Bch2SbchStatusPriceChanged:
+		return "PriceChanged"
+	case Bch2SbchStatusBchRefundedByUser:
+		return "BchRefundedByUser"
+	case Bch2SbchStatusInsufficientLiquidity:
+		return "InsufficientLiquidity"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(s))
+	}
+}
+
+func (s Sbch2BchStatus) String() string {
+	switch s {
+	case Sbch2BchStatusNew:
+		return "New"
+	case Sbch2BchStatusBchLocked:
+		return "BchLocked"
+	case Sbch2BchStatusSecretRevealed:
+		return "SecretRevealed"
+	case Sbch2BchStatusSbchUnlocked:
+		return "SbchUnlocked"
+	case Sbch2BchStatusBchRefunded:
+		return "BchRefunded"
+	case Sbch2BchStatusTooLateToLockBch:
+		return "TooLateToLockBch"
+	case Sbch2BchStatusPriceChanged:
+		return "PriceChanged"
+	case Sbch2BchStatusSbchRefundedByUser:
+		return "SbchRefundedByUser"
+	case Sbch2BchStatusInsufficientLiquidity:
+		return "InsufficientLiquidity"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(s))
+	}
+}
+
+// LastHeights, BchBlock and SbchBlock are the scan state of each bot, so that master and slave can share one DB
 type LastHeights struct {
 	gorm.Model
+	Actor          string `gorm:"uniqueIndex;default:''"` // ActorMaster or ActorSlave
 	LastBchHeight  uint64
 	LastSbchHeight uint64
 }
 
+// BchBlock records the hash of each scanned BCH block, used to detect reorgs
+type BchBlock struct {
+	gorm.Model
+	Actor    string `gorm:"uniqueIndex:idx_bch_blocks_actor_height;default:''"` // ActorMaster or ActorSlave
+	Height   uint64 `gorm:"uniqueIndex:idx_bch_blocks_actor_height"`
+	Hash     string `gorm:"not null"`
+	PrevHash string `gorm:"not null"`
+}
+
+// SbchBlock records the hash of the last block of each scanned sBCH block range, used to detect reorgs
+type SbchBlock struct {
+	gorm.Model
+	Actor  string `gorm:"uniqueIndex:idx_sbch_blocks_actor_height;default:''"` // ActorMaster or ActorSlave
+	Height uint64 `gorm:"uniqueIndex:idx_sbch_blocks_actor_height"`
+	Hash   string `gorm:"not null"`
+	Time   uint64 `gorm:"not null"`
+}
+
+// UtxoReservation marks a BCH UTXO of the bot as spent-pending,
+// so that it is not selected again before the spending tx is confirmed or dropped
+type UtxoReservation struct {
+	gorm.Model
+	TxID           string `gorm:"uniqueIndex:idx_utxo_reservations_outpoint;not null"`
+	Vout           uint32 `gorm:"uniqueIndex:idx_utxo_reservations_outpoint"`
+	Value          uint64 `gorm:"not null"` // in sats
+	SpendingTxHash string `gorm:"index;not null"`
+	HashLock       string // the swap which the spending tx is made for, empty if it is not made for a swap
+}
+
+// PendingTx is a tx which is saved before it is broadcast, so that the status transition of its records
+// can be finished or rolled back after the bot crashes, it is deleted once the tx is mined or dropped
+type PendingTx struct {
+	gorm.Model
+	Chain     string `gorm:"not null"`             // chainBch or chainSbch
+	TxType    string `gorm:"not null"`             // txTypeLock|txTypeUnlock|txTypeRefund|txTypeUpdatePrices
+	TxHash    string `gorm:"uniqueIndex;not null"` //
+	RawTx     string `gorm:"not null"`             // serialized BCH tx or signed sBCH tx, in hex
+	Direction string ``                            // DirectionBch2Sbch or DirectionSbch2Bch, empty if no record is changed by the tx
+	RecordIDs string ``                            // comma separated, a BCH lock tx has one record, whose HTLC output is output#0
+	HashLock  string ``                            // empty if no record is changed by the tx
+	ToStatus  int    ``                            // status of the records once the tx is mined
+	Value     uint64 ``                            // in sats, sBCH locked by sBCH lock tx
+	TimeLock  uint32 ``                            // in seconds, sBCH time lock of the swap
+}
+
+// StatusTransition is an append-only history of status changes of swap records
+type StatusTransition struct {
+	gorm.Model
+	RecordID   uint   `gorm:"not null;index"` // ID of Bch2SbchRecord or Sbch2BchRecord
+	Direction  string `gorm:"not null"`       // DirectionBch2Sbch or DirectionSbch2Bch
+	HashLock   string `gorm:"not null;index"` //
+	FromStatus int    `gorm:"not null"`       // statusNone if the record is created
+	ToStatus   int    `gorm:"not null"`       // statusNone if the record is deleted
+	TxHash     string ``                      // the tx that caused this transition
+	Actor      string ``                      // ActorMaster or ActorSlave
+	Reason     string ``                      //
+}
+
 type Bch2SbchRecord struct {
 	gorm.Model
-	BchLockHeight    uint64         `gorm:"not null"` // got from tx
-	BchLockTxHash    string         `gorm:"unique"`   // got from tx
-	Value            uint64         `gorm:"not null"` // got from tx, in Sats
-	BchPrice         uint64         `gorm:"not null"` // got from tx, 8 decimals
-	RecipientPkh     string         `gorm:"not null"` // got from retData
-	SenderPkh        string         `gorm:"not null"` // got from retData
-	HashLock         string         `gorm:"unique"`   // got from retData, in Blocks
-	TimeLock         uint32         `gorm:"not null"` // got from retData
-	PenaltyBPS       uint16         `gorm:"not null"` // got from retData
-	SenderEvmAddr    string         `gorm:"not null"` // got from retData
-	HtlcScriptHash   string         `gorm:"not null"` // calculated
-	SbchLockTxTime   uint64         ``                // set when status changed to Bch2SbchStatusSbchLocked
-	SbchLockTxHash   string         ``                // set when status changed to Bch2SbchStatusSbchLocked
-	SbchUnlockTxHash string         ``                // set when status changed to Bch2SbchStatusSecretRevealed
-	Secret           string         ``                // set when status changed to Bch2SbchStatusSecretRevealed
-	BchUnlockTxHash  string         ``                // set when status changed to Bch2SbchStatusBchUnlocked
-	SbchRefundTxHash string         ``                // set when status changed to Bch2SbchStatusSbchRefunded
-	Status           Bch2SbchStatus `gorm:"not null"` //
+	BchLockHeight    uint64         `gorm:"not null"`                                    // got from tx, 0 if found in mempool (zero-conf)
+	BchLockTxHash    string         `gorm:"uniqueIndex:idx_bch_lock_outpoint"`           // got from tx
+	BchLockVout      uint32         `gorm:"uniqueIndex:idx_bch_lock_outpoint;default:0"` // got from tx, one tx may lock coins for several records
+	Value            uint64         `gorm:"not null"`                                    // got from tx, in Sats
+	BchPrice         uint64         `gorm:"not null"`                                    // got from tx, 8 decimals
+	RecipientPkh     string         `gorm:"not null"`                                    // got from retData
+	SenderPkh        string         `gorm:"not null"`                                    // got from retData
+	HashLock         string         `gorm:"unique"`                                      // got from retData, in Blocks
+	TimeLock         uint32         `gorm:"not null"`                                    // got from retData
+	PenaltyBPS       uint16         `gorm:"not null"`                                    // got from retData
+	SenderEvmAddr    string         `gorm:"not null"`                                    // got from retData
+	HtlcScriptHash   string         `gorm:"not null"`                                    // calculated
+	SbchLockTxTime   uint64         ``                                                   // set when status changed to Bch2SbchStatusSbchLocked
+	SbchLockTxHash   string         ``                                                   // set when status changed to Bch2SbchStatusSbchLocked
+	SbchUnlockTxHash string         ``                                                   // set when status changed to Bch2SbchStatusSecretRevealed
+	Secret           string         ``                                                   // set when status changed to Bch2SbchStatusSecretRevealed
+	BchUnlockTxHash  string         ``                                                   // set when status changed to Bch2SbchStatusBchUnlocked
+	SbchRefundTxHash string         ``                                                   // set when status changed to Bch2SbchStatusSbchRefunded
+	BchRefundTxHash  string         ``                                                   // set when user refunded BCH
+	Status           Bch2SbchStatus `gorm:"not null"`                                    //
+
+	reason string // saved in StatusTransition
 }
 
 type Sbch2BchRecord struct {
 	gorm.Model
-	SbchLockTime     uint64         `gorm:"not null"` // got from event
-	SbchLockTxHash   string         `gorm:"unique"`   // got from event
-	Value            uint64         `gorm:"not null"` // got from txValue, in Sats
-	SbchPrice        uint64         `gorm:"not null"` // got from event, 8 decimals
-	SbchSenderAddr   string         `gorm:"not null"` // got from event
-	BchRecipientPkh  string         `gorm:"not null"` // got from event
-	HashLock         string         `gorm:"unique"`   // got from event
-	TimeLock         uint32         `gorm:"not null"` // got from event, in Seconds
-	PenaltyBPS       uint16         `gorm:"not null"` // got from event
-	HtlcScriptHash   string         `gorm:"not null"` // calculated by bot
-	BchLockTxHash    string         ``                // set when status changed to Sbch2BchStatusBchLocked
-	BchUnlockTxHash  string         ``                // set when status changed to Sbch2BchStatusSecretRevealed
-	Secret           string         ``                // set when status changed to Sbch2BchStatusSecretRevealed
-	SbchUnlockTxHash string         ``                // set when status changed to Sbch2BchStatusSbchUnlocked
-	BchRefundTxHash  string         ``                // set when status changed to Sbch2BchStatusBchRefunded
-	Status           Sbch2BchStatus `gorm:"not null"` //
+	SbchLockTime     uint64         `gorm:"not null"`  // got from event
+	SbchLockTxHash   string         `gorm:"unique"`    // got from event
+	Value            uint64         `gorm:"not null"`  // got from txValue, in Sats
+	SbchPrice        uint64         `gorm:"not null"`  // got from event, 8 decimals
+	SbchSenderAddr   string         `gorm:"not null"`  // got from event
+	BchRecipientPkh  string         `gorm:"not null"`  // got from event
+	HashLock         string         `gorm:"unique"`    // got from event
+	TimeLock         uint32         `gorm:"not null"`  // got from event, in Seconds
+	PenaltyBPS       uint16         `gorm:"not null"`  // got from event
+	HtlcScriptHash   string         `gorm:"not null"`  // calculated by bot
+	BchLockTxHash    string         ``                 // set when status changed to Sbch2BchStatusBchLocked
+	BchLockVout      uint32         `gorm:"default:0"` // set when status changed to Sbch2BchStatusBchLocked
+	BchUnlockTxHash  string         ``                 // set when status changed to Sbch2BchStatusSecretRevealed
+	BchUnlockHeight  uint64         ``                 // set when status changed to Sbch2BchStatusSecretRevealed
+	Secret           string         ``                 // set when status changed to Sbch2BchStatusSecretRevealed
+	SbchUnlockTxHash string         ``                 // set when status changed to Sbch2BchStatusSbchUnlocked
+	BchRefundTxHash  string         ``                 // set when status changed to Sbch2BchStatusBchRefunded
+	SbchRefundTxHash string         ``                 // set when user refunded sBCH
+	Status           Sbch2BchStatus `gorm:"not null"`  //
+
+	reason string // saved in StatusTransition
+}
+
+func (record *Bch2SbchRecord) withReason(reason string) *Bch2SbchRecord {
+	record.reason = reason
+	return record
+}
+
+// the tx which changed the record to its current status
+func (record *Bch2SbchRecord) statusTxHash() string {
+	switch record.Status {
+	case Bch2SbchStatusNew:
+		return record.BchLockTxHash
+	case Bch2SbchStatusSbchLocked:
+		return record.SbchLockTxHash
+	case Bch2SbchStatusSecretRevealed:
+		return record.SbchUnlockTxHash
+	case Bch2SbchStatusBchUnlocked:
+		return record.BchUnlockTxHash
+	case Bch2SbchStatusSbchRefunded:
+		return record.SbchRefundTxHash
+	case Bch2SbchStatusBchRefundedByUser:
+		return record.BchRefundTxHash
+	default:
+		return ""
+	}
 }
 
 func (record *Bch2SbchRecord) UpdateStatusToSbchLocked(sbchLockTxHash string, sbchLockTxTime uint64) *Bch2SbchRecord {
@@ -86,6 +254,12 @@ func (record *Bch2SbchRecord) UpdateStatusToSbchLocked(sbchLockTxHash string, sb
 	record.SbchLockTxTime = sbchLockTxTime
 	return record
 }
+func (record *Bch2SbchRecord) RevertStatusToNew() *Bch2SbchRecord {
+	record.Status = Bch2SbchStatusNew
+	record.SbchLockTxHash = ""
+	record.SbchLockTxTime = 0
+	return record
+}
 func (record *Bch2SbchRecord) UpdateStatusToSecretRevealed(secret, sbchUnlockTxHash string) *Bch2SbchRecord {
 	record.Status = Bch2SbchStatusSecretRevealed
 	record.Secret = secret
@@ -102,10 +276,41 @@ func (record *Bch2SbchRecord) UpdateStatusToSbchRefunded(sbchRefundTxHash string
 	record.SbchRefundTxHash = sbchRefundTxHash
 	return record
 }
+func (record *Bch2SbchRecord) UpdateStatusToBchRefundedByUser(bchRefundTxHash string) *Bch2SbchRecord {
+	record.Status = Bch2SbchStatusBchRefundedByUser
+	record.BchRefundTxHash = bchRefundTxHash
+	return record
+}
+
+func (record *Sbch2BchRecord) withReason(reason string) *Sbch2BchRecord {
+	record.reason = reason
+	return record
+}
+
+// the tx which changed the record to its current status
+func (record *Sbch2BchRecord) statusTxHash() string {
+	switch record.Status {
+	case Sbch2BchStatusNew:
+		return record.SbchLockTxHash
+	case Sbch2BchStatusBchLocked:
+		return record.BchLockTxHash
+	case Sbch2BchStatusSecretRevealed:
+		return record.BchUnlockTxHash
+	case Sbch2BchStatusSbchUnlocked:
+		return record.SbchUnlockTxHash
+	case Sbch2BchStatusBchRefunded:
+		return record.BchRefundTxHash
+	case Sbch2BchStatusSbchRefundedByUser:
+		return record.SbchRefundTxHash
+	default:
+		return ""
+	}
+}
 
-func (record *Sbch2BchRecord) UpdateStatusToBchLocked(bchLockTxHash string) *Sbch2BchRecord {
+func (record *Sbch2BchRecord) UpdateStatusToBchLocked(bchLockTxHash string, bchLockVout uint32) *Sbch2BchRecord {
 	record.Status = Sbch2BchStatusBchLocked
 	record.BchLockTxHash = bchLockTxHash
+	record.BchLockVout = bchLockVout
 	return record
 }
 func (record *Sbch2BchRecord) UpdateStatusToSecretRevealed(secret, bchUnlockTxHash string) *Sbch2BchRecord {
@@ -114,6 +319,13 @@ func (record *Sbch2BchRecord) UpdateStatusToSecretRevealed(secret, bchUnlockTxHa
 	record.BchUnlockTxHash = bchUnlockTxHash
 	return record
 }
+func (record *Sbch2BchRecord) RevertStatusToBchLocked() *Sbch2BchRecord {
+	record.Status = Sbch2BchStatusBchLocked
+	record.Secret = ""
+	record.BchUnlockTxHash = ""
+	record.BchUnlockHeight = 0
+	return record
+}
 func (record *Sbch2BchRecord) UpdateStatusToSbchUnlocked(sbchUnlockTxHash string) *Sbch2BchRecord {
 	record.Status = Sbch2BchStatusSbchUnlocked
 	record.SbchUnlockTxHash = sbchUnlockTxHash
@@ -125,36 +337,59 @@ func (record *Sbch2BchRecord) UpdateStatusToBchRefunded(bchRefundTxHash string)
 	return record
 }
 
+func (record *Sbch2BchRecord) UpdateStatusToSbchRefundedByUser(sbchRefundTxHash string) *Sbch2BchRecord {
+	record.Status = Sbch2BchStatusSbchRefundedByUser
+	record.SbchRefundTxHash = sbchRefundTxHash
+	return record
+}
+
 // ========== DB ==========
 
 type DB struct {
-	db *gorm.DB
+	db    *gorm.DB
+	actor string // saved in StatusTransition, and the owner of the scan state
 }
 
-func OpenDB(dbFile string) (DB, error) {
-	db, err := gorm.Open(sqlite.Open(dbFile), &gorm.Config{})
+// OpenDB opens a PostgreSQL DB if dsn starts with "postgres://" or "postgresql://",
+// otherwise dsn is treated as a SQLite file path (the "sqlite://" prefix is optional).
+func OpenDB(dsn string) (DB, error) {
+	var dialector gorm.Dialector
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		dialector = postgres.Open(dsn)
+	} else {
+		dialector = sqlite.Open(strings.TrimPrefix(dsn, "sqlite://"))
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return DB{}, err
 	}
-	return DB{db}, nil
+	return DB{db: db}, nil
 }
 
-func (db DB) syncSchemas() error {
-	return db.db.AutoMigrate(&Bch2SbchRecord{}, &Sbch2BchRecord{}, &LastHeights{})
+func isDuplicateKeyErr(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+	return false
 }
 
-func (db DB) initLastHeights(lastBchHeight, lastSbchHeight uint64) error {
-	result := db.db.Create(&LastHeights{
-		LastBchHeight:  lastBchHeight,
-		LastSbchHeight: lastSbchHeight,
-	})
-	if err := result.Error; err != nil {
-		return err
-	}
-	if result.RowsAffected != 1 {
-		return fmt.Errorf("failed to init LastHeights, RowsAffected: %d", result.RowsAffected)
+func wrapDuplicateKeyErr(err error) error {
+	if isDuplicateKeyErr(err) {
+		return fmt.Errorf("%w: %s", ErrDuplicateKey, err.Error())
 	}
-	return nil
+	return err
+}
+
+func (db DB) withActor(actor string) DB {
+	db.actor = actor
+	return db
 }
 
 func (db DB) getLastBchHeight() (uint64, error) {
@@ -167,10 +402,38 @@ func (db DB) getLastSbchHeight() (uint64, error) {
 }
 func (db DB) getLastHeights() (*LastHeights, error) {
 	heights := &LastHeights{}
-	result := db.db.First(heights)
+	result := db.db.Where("actor = ?", db.actor).First(heights)
 	return heights, result.Error
 }
 
+// initScanState creates the last heights of the actor if they do not exist,
+// the scan state saved before the actor column was added (whose actor is empty) is taken over
+// by the first bot started, because the DB was used by that bot only
+func (db DB) initScanState() error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		var n int64
+		err := tx.Model(&LastHeights{}).Where("actor = ?", db.actor).Count(&n).Error
+		if err != nil || n > 0 {
+			return err
+		}
+
+		result := tx.Model(&LastHeights{}).Where("actor = ?", "").Update("actor", db.actor)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return tx.Create(&LastHeights{Actor: db.actor}).Error
+		}
+		for _, model := range []any{&BchBlock{}, &SbchBlock{}} {
+			err = tx.Model(model).Where("actor = ?", "").Update("actor", db.actor).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (db DB) setLastBchHeight(h uint64) error {
 	heights, err := db.getLastHeights()
 	if err != nil {
@@ -190,9 +453,110 @@ func (db DB) setLastSbchHeight(h uint64) error {
 	return result.Error
 }
 
+func (db DB) addBchBlock(h uint64, hash, prevHash string) error {
+	result := db.db.Create(&BchBlock{
+		Actor:    db.actor,
+		Height:   h,
+		Hash:     hash,
+		PrevHash: prevHash,
+	})
+	return wrapDuplicateKeyErr(result.Error)
+}
+
+func (db DB) getBchBlock(h uint64) (block *BchBlock, err error) {
+	block = &BchBlock{}
+	result := db.db.Where("actor = ? AND height = ?", db.actor, h).First(block)
+	return block, result.Error
+}
+
+// fails with ErrDuplicateKey if any of the UTXOs is already reserved
+func (db DB) addUtxoReservations(reservations []*UtxoReservation) error {
+	result := db.db.Create(&reservations)
+	return wrapDuplicateKeyErr(result.Error)
+}
+
+func (db DB) getUtxoReservations() (reservations []*UtxoReservation, err error) {
+	result := db.db.Order("id").Find(&reservations)
+	err = result.Error
+	return
+}
+
+// hard delete, so that the UTXOs can be reserved again
+func (db DB) deleteUtxoReservationsBySpendingTx(txHash string) error {
+	result := db.db.Unscoped().Where("spending_tx_hash = ?", txHash).Delete(&UtxoReservation{})
+	return result.Error
+}
+
+func (db DB) addPendingTx(ptx *PendingTx) error {
+	result := db.db.Create(ptx)
+	return wrapDuplicateKeyErr(result.Error)
+}
+
+func (db DB) getPendingTxs() (ptxs []*PendingTx, err error) {
+	result := db.db.Order("id").Find(&ptxs)
+	err = result.Error
+	return
+}
+
+// hard delete, pending txs are only kept until they are mined or dropped
+func (db DB) deletePendingTxs(txHashes []string) error {
+	result := db.db.Unscoped().Where("tx_hash IN ?", txHashes).Delete(&PendingTx{})
+	return result.Error
+}
+
+// remove blocks orphaned by reorg
+func (db DB) deleteBchBlocksAbove(h uint64) error {
+	result := db.db.Unscoped().Where("actor = ? AND height > ?", db.actor, h).Delete(&BchBlock{})
+	return result.Error
+}
+
+// remove blocks that are too old to be reorganized
+func (db DB) deleteBchBlocksBelow(h uint64) error {
+	result := db.db.Unscoped().Where("actor = ? AND height < ?", db.actor, h).Delete(&BchBlock{})
+	return result.Error
+}
+
+func (db DB) addSbchBlock(h uint64, hash string, blockTime uint64) error {
+	result := db.db.Create(&SbchBlock{
+		Actor:  db.actor,
+		Height: h,
+		Hash:   hash,
+		Time:   blockTime,
+	})
+	return wrapDuplicateKeyErr(result.Error)
+}
+
+func (db DB) getSbchBlock(h uint64) (block *SbchBlock, err error) {
+	block = &SbchBlock{}
+	result := db.db.Where("actor = ? AND height = ?", db.actor, h).First(block)
+	return block, result.Error
+}
+
+// saved blocks below h, ordered by height DESC
+func (db DB) getSbchBlocksBelow(h uint64, limit int) (blocks []*SbchBlock, err error) {
+	result := db.db.Where("actor = ? AND height < ?", db.actor, h).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "height"}, Desc: true}).
+		Limit(limit).
+		Find(&blocks)
+	err = result.Error
+	return
+}
+
+// remove blocks orphaned by reorg
+func (db DB) deleteSbchBlocksAbove(h uint64) error {
+	result := db.db.Unscoped().Where("actor = ? AND height > ?", db.actor, h).Delete(&SbchBlock{})
+	return result.Error
+}
+
+// remove blocks that are too old to be reorganized
+func (db DB) deleteSbchBlocksBelow(h uint64) error {
+	result := db.db.Unscoped().Where("actor = ? AND height < ?", db.actor, h).Delete(&SbchBlock{})
+	return result.Error
+}
+
+// BchLockHeight is 0 if the lock tx is not mined yet (zero-conf)
 func (db DB) addBch2SbchRecord(record *Bch2SbchRecord) error {
-	if record.BchLockHeight == 0 ||
-		record.BchLockTxHash == "" ||
+	if record.BchLockTxHash == "" ||
 		record.Value == 0 ||
 		record.RecipientPkh == "" ||
 		record.SenderPkh == "" ||
@@ -204,8 +568,13 @@ func (db DB) addBch2SbchRecord(record *Bch2SbchRecord) error {
 		return fmt.Errorf("missing required fields")
 	}
 
-	result := db.db.Create(record)
-	return result.Error
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return db.addBch2SbchTransition(tx, record, statusNone)
+	})
+	return wrapDuplicateKeyErr(err)
 }
 
 func (db DB) addSbch2BchRecord(record *Sbch2BchRecord) error {
@@ -221,8 +590,44 @@ func (db DB) addSbch2BchRecord(record *Sbch2BchRecord) error {
 		return fmt.Errorf("missing required fields")
 	}
 
-	result := db.db.Create(record)
-	return result.Error
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return db.addSbch2BchTransition(tx, record, statusNone)
+	})
+	return wrapDuplicateKeyErr(err)
+}
+
+type statusCount struct {
+	Status int
+	N      int64
+}
+
+func (db DB) countBch2SbchRecordsByStatus() (map[Bch2SbchStatus]int64, error) {
+	var rows []statusCount
+	result := db.db.Model(&Bch2SbchRecord{}).Select("status, count(*) AS n").Group("status").Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	counts := make(map[Bch2SbchStatus]int64, len(rows))
+	for _, row := range rows {
+		counts[Bch2SbchStatus(row.Status)] = row.N
+	}
+	return counts, nil
+}
+
+func (db DB) countSbch2BchRecordsByStatus() (map[Sbch2BchStatus]int64, error) {
+	var rows []statusCount
+	result := db.db.Model(&Sbch2BchRecord{}).Select("status, count(*) AS n").Group("status").Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	counts := make(map[Sbch2BchStatus]int64, len(rows))
+	for _, row := range rows {
+		counts[Sbch2BchStatus(row.Status)] = row.N
+	}
+	return counts, nil
 }
 
 func (db DB) getBch2SbchRecordsByStatus(status Bch2SbchStatus, limit int) (records []*Bch2SbchRecord, err error) {
@@ -243,6 +648,18 @@ func (db DB) getSbch2BchRecordsByStatus(status Sbch2BchStatus, limit int) (recor
 	return
 }
 
+func (db DB) getBch2SbchRecordByID(id uint) (record *Bch2SbchRecord, err error) {
+	record = &Bch2SbchRecord{}
+	result := db.db.First(record, id)
+	return record, result.Error
+}
+
+func (db DB) getSbch2BchRecordByID(id uint) (record *Sbch2BchRecord, err error) {
+	record = &Sbch2BchRecord{}
+	result := db.db.First(record, id)
+	return record, result.Error
+}
+
 func (db DB) getBch2SbchRecordByHashLock(hashLock string) (record *Bch2SbchRecord, err error) {
 	record = &Bch2SbchRecord{}
 	result := db.db.Where("hash_lock = ?", hashLock).First(record)
@@ -255,12 +672,97 @@ func (db DB) getSbch2BchRecordByHashLock(hashLock string) (record *Sbch2BchRecor
 	return record, result.Error
 }
 
-func (db DB) getSbch2BchRecordByBchLockTxHash(txHashHex string) (record *Sbch2BchRecord, err error) {
-	record = &Sbch2BchRecord{}
+func (db DB) getBch2SbchRecordByBchLockTxHash(txHashHex string) (record *Bch2SbchRecord, err error) {
+	record = &Bch2SbchRecord{}
 	result := db.db.Where("bch_lock_tx_hash = ?", txHashHex).First(record)
 	return record, result.Error
 }
 
+func (db DB) getBch2SbchRecordByBchLockOutPoint(txHashHex string, vout uint32) (record *Bch2SbchRecord, err error) {
+	record = &Bch2SbchRecord{}
+	result := db.db.Where("bch_lock_tx_hash = ? AND bch_lock_vout = ?", txHashHex, vout).First(record)
+	return record, result.Error
+}
+
+// bch2sbch records created from lock txs in blocks above h
+func (db DB) getBch2SbchRecordsLockedAbove(h uint64) (records []*Bch2SbchRecord, err error) {
+	result := db.db.Where("bch_lock_height > ?", h).Find(&records)
+	err = result.Error
+	return
+}
+
+// bch2sbch records created from lock txs in mempool (zero-conf), whose lock txs are not scanned in blocks yet
+func (db DB) getUnconfirmedBch2SbchRecords(limit int) (records []*Bch2SbchRecord, err error) {
+	result := db.db.Where("bch_lock_height = 0 AND status NOT IN ?", []Bch2SbchStatus{
+		Bch2SbchStatusBchUnlocked, Bch2SbchStatusSbchRefunded, Bch2SbchStatusBchRefundedByUser}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: false}).
+		Limit(limit).
+		Find(&records)
+	err = result.Error
+	return
+}
+
+// total value of unconfirmed BCH deposits, which the bot has locked or will lock sBCH for
+func (db DB) getUnconfirmedBch2SbchValue() (uint64, error) {
+	var total uint64
+	result := db.db.Model(&Bch2SbchRecord{}).
+		Select("COALESCE(SUM(value), 0)").
+		Where("bch_lock_height = 0 AND status IN ?", []Bch2SbchStatus{
+			Bch2SbchStatusNew, Bch2SbchStatusSbchLocked, Bch2SbchStatusSecretRevealed}).
+		Scan(&total)
+	return total, result.Error
+}
+
+// total value of bch2sbch records which the bot will unlock BCH from
+func (db DB) getToBeUnlockedBchValue() (uint64, error) {
+	var total uint64
+	result := db.db.Model(&Bch2SbchRecord{}).
+		Select("COALESCE(SUM(value), 0)").
+		Where("status IN ?", []Bch2SbchStatus{
+			Bch2SbchStatusNew, Bch2SbchStatusSbchLocked, Bch2SbchStatusSecretRevealed}).
+		Scan(&total)
+	return total, result.Error
+}
+
+// total value of sbch2bch records which the bot will unlock sBCH from
+func (db DB) getToBeUnlockedSbchValue() (uint64, error) {
+	var total uint64
+	result := db.db.Model(&Sbch2BchRecord{}).
+		Select("COALESCE(SUM(value), 0)").
+		Where("status IN ?", []Sbch2BchStatus{
+			Sbch2BchStatusNew, Sbch2BchStatusBchLocked, Sbch2BchStatusSecretRevealed}).
+		Scan(&total)
+	return total, result.Error
+}
+
+// sbch2bch records moved to SecretRevealed by unlock txs in blocks above h
+func (db DB) getSbch2BchRecordsUnlockedAbove(h uint64) (records []*Sbch2BchRecord, err error) {
+	result := db.db.Where("bch_unlock_height > ?", h).Find(&records)
+	err = result.Error
+	return
+}
+
+// bch2sbch records whose sBCH lock txs are mined after t
+func (db DB) getBch2SbchRecordsSbchLockedAfter(t uint64) (records []*Bch2SbchRecord, err error) {
+	result := db.db.Where("sbch_lock_tx_time > ?", t).Find(&records)
+	err = result.Error
+	return
+}
+
+// sbch2bch records whose sBCH lock txs are mined after t
+func (db DB) getSbch2BchRecordsSbchLockedAfter(t uint64) (records []*Sbch2BchRecord, err error) {
+	result := db.db.Where("sbch_lock_time > ?", t).Find(&records)
+	err = result.Error
+	return
+}
+
+// the HTLC UTXO spent by a BCH unlock/refund tx identifies the sbch2bch record
+func (db DB) getSbch2BchRecordByBchLockOutPoint(txHashHex string, vout uint32) (record *Sbch2BchRecord, err error) {
+	record = &Sbch2BchRecord{}
+	result := db.db.Where("bch_lock_tx_hash = ? AND bch_lock_vout = ?", txHashHex, vout).First(record)
+	return record, result.Error
+}
+
 func (db DB) updateBch2SbchRecord(record *Bch2SbchRecord) error {
 	if record.Status == Bch2SbchStatusSbchLocked {
 		if record.SbchLockTxHash == "" {
@@ -277,9 +779,30 @@ func (db DB) updateBch2SbchRecord(record *Bch2SbchRecord) error {
 		if record.BchUnlockTxHash == "" {
 			return fmt.Errorf("BchUnlockTxHash is empty")
 		}
+	} else if record.Status == Bch2SbchStatusBchRefundedByUser {
+		if record.BchRefundTxHash == "" {
+			return fmt.Errorf("BchRefundTxHash is empty")
+		}
 	} //else if record.Status == Bch2SbchStatusTooLateToLockSbch {}
-	result := db.db.Save(record)
-	return result.Error
+
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		var oldStatus []int
+		err := tx.Model(&Bch2SbchRecord{}).Where("id = ?", record.ID).Pluck("status", &oldStatus).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Save(record).Error; err != nil {
+			return err
+		}
+		if len(oldStatus) > 0 && oldStatus[0] == int(record.Status) {
+			return nil
+		}
+		fromStatus := statusNone
+		if len(oldStatus) > 0 {
+			fromStatus = oldStatus[0]
+		}
+		return db.addBch2SbchTransition(tx, record, fromStatus)
+	})
 }
 
 func (db DB) updateSbch2BchRecord(record *Sbch2BchRecord) error {
@@ -298,11 +821,187 @@ func (db DB) updateSbch2BchRecord(record *Sbch2BchRecord) error {
 		if record.SbchUnlockTxHash == "" {
 			return fmt.Errorf("BchUnlockTxHash is empty")
 		}
+	} else if record.Status == Sbch2BchStatusSbchRefundedByUser {
+		if record.SbchRefundTxHash == "" {
+			return fmt.Errorf("SbchRefundTxHash is empty")
+		}
 	} //else if record.Status == Sbch2BchStatusTooLateToLockBch {}
-	result := db.db.Save(record)
+
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		var oldStatus []int
+		err := tx.Model(&Sbch2BchRecord{}).Where("id = ?", record.ID).Pluck("status", &oldStatus).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Save(record).Error; err != nil {
+			return err
+		}
+		if len(oldStatus) > 0 && oldStatus[0] == int(record.Status) {
+			return nil
+		}
+		fromStatus := statusNone
+		if len(oldStatus) > 0 {
+			fromStatus = oldStatus[0]
+		}
+		return db.addSbch2BchTransition(tx, record, fromStatus)
+	})
+}
+
+// hard delete, so that the record can be recreated if its lock tx is mined again
+func (db DB) deleteBch2SbchRecord(record *Bch2SbchRecord) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(record).Error; err != nil {
+			return err
+		}
+		return tx.Create(&StatusTransition{
+			RecordID:   record.ID,
+			Direction:  DirectionBch2Sbch,
+			HashLock:   record.HashLock,
+			FromStatus: int(record.Status),
+			ToStatus:   statusNone,
+			Actor:      db.actor,
+			Reason:     record.reason,
+		}).Error
+	})
+}
+
+// hard delete, so that the record can be recreated if its lock tx is mined again
+func (db DB) deleteSbch2BchRecord(record *Sbch2BchRecord) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(record).Error; err != nil {
+			return err
+		}
+		return tx.Create(&StatusTransition{
+			RecordID:   record.ID,
+			Direction:  DirectionSbch2Bch,
+			HashLock:   record.HashLock,
+			FromStatus: int(record.Status),
+			ToStatus:   statusNone,
+			Actor:      db.actor,
+			Reason:     record.reason,
+		}).Error
+	})
+}
+
+func (db DB) addBch2SbchTransition(tx *gorm.DB, record *Bch2SbchRecord, fromStatus int) error {
+	result := tx.Create(&StatusTransition{
+		RecordID:   record.ID,
+		Direction:  DirectionBch2Sbch,
+		HashLock:   record.HashLock,
+		FromStatus: fromStatus,
+		ToStatus:   int(record.Status),
+		TxHash:     record.statusTxHash(),
+		Actor:      db.actor,
+		Reason:     record.reason,
+	})
+	if result.Error == nil {
+		record.reason = ""
+	}
 	return result.Error
 }
 
+func (db DB) addSbch2BchTransition(tx *gorm.DB, record *Sbch2BchRecord, fromStatus int) error {
+	result := tx.Create(&StatusTransition{
+		RecordID:   record.ID,
+		Direction:  DirectionSbch2Bch,
+		HashLock:   record.HashLock,
+		FromStatus: fromStatus,
+		ToStatus:   int(record.Status),
+		TxHash:     record.statusTxHash(),
+		Actor:      db.actor,
+		Reason:     record.reason,
+	})
+	if result.Error == nil {
+		record.reason = ""
+	}
+	return result.Error
+}
+
+// GetStatusTransitions returns the full timeline of a hash lock, ordered by time
+func (db DB) GetStatusTransitions(hashLock string) (transitions []*StatusTransition, err error) {
+	result := db.db.Where("hash_lock = ?", hashLock).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: false}).
+		Find(&transitions)
+	err = result.Error
+	return
+}
+
+func (db DB) getStatusTransitionsAfter(id uint, limit int) (transitions []*StatusTransition, err error) {
+	result := db.db.Where("id > ?", id).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: false}).
+		Limit(limit).
+		Find(&transitions)
+	err = result.Error
+	return
+}
+
+// 0 if there is no transition
+func (db DB) getLastStatusTransitionID() (uint, error) {
+	var ids []uint
+	result := db.db.Model(&StatusTransition{}).Order("id DESC").Limit(1).Pluck("id", &ids)
+	if result.Error != nil || len(ids) == 0 {
+		return 0, result.Error
+	}
+	return ids[0], nil
+}
+
+func (db DB) getStatusTransitionsByRecord(direction string, recordID uint) (transitions []*StatusTransition, err error) {
+	result := db.db.Where("direction = ? AND record_id = ?", direction, recordID).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: false}).
+		Find(&transitions)
+	err = result.Error
+	return
+}
+
+// SwapFilter is used to query swap records, zero values mean no filter
+type SwapFilter struct {
+	Status    *int      //
+	Sender    string    // user's BCH PKH (BCH2SBCH) or sBCH address (SBCH2BCH), hex without 0x
+	Recipient string    // user's sBCH address (BCH2SBCH) or BCH PKH (SBCH2BCH), hex without 0x
+	FromTime  time.Time // CreatedAt >= FromTime
+	ToTime    time.Time // CreatedAt < ToTime
+	BeforeID  uint      // cursor, ID < BeforeID
+	Limit     int       //
+}
+
+func (f SwapFilter) apply(query *gorm.DB, senderCol, recipientCol string) *gorm.DB {
+	if f.Status != nil {
+		query = query.Where("status = ?", *f.Status)
+	}
+	if f.Sender != "" {
+		query = query.Where(senderCol+" = ?", f.Sender)
+	}
+	if f.Recipient != "" {
+		query = query.Where(recipientCol+" = ?", f.Recipient)
+	}
+	if !f.FromTime.IsZero() {
+		query = query.Where("created_at >= ?", f.FromTime)
+	}
+	if !f.ToTime.IsZero() {
+		query = query.Where("created_at < ?", f.ToTime)
+	}
+	if f.BeforeID > 0 {
+		query = query.Where("id < ?", f.BeforeID)
+	}
+	return query.
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: true}).
+		Limit(f.Limit)
+}
+
+// newest first
+func (db DB) queryBch2SbchRecords(f SwapFilter) (records []*Bch2SbchRecord, err error) {
+	result := f.apply(db.db, "sender_pkh", "sender_evm_addr").Find(&records)
+	err = result.Error
+	return
+}
+
+// newest first
+func (db DB) querySbch2BchRecords(f SwapFilter) (records []*Sbch2BchRecord, err error) {
+	result := f.apply(db.db, "sbch_sender_addr", "bch_recipient_pkh").Find(&records)
+	err = result.Error
+	return
+}
+
 func (db DB) GetAllBch2SbchRecords() (records []*Bch2SbchRecord, err error) {
 	result := db.db.Find(&records)
 	err = result.Error
@@ -0,0 +1,427 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaVersion records each applied migration
+type SchemaVersion struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+type MigrationInfo struct {
+	Version uint
+	Name    string
+}
+
+type migration struct {
+	MigrationInfo
+	migrate func(tx *gorm.DB) error
+}
+
+// Migrations are applied in order, each in its own DB transaction.
+// Never modify or reorder released migrations, append new ones instead.
+// Migrations must be idempotent, DB files created before versioning have no schema_versions table.
+// Migrations use the schema snapshots below instead of the models, so that a fresh DB goes through
+// the same steps as an old one whatever the models look like now.
+var migrations = []migration{
+	{
+		MigrationInfo: MigrationInfo{1, "create swap records and last heights"},
+		migrate: func(tx *gorm.DB) error {
+			err := createTablesIfNotExist(tx, &bch2SbchRecordV1{}, &sbch2BchRecordV1{}, &lastHeightsV1{})
+			if err != nil {
+				return err
+			}
+			var n int64
+			if err = tx.Model(&lastHeightsV1{}).Count(&n).Error; err != nil || n > 0 {
+				return err
+			}
+			return tx.Create(&lastHeightsV1{}).Error
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{2, "create block hashes for reorg detection"},
+		migrate: func(tx *gorm.DB) error {
+			err := createTablesIfNotExist(tx, &bchBlockV2{}, &sbchBlockV2{})
+			if err != nil {
+				return err
+			}
+			return addColumnIfNotExist(tx, &sbch2BchRecordV2{}, "BchUnlockHeight")
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{3, "create status transitions"},
+		migrate: func(tx *gorm.DB) error {
+			return createTablesIfNotExist(tx, &statusTransitionV3{})
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{4, "add sBCH refund tx hash to SBCH2BCH records"},
+		migrate: func(tx *gorm.DB) error {
+			return addColumnIfNotExist(tx, &sbch2BchRecordV4{}, "SbchRefundTxHash")
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{5, "add BCH refund tx hash to BCH2SBCH records"},
+		migrate: func(tx *gorm.DB) error {
+			return addColumnIfNotExist(tx, &bch2SbchRecordV5{}, "BchRefundTxHash")
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{6, "create UTXO reservations"},
+		migrate: func(tx *gorm.DB) error {
+			return createTablesIfNotExist(tx, &utxoReservationV6{})
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{7, "add BCH lock vout to swap records"},
+		migrate: func(tx *gorm.DB) error {
+			err := addColumnIfNotExist(tx, &bch2SbchRecordV7{}, "BchLockVout")
+			if err != nil {
+				return err
+			}
+			return addColumnIfNotExist(tx, &sbch2BchRecordV7{}, "BchLockVout")
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{8, "create pending txs"},
+		migrate: func(tx *gorm.DB) error {
+			return createTablesIfNotExist(tx, &pendingTxV8{})
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{9, "key BCH2SBCH records by BCH lock outpoint"},
+		migrate: func(tx *gorm.DB) error {
+			err := dropUniqueIfExist(tx, &bch2SbchRecordV9{}, "BchLockTxHash")
+			if err != nil {
+				return err
+			}
+			return createIndexesIfNotExist(tx, &bch2SbchRecordV9{})
+		},
+	},
+	{
+		MigrationInfo: MigrationInfo{10, "key scan state by actor"},
+		migrate: func(tx *gorm.DB) error {
+			// sqlite can not add a UNIQUE column, the column and the index are added separately
+			err := addColumnIfNotExist(tx, &lastHeightsActorV10{}, "Actor")
+			if err != nil {
+				return err
+			}
+			if err = createIndexesIfNotExist(tx, &lastHeightsV10{}); err != nil {
+				return err
+			}
+			for _, model := range []any{&bchBlockV10{}, &sbchBlockV10{}} {
+				if err = addColumnIfNotExist(tx, model, "Actor"); err != nil {
+					return err
+				}
+				if err = dropUniqueIfExist(tx, model, "Height"); err != nil {
+					return err
+				}
+				if err = createIndexesIfNotExist(tx, model); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+func createTablesIfNotExist(tx *gorm.DB, models ...any) error {
+	for _, model := range models {
+		if tx.Migrator().HasTable(model) {
+			continue
+		}
+		if err := tx.Migrator().CreateTable(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addColumnIfNotExist(tx *gorm.DB, model any, field string) error {
+	if tx.Migrator().HasColumn(model, field) {
+		return nil
+	}
+	return tx.Migrator().AddColumn(model, field)
+}
+
+// dropUniqueIfExist drops the UNIQUE constraint created by the `unique` tag of the field
+func dropUniqueIfExist(tx *gorm.DB, model any, field string) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	column := stmt.Schema.LookUpField(field).DBName
+	columnTypes, err := tx.Migrator().ColumnTypes(model)
+	if err != nil {
+		return err
+	}
+	for _, columnType := range columnTypes {
+		if columnType.Name() != column {
+			continue
+		}
+		if unique, ok := columnType.Unique(); !ok || !unique {
+			return nil
+		}
+		if tx.Dialector.Name() == "postgres" {
+			// named by postgres as <table>_<column>_key
+			return tx.Migrator().DropConstraint(model, stmt.Schema.Table+"_"+column+"_key")
+		}
+		// sqlite can not alter constraints, the table is recreated with the column definition of the model,
+		// indexes of the old table are dropped and must be created again
+		return tx.Migrator().AlterColumn(model, field)
+	}
+	return nil
+}
+
+func createIndexesIfNotExist(tx *gorm.DB, model any) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	for name := range stmt.Schema.ParseIndexes() {
+		if tx.Migrator().HasIndex(model, name) {
+			continue
+		}
+		if err := tx.Migrator().CreateIndex(model, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 if no migration is applied
+func (db DB) SchemaVersion() (uint, error) {
+	if !db.db.Migrator().HasTable(&SchemaVersion{}) {
+		return 0, nil
+	}
+	var versions []uint
+	result := db.db.Model(&SchemaVersion{}).Order("version DESC").Limit(1).Pluck("version", &versions)
+	if result.Error != nil || len(versions) == 0 {
+		return 0, result.Error
+	}
+	return versions[0], nil
+}
+
+// PendingMigrations returns migrations not applied yet, the DB is not modified
+func (db DB) PendingMigrations() ([]MigrationInfo, error) {
+	currVer, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if currVer > uint(len(migrations)) {
+		return nil, fmt.Errorf("DB schema version %d is newer than supported version %d",
+			currVer, len(migrations))
+	}
+
+	var pending []MigrationInfo
+	for _, m := range migrations {
+		if m.Version > currVer {
+			pending = append(pending, m.MigrationInfo)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations and returns them
+func (db DB) Migrate() ([]MigrationInfo, error) {
+	if err := createTablesIfNotExist(db.db, &SchemaVersion{}); err != nil {
+		return nil, err
+	}
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, info := range pending {
+		m := migrations[info.Version-1]
+		err = db.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.migrate(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return pending[:i], fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// ========== schema snapshots ==========
+// the tables and columns created by each migration, never change them
+
+// schema of DB files created before versioning
+type lastHeightsV1 struct {
+	gorm.Model
+	LastBchHeight  uint64
+	LastSbchHeight uint64
+}
+
+type bch2SbchRecordV1 struct {
+	gorm.Model
+	BchLockHeight    uint64 `gorm:"not null"`
+	BchLockTxHash    string `gorm:"unique"`
+	Value            uint64 `gorm:"not null"`
+	BchPrice         uint64 `gorm:"not null"`
+	RecipientPkh     string `gorm:"not null"`
+	SenderPkh        string `gorm:"not null"`
+	HashLock         string `gorm:"unique"`
+	TimeLock         uint32 `gorm:"not null"`
+	PenaltyBPS       uint16 `gorm:"not null"`
+	SenderEvmAddr    string `gorm:"not null"`
+	HtlcScriptHash   string `gorm:"not null"`
+	SbchLockTxTime   uint64
+	SbchLockTxHash   string
+	SbchUnlockTxHash string
+	Secret           string
+	BchUnlockTxHash  string
+	SbchRefundTxHash string
+	Status           int `gorm:"not null"`
+}
+
+type sbch2BchRecordV1 struct {
+	gorm.Model
+	SbchLockTime     uint64 `gorm:"not null"`
+	SbchLockTxHash   string `gorm:"unique"`
+	Value            uint64 `gorm:"not null"`
+	SbchPrice        uint64 `gorm:"not null"`
+	SbchSenderAddr   string `gorm:"not null"`
+	BchRecipientPkh  string `gorm:"not null"`
+	HashLock         string `gorm:"unique"`
+	TimeLock         uint32 `gorm:"not null"`
+	PenaltyBPS       uint16 `gorm:"not null"`
+	HtlcScriptHash   string `gorm:"not null"`
+	BchLockTxHash    string
+	BchUnlockTxHash  string
+	Secret           string
+	SbchUnlockTxHash string
+	BchRefundTxHash  string
+	Status           int `gorm:"not null"`
+}
+
+type bchBlockV2 struct {
+	gorm.Model
+	Height   uint64 `gorm:"unique"`
+	Hash     string `gorm:"not null"`
+	PrevHash string `gorm:"not null"`
+}
+
+type sbchBlockV2 struct {
+	gorm.Model
+	Height uint64 `gorm:"unique"`
+	Hash   string `gorm:"not null"`
+	Time   uint64 `gorm:"not null"`
+}
+
+type sbch2BchRecordV2 struct {
+	BchUnlockHeight uint64
+}
+
+type statusTransitionV3 struct {
+	gorm.Model
+	RecordID   uint   `gorm:"not null;index"`
+	Direction  string `gorm:"not null"`
+	HashLock   string `gorm:"not null;index"`
+	FromStatus int    `gorm:"not null"`
+	ToStatus   int    `gorm:"not null"`
+	TxHash     string
+	Actor      string
+	Reason     string
+}
+
+type sbch2BchRecordV4 struct {
+	SbchRefundTxHash string
+}
+
+type bch2SbchRecordV5 struct {
+	BchRefundTxHash string
+}
+
+type utxoReservationV6 struct {
+	gorm.Model
+	TxID           string `gorm:"uniqueIndex:idx_utxo_reservations_outpoint;not null"`
+	Vout           uint32 `gorm:"uniqueIndex:idx_utxo_reservations_outpoint"`
+	Value          uint64 `gorm:"not null"`
+	SpendingTxHash string `gorm:"index;not null"`
+	HashLock       string
+}
+
+type bch2SbchRecordV7 struct {
+	BchLockVout uint32 `gorm:"default:0"`
+}
+
+type sbch2BchRecordV7 struct {
+	BchLockVout uint32 `gorm:"default:0"`
+}
+
+type pendingTxV8 struct {
+	gorm.Model
+	Chain     string `gorm:"not null"`
+	TxType    string `gorm:"not null"`
+	TxHash    string `gorm:"uniqueIndex;not null"`
+	RawTx     string `gorm:"not null"`
+	Direction string
+	RecordIDs string
+	HashLock  string
+	ToStatus  int
+	Value     uint64
+	TimeLock  uint32
+}
+
+// the indexes of bch2sbch_records after migration 9
+type bch2SbchRecordV9 struct {
+	gorm.Model
+	BchLockTxHash string `gorm:"uniqueIndex:idx_bch_lock_outpoint"`
+	BchLockVout   uint32 `gorm:"uniqueIndex:idx_bch_lock_outpoint;default:0"`
+}
+
+// rows saved before migration 10 have empty actors, see DB.initScanState
+type lastHeightsActorV10 struct {
+	Actor string `gorm:"default:''"`
+}
+
+type lastHeightsV10 struct {
+	gorm.Model
+	Actor string `gorm:"uniqueIndex;default:''"`
+}
+
+type bchBlockV10 struct {
+	gorm.Model
+	Actor  string `gorm:"uniqueIndex:idx_bch_blocks_actor_height;default:''"`
+	Height uint64 `gorm:"uniqueIndex:idx_bch_blocks_actor_height"`
+}
+
+type sbchBlockV10 struct {
+	gorm.Model
+	Actor  string `gorm:"uniqueIndex:idx_sbch_blocks_actor_height;default:''"`
+	Height uint64 `gorm:"uniqueIndex:idx_sbch_blocks_actor_height"`
+}
+
+func (lastHeightsV1) TableName() string       { return "last_heights" }
+func (bch2SbchRecordV1) TableName() string    { return "bch2_sbch_records" }
+func (sbch2BchRecordV1) TableName() string    { return "sbch2_bch_records" }
+func (bchBlockV2) TableName() string          { return "bch_blocks" }
+func (sbchBlockV2) TableName() string         { return "sbch_blocks" }
+func (sbch2BchRecordV2) TableName() string    { return "sbch2_bch_records" }
+func (statusTransitionV3) TableName() string  { return "status_transitions" }
+func (sbch2BchRecordV4) TableName() string    { return "sbch2_bch_records" }
+func (bch2SbchRecordV5) TableName() string    { return "bch2_sbch_records" }
+func (utxoReservationV6) TableName() string   { return "utxo_reservations" }
+func (bch2SbchRecordV7) TableName() string    { return "bch2_sbch_records" }
+func (sbch2BchRecordV7) TableName() string    { return "sbch2_bch_records" }
+func (pendingTxV8) TableName() string         { return "pending_txes" }
+func (bch2SbchRecordV9) TableName() string    { return "bch2_sbch_records" }
+func (lastHeightsActorV10) TableName() string { return "last_heights" }
+func (lastHeightsV10) TableName() string      { return "last_heights" }
+func (bchBlockV10) TableName() string         { return "bch_blocks" }
+func (sbchBlockV10) TableName() string        { return "sbch_blocks" }
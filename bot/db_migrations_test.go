@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// bch2sbch_records before migration 9, whose BCH lock tx hash is unique
+type bch2SbchRecordV8 struct {
+	gorm.Model
+	BchLockTxHash string `gorm:"unique"`
+	BchLockVout   uint32 `gorm:"default:0"`
+	HashLock      string `gorm:"unique"`
+}
+
+func (bch2SbchRecordV8) TableName() string {
+	return "bch2_sbch_records"
+}
+
+func TestMigrate_newDB(t *testing.T) {
+	db := openTestDB(t)
+
+	ver, err := db.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint(0), ver)
+
+	pending, err := db.PendingMigrations()
+	require.NoError(t, err)
+	require.Len(t, pending, len(migrations))
+	require.Equal(t, uint(1), pending[0].Version)
+	require.False(t, db.db.Migrator().HasTable(&SchemaVersion{})) // dry run does not touch DB
+
+	applied, err := db.Migrate()
+	require.NoError(t, err)
+	require.Equal(t, pending, applied)
+
+	ver, err = db.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint(len(migrations)), ver)
+
+	pending, err = db.PendingMigrations()
+	require.NoError(t, err)
+	require.Len(t, pending, 0)
+
+	applied, err = db.Migrate()
+	require.NoError(t, err)
+	require.Len(t, applied, 0)
+
+	heights, err := db.getLastHeights()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), heights.LastBchHeight)
+	require.Equal(t, uint64(0), heights.LastSbchHeight)
+}
+
+func TestMigrate_legacyDB(t *testing.T) {
+	db := openTestDB(t)
+
+	// simulate DB created by the "no such table" bootstrap of old versions
+	_, err := db.Migrate()
+	require.NoError(t, err)
+	require.NoError(t, db.db.Migrator().DropTable(&SchemaVersion{}, &BchBlock{}, &SbchBlock{}, &StatusTransition{}))
+	require.NoError(t, db.db.Migrator().DropColumn(&Sbch2BchRecord{}, "BchUnlockHeight"))
+	require.NoError(t, db.setLastBchHeight(123))
+	require.NoError(t, db.setLastSbchHeight(456))
+	require.False(t, db.db.Migrator().HasColumn(&Sbch2BchRecord{}, "BchUnlockHeight"))
+
+	applied, err := db.Migrate()
+	require.NoError(t, err)
+	require.Len(t, applied, len(migrations))
+	require.True(t, db.db.Migrator().HasColumn(&Sbch2BchRecord{}, "BchUnlockHeight"))
+	require.True(t, db.db.Migrator().HasTable(&BchBlock{}))
+	require.True(t, db.db.Migrator().HasTable(&StatusTransition{}))
+
+	heights, err := db.getLastHeights()
+	require.NoError(t, err)
+	require.Equal(t, uint64(123), heights.LastBchHeight)
+	require.Equal(t, uint64(456), heights.LastSbchHeight)
+	var n int64
+	require.NoError(t, db.db.Model(&LastHeights{}).Count(&n).Error)
+	require.Equal(t, int64(1), n)
+}
+
+func TestMigrate_bch2SbchLockOutPoint(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.Migrate()
+	require.NoError(t, err)
+	require.NoError(t, db.db.Migrator().DropTable(&Bch2SbchRecord{}))
+	require.NoError(t, db.db.Migrator().CreateTable(&bch2SbchRecordV8{}))
+	require.NoError(t, db.db.Where("version >= ?", 9).Delete(&SchemaVersion{}).Error)
+	require.NoError(t, db.db.Create(&bch2SbchRecordV8{BchLockTxHash: "tx1", BchLockVout: 1, HashLock: "h1"}).Error)
+	require.Error(t, db.db.Create(&bch2SbchRecordV8{BchLockTxHash: "tx1", BchLockVout: 3, HashLock: "h2"}).Error)
+
+	applied, err := db.Migrate()
+	require.NoError(t, err)
+	require.Len(t, applied, len(migrations)-8)
+	require.Equal(t, uint(9), applied[0].Version)
+
+	// records are kept, HTLC outputs of the same tx are accepted
+	var records []*bch2SbchRecordV8
+	require.NoError(t, db.db.Find(&records).Error)
+	require.Len(t, records, 1)
+	require.Equal(t, "h1", records[0].HashLock)
+	require.NoError(t, db.db.Create(&bch2SbchRecordV8{BchLockTxHash: "tx1", BchLockVout: 3, HashLock: "h2"}).Error)
+	require.Error(t, db.db.Create(&bch2SbchRecordV8{BchLockTxHash: "tx1", BchLockVout: 3, HashLock: "h3"}).Error)
+	require.Error(t, db.db.Create(&bch2SbchRecordV8{BchLockTxHash: "tx2", BchLockVout: 1, HashLock: "h1"}).Error)
+	require.True(t, db.db.Migrator().HasIndex(&Bch2SbchRecord{}, "idx_bch2_sbch_records_deleted_at"))
+}
+
+func TestMigrate_scanStateActor(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.Migrate()
+	require.NoError(t, err)
+	require.NoError(t, db.db.Migrator().DropTable(&LastHeights{}, &BchBlock{}, &SbchBlock{}))
+	require.NoError(t, db.db.Migrator().CreateTable(&lastHeightsV1{}, &bchBlockV2{}, &sbchBlockV2{}))
+	require.NoError(t, db.db.Where("version >= ?", 10).Delete(&SchemaVersion{}).Error)
+	require.NoError(t, db.db.Create(&lastHeightsV1{LastBchHeight: 123, LastSbchHeight: 456}).Error)
+	require.NoError(t, db.db.Create(&bchBlockV2{Height: 123, Hash: "hash123", PrevHash: "hash122"}).Error)
+	require.NoError(t, db.db.Create(&sbchBlockV2{Height: 456, Hash: "hash456", Time: 4560}).Error)
+
+	applied, err := db.Migrate()
+	require.NoError(t, err)
+	require.Len(t, applied, len(migrations)-9)
+
+	master := db.withActor(ActorMaster)
+	slave := db.withActor(ActorSlave)
+	require.NoError(t, master.initScanState())
+	require.NoError(t, slave.initScanState())
+	h, err := master.getLastBchHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(123), h)
+	_, err = master.getBchBlock(123)
+	require.NoError(t, err)
+	_, err = master.getSbchBlock(456)
+	require.NoError(t, err)
+	require.NoError(t, slave.addBchBlock(123, "hash123", "hash122"))
+	require.NoError(t, slave.addSbchBlock(456, "hash456", 4560))
+	require.ErrorIs(t, slave.addBchBlock(123, "hash123", "hash122"), ErrDuplicateKey)
+	require.True(t, db.db.Migrator().HasIndex(&BchBlock{}, "idx_bch_blocks_deleted_at"))
+}
+
+// every column and index of the models must be created by migrations
+func TestMigrate_schemaMatchesModels(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.Migrate()
+	require.NoError(t, err)
+
+	models := []any{&Bch2SbchRecord{}, &Sbch2BchRecord{}, &LastHeights{}, &BchBlock{}, &SbchBlock{},
+		&StatusTransition{}, &UtxoReservation{}, &PendingTx{}}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db.db}
+		require.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				require.True(t, db.db.Migrator().HasColumn(model, field.DBName), stmt.Schema.Table+"."+field.DBName)
+			}
+		}
+		for name := range stmt.Schema.ParseIndexes() {
+			require.True(t, db.db.Migrator().HasIndex(model, name), name)
+		}
+	}
+}
+
+func TestMigrate_newerDB(t *testing.T) {
+	db := initDB(t, 123, 456)
+	require.NoError(t, db.db.Create(&SchemaVersion{Version: uint(len(migrations) + 1), Name: "future"}).Error)
+
+	_, err := db.PendingMigrations()
+	require.ErrorContains(t, err, "is newer than supported version")
+	_, err = db.Migrate()
+	require.ErrorContains(t, err, "is newer than supported version")
+}
@@ -0,0 +1,268 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	swapEventsPollInterval = time.Second
+	swapEventsBatchSize    = 500
+	swapEventsSubBufSize   = 256
+	swapEventsKeepAlive    = 15 * time.Second
+)
+
+// SwapEvent is pushed to clients every time a record transitions, ID can be used as resume cursor
+type SwapEvent struct {
+	ID         uint   `json:"id"`
+	Time       int64  `json:"time"`
+	Direction  string `json:"direction"`
+	HashLock   string `json:"hash_lock"`
+	FromStatus string `json:"from_status"` // "None" if the record is created
+	ToStatus   string `json:"to_status"`   // "None" if the record is deleted
+	TxHash     string `json:"tx_hash,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Sender     string `json:"sender,omitempty"`    // same as SwapFilter.Sender
+	Recipient  string `json:"recipient,omitempty"` // same as SwapFilter.Recipient
+}
+
+type swapEventFilter struct {
+	hashLock string
+	address  string // sender or recipient
+}
+
+func (f swapEventFilter) match(ev *SwapEvent) bool {
+	if f.hashLock != "" && f.hashLock != ev.HashLock {
+		return false
+	}
+	if f.address != "" && f.address != ev.Sender && f.address != ev.Recipient {
+		return false
+	}
+	return true
+}
+
+type swapEventSub struct {
+	ch     chan *SwapEvent // closed if the subscriber is too slow
+	filter swapEventFilter
+}
+
+// swapEventHub polls new status transitions from DB and fans them out to subscribers,
+// so that transitions made by another process (e.g. asbot in slave mode) are also pushed
+type swapEventHub struct {
+	mu     sync.Mutex
+	subs   map[*swapEventSub]struct{}
+	lastID uint // last transition ID broadcast
+}
+
+func newSwapEventHub() *swapEventHub {
+	return &swapEventHub{subs: map[*swapEventSub]struct{}{}}
+}
+
+func (hub *swapEventHub) subscribe(filter swapEventFilter) *swapEventSub {
+	sub := &swapEventSub{
+		ch:     make(chan *SwapEvent, swapEventsSubBufSize),
+		filter: filter,
+	}
+	hub.mu.Lock()
+	hub.subs[sub] = struct{}{}
+	hub.mu.Unlock()
+	return sub
+}
+
+func (hub *swapEventHub) unsubscribe(sub *swapEventSub) {
+	hub.mu.Lock()
+	if _, ok := hub.subs[sub]; ok {
+		delete(hub.subs, sub)
+		close(sub.ch)
+	}
+	hub.mu.Unlock()
+}
+
+func (hub *swapEventHub) broadcast(events []*SwapEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, ev := range events {
+		for sub := range hub.subs {
+			if !sub.filter.match(ev) {
+				continue
+			}
+			select {
+			case sub.ch <- ev:
+			default:
+				// the client will reconnect and resume from its last event
+				delete(hub.subs, sub)
+				close(sub.ch)
+			}
+		}
+		hub.lastID = ev.ID
+	}
+}
+
+func (hub *swapEventHub) getLastID() uint {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return hub.lastID
+}
+
+func (bot *MarketMakerBot) runSwapEventHub() {
+	lastID, err := bot.db.getLastStatusTransitionID()
+	if err != nil {
+		log.Fatal("DB error, failed to get last status transition ID: ", err)
+	}
+	bot.swapEvents.mu.Lock()
+	bot.swapEvents.lastID = lastID
+	bot.swapEvents.mu.Unlock()
+
+	for {
+		time.Sleep(swapEventsPollInterval)
+		bot.pollSwapEvents()
+	}
+}
+
+func (bot *MarketMakerBot) pollSwapEvents() {
+	events, err := bot.loadSwapEvents(bot.swapEvents.getLastID(), swapEventsBatchSize)
+	if err != nil {
+		log.Error("DB error, failed to load swap events: ", err)
+		return
+	}
+	bot.swapEvents.broadcast(events)
+}
+
+func (bot *MarketMakerBot) loadSwapEvents(afterID uint, limit int) ([]*SwapEvent, error) {
+	transitions, err := bot.db.getStatusTransitionsAfter(afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*SwapEvent, len(transitions))
+	for i, tr := range transitions {
+		ev := &SwapEvent{
+			ID:         tr.ID,
+			Time:       tr.CreatedAt.Unix(),
+			Direction:  tr.Direction,
+			HashLock:   tr.HashLock,
+			FromStatus: statusName(tr.Direction, tr.FromStatus),
+			ToStatus:   statusName(tr.Direction, tr.ToStatus),
+			TxHash:     tr.TxHash,
+			Reason:     tr.Reason,
+		}
+		// addresses of deleted records are unknown
+		if tr.Direction == DirectionBch2Sbch {
+			if record, err := bot.db.getBch2SbchRecordByID(tr.RecordID); err == nil {
+				ev.Sender, ev.Recipient = record.SenderPkh, record.SenderEvmAddr
+			}
+		} else {
+			if record, err := bot.db.getSbch2BchRecordByID(tr.RecordID); err == nil {
+				ev.Sender, ev.Recipient = record.SbchSenderAddr, record.BchRecipientPkh
+			}
+		}
+		events[i] = ev
+	}
+	return events, nil
+}
+
+func statusName(direction string, status int) string {
+	if status == statusNone {
+		return "None"
+	}
+	if direction == DirectionBch2Sbch {
+		return Bch2SbchStatus(status).String()
+	}
+	return Sbch2BchStatus(status).String()
+}
+
+// stream swap events using Server-Sent Events
+// query params: hash_lock, address, cursor (the Last-Event-ID header takes precedence)
+// without cursor, only transitions after the connection are pushed
+func (bot *MarketMakerBot) handleSwapEvents(w http.ResponseWriter, r *http.Request) {
+	filter := swapEventFilter{
+		hashLock: normalizeHex(r.URL.Query().Get("hash_lock")),
+		address:  normalizeHex(r.URL.Query().Get("address")),
+	}
+
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("cursor")
+	}
+	resume := cursor != ""
+	var lastID uint
+	if resume {
+		n, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			NewErrResp("invalid cursor: " + cursor).WriteTo(w)
+			return
+		}
+		lastID = uint(n)
+	}
+
+	// subscribe before replaying, so that no event is missed
+	sub := bot.swapEvents.subscribe(filter)
+	defer bot.swapEvents.unsubscribe(sub)
+	if !resume {
+		lastID = bot.swapEvents.getLastID()
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // the stream is long-lived, lift the WriteTimeout of the server
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if rc.Flush() != nil {
+		return
+	}
+
+	send := func(ev *SwapEvent) bool {
+		if ev.ID <= lastID || !filter.match(ev) {
+			return true
+		}
+		data, _ := json.Marshal(ev)
+		_, err := fmt.Fprintf(w, "id: %d\nevent: transition\ndata: %s\n\n", ev.ID, data)
+		lastID = ev.ID
+		return err == nil
+	}
+
+	// replay missed events
+	for resume {
+		events, err := bot.loadSwapEvents(lastID, swapEventsBatchSize)
+		if err != nil {
+			log.Error("DB error, failed to load swap events: ", err)
+			return
+		}
+		for _, ev := range events {
+			if !send(ev) {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+		if len(events) < swapEventsBatchSize {
+			break
+		}
+		lastID = events[len(events)-1].ID
+	}
+
+	keepAlive := time.NewTicker(swapEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.ch:
+			if !ok || !send(ev) || rc.Flush() != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapEvents(t *testing.T) {
+	db := initDB(t, 123, 456)
+	bot := &MarketMakerBot{db: db, swapEvents: newSwapEventHub()}
+
+	b2s := createFakeBch2SbchRecord(1)
+	require.NoError(t, db.addBch2SbchRecord(b2s))
+	s2b := createFakeSbch2BchRecord(2)
+	require.NoError(t, db.addSbch2BchRecord(s2b))
+	b2s.UpdateStatusToSbchLocked("sl", 1234)
+	require.NoError(t, db.updateBch2SbchRecord(b2s))
+	bot.pollSwapEvents()
+	require.Equal(t, uint(3), bot.swapEvents.getLastID())
+
+	server := httptest.NewServer(bot.createHttpHandlers())
+	defer server.Close()
+
+	// resume from cursor, filtered by hash lock
+	resp1, err := http.Get(server.URL + "/events?cursor=0&hash_lock=0x1")
+	require.NoError(t, err)
+	defer resp1.Body.Close()
+	require.Equal(t, "text/event-stream", resp1.Header.Get("Content-Type"))
+	events1 := bufio.NewReader(resp1.Body)
+	ev := readSwapEvent(t, events1)
+	require.Equal(t, uint(1), ev.ID)
+	require.Equal(t, DirectionBch2Sbch, ev.Direction)
+	require.Equal(t, "None", ev.FromStatus)
+	require.Equal(t, "New", ev.ToStatus)
+	require.Equal(t, "1", ev.Sender)
+	ev = readSwapEvent(t, events1)
+	require.Equal(t, uint(3), ev.ID)
+	require.Equal(t, "SbchLocked", ev.ToStatus)
+	require.Equal(t, "sl", ev.TxHash)
+
+	// no cursor, filtered by address, the request returns after headers are flushed
+	req, _ := http.NewRequest("GET", server.URL+"/events?address=0x2", nil)
+	resp2, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp2.Body.Close()
+	events2 := bufio.NewReader(resp2.Body)
+
+	// live events
+	b2s.UpdateStatusToSecretRevealed("secret", "su")
+	require.NoError(t, db.updateBch2SbchRecord(b2s))
+	s2b.UpdateStatusToBchLocked("bl", 0)
+	require.NoError(t, db.updateSbch2BchRecord(s2b))
+	bot.pollSwapEvents()
+
+	ev = readSwapEvent(t, events1)
+	require.Equal(t, uint(4), ev.ID)
+	require.Equal(t, "SecretRevealed", ev.ToStatus)
+	ev = readSwapEvent(t, events2)
+	require.Equal(t, uint(5), ev.ID)
+	require.Equal(t, DirectionSbch2Bch, ev.Direction)
+	require.Equal(t, "BchLocked", ev.ToStatus)
+
+	// resume with Last-Event-ID
+	req, _ = http.NewRequest("GET", server.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "3")
+	resp3, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp3.Body.Close()
+	events3 := bufio.NewReader(resp3.Body)
+	require.Equal(t, uint(4), readSwapEvent(t, events3).ID)
+	require.Equal(t, uint(5), readSwapEvent(t, events3).ID)
+}
+
+func readSwapEvent(t *testing.T, r *bufio.Reader) *SwapEvent {
+	var ev SwapEvent
+	var id string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && id != "" {
+			return &ev
+		}
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimPrefix(line, "id: ")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+		}
+	}
+}
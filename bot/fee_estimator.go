@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	feeRateRefreshInterval = time.Minute
+	defaultMinRelayFeeRate = 1 // sats/byte, used if the node does not tell its relay fee
+)
+
+type FeeRateLimits struct {
+	Floor   uint64 // sats/byte
+	Ceiling uint64 // sats/byte
+}
+
+// FeeEstimator adapts BCH miner fee rates to the network,
+// the estimated rate of the node (or the static rate if estimation fails) is clamped by the limits of each tx type,
+// and never drops below the relay fee rate
+type FeeEstimator struct {
+	limits map[string]FeeRateLimits // txType => limits
+
+	estimatedRate uint64 // 0 means estimation failed
+	relayRate     uint64
+	refreshedAt   time.Time
+}
+
+func NewFeeEstimator(lockLimits, unlockLimits, refundLimits FeeRateLimits) (*FeeEstimator, error) {
+	limits := map[string]FeeRateLimits{
+		txTypeLock:   lockLimits,
+		txTypeUnlock: unlockLimits,
+		txTypeRefund: refundLimits,
+	}
+	for txType, l := range limits {
+		if l.Floor == 0 || l.Ceiling < l.Floor {
+			return nil, fmt.Errorf("invalid %s fee rate limits: [%d, %d]", txType, l.Floor, l.Ceiling)
+		}
+	}
+	return &FeeEstimator{limits: limits}, nil
+}
+
+func (fe *FeeEstimator) getFeeRate(txType string, staticRate uint64) uint64 {
+	rate := fe.estimatedRate
+	if rate == 0 {
+		rate = staticRate
+	}
+	if l, ok := fe.limits[txType]; ok {
+		if rate < l.Floor {
+			rate = l.Floor
+		}
+		if rate > l.Ceiling {
+			rate = l.Ceiling
+		}
+	}
+	if rate < fe.relayRate {
+		rate = fe.relayRate
+	}
+	return rate
+}
+
+// returns the miner fee rate of BCH lock|unlock|refund tx, in sats/byte
+func (bot *MarketMakerBot) getBchFeeRate(txType string) uint64 {
+	var staticRate uint64
+	switch txType {
+	case txTypeLock:
+		staticRate = bot.bchLockMinerFeeRate
+	case txTypeUnlock:
+		staticRate = bot.bchUnlockMinerFeeRate
+	case txTypeRefund:
+		staticRate = bot.bchRefundMinerFeeRate
+	}
+
+	fe := bot.feeEstimator
+	if fe == nil {
+		return staticRate
+	}
+	if time.Since(fe.refreshedAt) >= feeRateRefreshInterval {
+		bot.refreshBchFeeRates()
+	}
+	return fe.getFeeRate(txType, staticRate)
+}
+
+func (bot *MarketMakerBot) refreshBchFeeRates() {
+	fe := bot.feeEstimator
+	fe.refreshedAt = time.Now()
+
+	estimatedRate, err := bot.bchCli.EstimateFeeRate()
+	if err != nil {
+		bot.logError("RPC error, failed to estimate BCH fee rate: ", err)
+		estimatedRate = 0
+	}
+	relayRate, err := bot.bchCli.GetMempoolMinFeeRate()
+	if err != nil {
+		bot.logError("RPC error, failed to get BCH mempool min fee rate: ", err)
+		relayRate = defaultMinRelayFeeRate
+	}
+	if relayRate < defaultMinRelayFeeRate {
+		relayRate = defaultMinRelayFeeRate
+	}
+
+	log.Infof("BCH fee rate, estimated: %d, relay: %d", estimatedRate, relayRate)
+	fe.estimatedRate = estimatedRate
+	fe.relayRate = relayRate
+}
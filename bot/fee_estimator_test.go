@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFeeEstimator(t *testing.T) {
+	ok := FeeRateLimits{Floor: 1, Ceiling: 10}
+	_, err := NewFeeEstimator(FeeRateLimits{Floor: 0, Ceiling: 10}, ok, ok)
+	require.ErrorContains(t, err, "invalid lock fee rate limits: [0, 10]")
+	_, err = NewFeeEstimator(ok, FeeRateLimits{Floor: 5, Ceiling: 4}, ok)
+	require.ErrorContains(t, err, "invalid unlock fee rate limits: [5, 4]")
+	_, err = NewFeeEstimator(ok, ok, ok)
+	require.NoError(t, err)
+}
+
+func TestGetBchFeeRate(t *testing.T) {
+	bchCli := newMockBchClient(122, 123)
+	_bot := &MarketMakerBot{
+		bchCli:                bchCli,
+		bchLockMinerFeeRate:   2,
+		bchUnlockMinerFeeRate: 3,
+		bchRefundMinerFeeRate: 4,
+		errLogQueue:           newErrLogQueue(100),
+	}
+
+	// static rates
+	require.Equal(t, uint64(2), _bot.getBchFeeRate(txTypeLock))
+	require.Equal(t, uint64(3), _bot.getBchFeeRate(txTypeUnlock))
+	require.Equal(t, uint64(4), _bot.getBchFeeRate(txTypeRefund))
+
+	fe, err := NewFeeEstimator(
+		FeeRateLimits{Floor: 1, Ceiling: 5},
+		FeeRateLimits{Floor: 2, Ceiling: 20},
+		FeeRateLimits{Floor: 5, Ceiling: 20},
+	)
+	require.NoError(t, err)
+	_bot.feeEstimator = fe
+	refresh := func() {
+		fe.refreshedAt = time.Time{}
+	}
+
+	// estimation failed, static rates are clamped
+	require.Equal(t, uint64(2), _bot.getBchFeeRate(txTypeLock))
+	require.Equal(t, uint64(3), _bot.getBchFeeRate(txTypeUnlock))
+	require.Equal(t, uint64(5), _bot.getBchFeeRate(txTypeRefund))
+
+	// estimated rates are cached
+	bchCli.feeRate = 8
+	bchCli.minFeeRate = 1
+	require.Equal(t, uint64(2), _bot.getBchFeeRate(txTypeLock))
+
+	// estimated rates are clamped
+	refresh()
+	require.Equal(t, uint64(5), _bot.getBchFeeRate(txTypeLock))
+	require.Equal(t, uint64(8), _bot.getBchFeeRate(txTypeUnlock))
+	require.Equal(t, uint64(8), _bot.getBchFeeRate(txTypeRefund))
+
+	bchCli.feeRate = 1
+	refresh()
+	require.Equal(t, uint64(1), _bot.getBchFeeRate(txTypeLock))
+	require.Equal(t, uint64(2), _bot.getBchFeeRate(txTypeUnlock))
+	require.Equal(t, uint64(5), _bot.getBchFeeRate(txTypeRefund))
+
+	// never below relay fee rate
+	bchCli.minFeeRate = 30
+	refresh()
+	require.Equal(t, uint64(30), _bot.getBchFeeRate(txTypeLock))
+	require.Equal(t, uint64(30), _bot.getBchFeeRate(txTypeUnlock))
+	require.Equal(t, uint64(30), _bot.getBchFeeRate(txTypeRefund))
+}
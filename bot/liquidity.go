@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+// reserved for the miner fee of each BCH lock tx, in sats
+const bchLockFeeReserve = 5000
+
+// Reservation is the liquidity reserved for an admitted swap which the bot has not locked coins for
+type Reservation struct {
+	HashLock string `json:"hash_lock"`
+	Value    uint64 `json:"value"` // in sats
+}
+
+// LiquidityBook is kept across rounds of handleBchUserDeposits|handleSbchUserDeposits,
+// the asset is sBCH for BCH2SBCH swaps and BCH for SBCH2BCH swaps.
+// A reservation is kept until the locked coins are deducted from the free balance:
+// until the sBCH lock tx is mined, or until the BCH lock tx is sent (its UTXOs are no longer free)
+type LiquidityBook struct {
+	Free         uint64         `json:"free"`     // in sats, balance at the start of the last round, minus BCH locked in the round
+	Reserved     uint64         `json:"reserved"` // in sats, sum of reservations
+	Reservations []*Reservation `json:"reservations"`
+	UpdatedAt    int64          `json:"updated_at"`
+}
+
+// the reservation book is read by the HTTP server
+type liquidityBooks struct {
+	mu    sync.Mutex
+	books map[string]*LiquidityBook // direction => book
+}
+
+// update is called at the start of each round with the free balance,
+// and the reservations whose coins are still in the free balance (e.g. sBCH lock txs which are not mined),
+// other reservations are dropped
+func (lb *liquidityBooks) update(direction string, free uint64, committed []*Reservation) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if lb.books == nil {
+		lb.books = map[string]*LiquidityBook{}
+	}
+	book := &LiquidityBook{
+		Free:         free,
+		Reservations: []*Reservation{},
+		UpdatedAt:    time.Now().Unix(),
+	}
+	if old := lb.books[direction]; old != nil {
+		// keep the order of reservations which are still committed
+		for _, r := range old.Reservations {
+			if idx := findReservation(committed, r.HashLock); idx >= 0 {
+				book.Reservations = append(book.Reservations, committed[idx])
+			}
+		}
+	}
+	for _, r := range committed {
+		if findReservation(book.Reservations, r.HashLock) < 0 {
+			book.Reservations = append(book.Reservations, r)
+		}
+	}
+	for _, r := range book.Reservations {
+		book.Reserved += r.Value
+	}
+	lb.books[direction] = book
+}
+
+// reserve returns false and the available liquidity if the free balance minus reservations is not enough
+func (lb *liquidityBooks) reserve(direction, hashLock string, val uint64) (available uint64, ok bool) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	book := lb.books[direction]
+	if book == nil {
+		return 0, false
+	}
+	if book.Free > book.Reserved {
+		available = book.Free - book.Reserved
+	}
+	if findReservation(book.Reservations, hashLock) >= 0 {
+		return available, true // reserved in the last round
+	}
+	if val > available {
+		return available, false
+	}
+	book.Reserved += val
+	book.Reservations = append(book.Reservations, &Reservation{HashLock: hashLock, Value: val})
+	return available, true
+}
+
+// commit is called after the bot sent the BCH lock tx for the reserved swap, the coins are no longer free
+func (lb *liquidityBooks) commit(direction, hashLock string) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	book := lb.books[direction]
+	if book == nil {
+		return
+	}
+	for i, r := range book.Reservations {
+		if r.HashLock == hashLock {
+			if book.Free > r.Value {
+				book.Free -= r.Value
+			} else {
+				book.Free = 0
+			}
+			book.Reserved -= r.Value
+			book.Reservations = append(book.Reservations[:i], book.Reservations[i+1:]...)
+			return
+		}
+	}
+}
+
+// release is called if the bot failed to lock coins for the reserved swap, the coins are still free
+func (lb *liquidityBooks) release(direction, hashLock string) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	book := lb.books[direction]
+	if book == nil {
+		return
+	}
+	for i, r := range book.Reservations {
+		if r.HashLock == hashLock {
+			book.Reserved -= r.Value
+			book.Reservations = append(book.Reservations[:i], book.Reservations[i+1:]...)
+			return
+		}
+	}
+}
+
+// snapshot returns copies of all books
+func (lb *liquidityBooks) snapshot() map[string]*LiquidityBook {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	result := map[string]*LiquidityBook{}
+	for direction, book := range lb.books {
+		bookCopy := *book
+		bookCopy.Reservations = make([]*Reservation, len(book.Reservations))
+		for i, r := range book.Reservations {
+			rCopy := *r
+			bookCopy.Reservations[i] = &rCopy
+		}
+		result[direction] = &bookCopy
+	}
+	return result
+}
+
+func findReservation(reservations []*Reservation, hashLock string) int {
+	for i, r := range reservations {
+		if r.HashLock == hashLock {
+			return i
+		}
+	}
+	return -1
+}
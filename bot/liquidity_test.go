@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiquidityBooks(t *testing.T) {
+	var lb liquidityBooks
+	_, ok := lb.reserve(DirectionBch2Sbch, "h1", 1)
+	require.False(t, ok)
+	lb.commit(DirectionBch2Sbch, "h1")
+	require.Len(t, lb.snapshot(), 0)
+
+	lb.update(DirectionBch2Sbch, 100, nil)
+	lb.update(DirectionSbch2Bch, 50, nil)
+	available, ok := lb.reserve(DirectionBch2Sbch, "h1", 60)
+	require.True(t, ok)
+	require.Equal(t, uint64(100), available)
+	available, ok = lb.reserve(DirectionBch2Sbch, "h2", 50)
+	require.False(t, ok)
+	require.Equal(t, uint64(40), available)
+	_, ok = lb.reserve(DirectionBch2Sbch, "h3", 40)
+	require.True(t, ok)
+	_, ok = lb.reserve(DirectionSbch2Bch, "h4", 50)
+	require.True(t, ok)
+
+	books := lb.snapshot()
+	require.Equal(t, uint64(100), books[DirectionBch2Sbch].Free)
+	require.Equal(t, uint64(100), books[DirectionBch2Sbch].Reserved)
+	require.Equal(t, []*Reservation{{"h1", 60}, {"h3", 40}}, books[DirectionBch2Sbch].Reservations)
+	require.Equal(t, []*Reservation{{"h4", 50}}, books[DirectionSbch2Bch].Reservations)
+
+	// coins locked for h1 are no longer free
+	lb.commit(DirectionBch2Sbch, "h1")
+	books = lb.snapshot()
+	require.Equal(t, uint64(40), books[DirectionBch2Sbch].Free)
+	require.Equal(t, uint64(40), books[DirectionBch2Sbch].Reserved)
+	require.Equal(t, []*Reservation{{"h3", 40}}, books[DirectionBch2Sbch].Reservations)
+
+	// coins reserved for h3 are still free if they are not locked
+	lb.release(DirectionBch2Sbch, "h3")
+	lb.release(DirectionBch2Sbch, "h5")
+	books = lb.snapshot()
+	require.Equal(t, uint64(40), books[DirectionBch2Sbch].Free)
+	require.Equal(t, uint64(0), books[DirectionBch2Sbch].Reserved)
+	require.Len(t, books[DirectionBch2Sbch].Reservations, 0)
+	_, ok = lb.reserve(DirectionBch2Sbch, "h3", 40)
+	require.True(t, ok)
+	books = lb.snapshot()
+
+	// snapshot is a copy
+	books[DirectionBch2Sbch].Reservations[0].Value = 1
+	require.Equal(t, uint64(40), lb.snapshot()[DirectionBch2Sbch].Reservations[0].Value)
+
+	lb.update(DirectionBch2Sbch, 10, nil)
+	books = lb.snapshot()
+	require.Equal(t, uint64(10), books[DirectionBch2Sbch].Free)
+	require.Len(t, books[DirectionBch2Sbch].Reservations, 0)
+	require.Len(t, books[DirectionSbch2Bch].Reservations, 1)
+}
+
+func TestLiquidityBooks_keptAcrossRounds(t *testing.T) {
+	var lb liquidityBooks
+	lb.update(DirectionBch2Sbch, 100, []*Reservation{{"h1", 10}})
+	_, ok := lb.reserve(DirectionBch2Sbch, "h2", 20)
+	require.True(t, ok)
+	_, ok = lb.reserve(DirectionBch2Sbch, "h3", 30)
+	require.True(t, ok)
+	lb.release(DirectionBch2Sbch, "h3")
+
+	// the book is not emptied at the end of the round
+	books := lb.snapshot()
+	require.Equal(t, uint64(100), books[DirectionBch2Sbch].Free)
+	require.Equal(t, uint64(30), books[DirectionBch2Sbch].Reserved)
+	require.Equal(t, []*Reservation{{"h1", 10}, {"h2", 20}}, books[DirectionBch2Sbch].Reservations)
+
+	// h1 is mined, h2 is still committed, h4 is found after restart
+	lb.update(DirectionBch2Sbch, 90, []*Reservation{{"h4", 40}, {"h2", 20}})
+	books = lb.snapshot()
+	require.Equal(t, uint64(90), books[DirectionBch2Sbch].Free)
+	require.Equal(t, uint64(60), books[DirectionBch2Sbch].Reserved)
+	require.Equal(t, []*Reservation{{"h2", 20}, {"h4", 40}}, books[DirectionBch2Sbch].Reservations)
+
+	// committed reservations are not made twice
+	available, ok := lb.reserve(DirectionBch2Sbch, "h2", 20)
+	require.True(t, ok)
+	require.Equal(t, uint64(30), available)
+	require.Equal(t, uint64(60), lb.snapshot()[DirectionBch2Sbch].Reserved)
+}
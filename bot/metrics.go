@@ -0,0 +1,210 @@
+package bot
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	metricsNamespace = "asbot"
+
+	chainBch  = "bch"
+	chainSbch = "sbch"
+
+	txTypeLock   = "lock"
+	txTypeUnlock = "unlock"
+	txTypeRefund = "refund"
+
+	txTypeConsolidate = "consolidate"
+	txTypeCpfp        = "cpfp"
+
+	txTypeUpdatePrices = "update_prices"
+	txTypeReplace      = "replace"
+)
+
+// botMetrics is nil-safe, so bots created without metrics (e.g. in tests) also work
+type botMetrics struct {
+	registry *prometheus.Registry
+
+	chainTipHeight *prometheus.GaugeVec     // chain
+	txsSent        *prometheus.CounterVec   // chain, type
+	txsFailed      *prometheus.CounterVec   // chain, type
+	errors         *prometheus.CounterVec   // kind
+	stageDuration  *prometheus.HistogramVec // stage
+}
+
+func newBotMetrics() *botMetrics {
+	m := &botMetrics{
+		registry: prometheus.NewRegistry(),
+		chainTipHeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "chain_tip_height",
+			Help:      "Latest block height reported by the node.",
+		}, []string{"chain"}),
+		txsSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "txs_sent_total",
+			Help:      "Number of txs sent by the bot.",
+		}, []string{"chain", "type"}),
+		txsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "txs_failed_total",
+			Help:      "Number of txs the bot failed to send.",
+		}, []string{"chain", "type"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "Number of logged errors, by kind (rpc, db, other).",
+		}, []string{"kind"}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "loop_stage_duration_seconds",
+			Help:      "Duration of each stage of the main loop.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10, 30, 60},
+		}, []string{"stage"}),
+	}
+	m.registry.MustRegister(
+		m.chainTipHeight,
+		m.txsSent,
+		m.txsFailed,
+		m.errors,
+		m.stageDuration,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *botMetrics) setChainTipHeight(chain string, h uint64) {
+	if m != nil {
+		m.chainTipHeight.WithLabelValues(chain).Set(float64(h))
+	}
+}
+
+func (m *botMetrics) incTxsSent(chain, txType string) {
+	if m != nil {
+		m.txsSent.WithLabelValues(chain, txType).Inc()
+	}
+}
+
+func (m *botMetrics) incTxsFailed(chain, txType string) {
+	if m != nil {
+		m.txsFailed.WithLabelValues(chain, txType).Inc()
+	}
+}
+
+// msg is the message passed to logError, e.g. "RPC error, failed to get BCH height: "
+func (m *botMetrics) incErrors(msg string) {
+	if m == nil {
+		return
+	}
+	kind := "other"
+	if strings.HasPrefix(msg, "RPC error") {
+		kind = "rpc"
+	} else if strings.HasPrefix(msg, "DB error") {
+		kind = "db"
+	}
+	m.errors.WithLabelValues(kind).Inc()
+}
+
+func (m *botMetrics) observeStage(stage string, start time.Time) {
+	if m != nil {
+		m.stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *botMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// botCollector collects metrics from DB and nodes at scrape time
+type botCollector struct {
+	bot *MarketMakerBot
+
+	lastHeightDesc *prometheus.Desc
+	recordsDesc    *prometheus.Desc
+	balanceDesc    *prometheus.Desc
+}
+
+func newBotCollector(bot *MarketMakerBot) *botCollector {
+	return &botCollector{
+		bot: bot,
+		lastHeightDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "last_scanned_height"),
+			"Last block height scanned by the bot.",
+			[]string{"chain"}, nil),
+		recordsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "records"),
+			"Number of swap records, by direction and status.",
+			[]string{"direction", "status"}, nil),
+		balanceDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "balance"),
+			"Balance of the bot in BCH, kind is free, locked or to_be_unlocked.",
+			[]string{"chain", "kind"}, nil),
+	}
+}
+
+func (c *botCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lastHeightDesc
+	ch <- c.recordsDesc
+	ch <- c.balanceDesc
+}
+
+func (c *botCollector) Collect(ch chan<- prometheus.Metric) {
+	bot := c.bot
+
+	if heights, err := bot.db.getLastHeights(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.lastHeightDesc, prometheus.GaugeValue,
+			float64(heights.LastBchHeight), chainBch)
+		ch <- prometheus.MustNewConstMetric(c.lastHeightDesc, prometheus.GaugeValue,
+			float64(heights.LastSbchHeight), chainSbch)
+	} else {
+		log.Error("metrics: failed to get last heights: ", err)
+	}
+
+	if counts, err := bot.db.countBch2SbchRecordsByStatus(); err == nil {
+		for status := Bch2SbchStatusNew; status <= Bch2SbchStatusInsufficientLiquidity; status++ {
+			ch <- prometheus.MustNewConstMetric(c.recordsDesc, prometheus.GaugeValue,
+				float64(counts[status]), DirectionBch2Sbch, status.String())
+		}
+	} else {
+		log.Error("metrics: failed to count BCH2SBCH records: ", err)
+	}
+	if counts, err := bot.db.countSbch2BchRecordsByStatus(); err == nil {
+		for status := Sbch2BchStatusNew; status <= Sbch2BchStatusInsufficientLiquidity; status++ {
+			ch <- prometheus.MustNewConstMetric(c.recordsDesc, prometheus.GaugeValue,
+				float64(counts[status]), DirectionSbch2Bch, status.String())
+		}
+	} else {
+		log.Error("metrics: failed to count SBCH2BCH records: ", err)
+	}
+
+	if toBeUnlockedSbch, lockedBch, _, err := bot.getSbch2BchInfo(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.balanceDesc, prometheus.GaugeValue, lockedBch, chainBch, "locked")
+		ch <- prometheus.MustNewConstMetric(c.balanceDesc, prometheus.GaugeValue, toBeUnlockedSbch, chainSbch, "to_be_unlocked")
+	} else {
+		log.Error("metrics: failed to query SBCH2BCH records: ", err)
+	}
+	if toBeUnlockedBch, lockedSbch, _, err := bot.getBch2SbchInfo(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.balanceDesc, prometheus.GaugeValue, lockedSbch, chainSbch, "locked")
+		ch <- prometheus.MustNewConstMetric(c.balanceDesc, prometheus.GaugeValue, toBeUnlockedBch, chainBch, "to_be_unlocked")
+	} else {
+		log.Error("metrics: failed to query BCH2SBCH records: ", err)
+	}
+
+	if freeBch, err := bot.getFreeBch(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.balanceDesc, prometheus.GaugeValue, satsToUtxoAmt(freeBch), chainBch, "free")
+	} else {
+		log.Error("metrics: failed to query UTXOs: ", err)
+	}
+	if freeSbch, err := bot.getFreeSbch(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.balanceDesc, prometheus.GaugeValue, satsToUtxoAmt(freeSbch), chainSbch, "free")
+	} else {
+		log.Error("metrics: failed to query sBCH balance: ", err)
+	}
+}
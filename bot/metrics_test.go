@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBotMetrics_nilSafe(t *testing.T) {
+	var m *botMetrics
+	m.setChainTipHeight(chainBch, 123)
+	m.incTxsSent(chainBch, txTypeLock)
+	m.incTxsFailed(chainBch, txTypeLock)
+	m.incErrors("RPC error, failed to get BCH height: ")
+	m.observeStage("scanBchBlocks", time.Now())
+
+	bot := &MarketMakerBot{errLogQueue: newErrLogQueue(100)}
+	bot.logError("RPC error, test: ", io.EOF)
+	bot.runStage("test", func() {})
+}
+
+func TestBotMetrics(t *testing.T) {
+	m := newBotMetrics()
+	m.setChainTipHeight(chainSbch, 456)
+	m.incTxsSent(chainBch, txTypeLock)
+	m.incTxsSent(chainBch, txTypeLock)
+	m.incTxsFailed(chainSbch, txTypeRefund)
+	m.incErrors("RPC error, failed to get BCH height: ")
+	m.incErrors("DB error, failed to update status of BCH2SBCH record: ")
+	m.incErrors("DB error, failed to update status of SBCH2BCH record: ")
+	m.incErrors("failed to create BCH tx: ")
+	m.observeStage("scanBchBlocks", time.Now())
+
+	require.Equal(t, 456.0, testutil.ToFloat64(m.chainTipHeight.WithLabelValues(chainSbch)))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.txsSent.WithLabelValues(chainBch, txTypeLock)))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.txsSent.WithLabelValues(chainSbch, txTypeLock)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.txsFailed.WithLabelValues(chainSbch, txTypeRefund)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("rpc")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.errors.WithLabelValues("db")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("other")))
+
+	bot := &MarketMakerBot{metrics: m}
+	w := httptest.NewRecorder()
+	bot.createHttpHandlers().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), `asbot_chain_tip_height{chain="sbch"} 456`)
+	require.Contains(t, w.Body.String(), `asbot_txs_sent_total{chain="bch",type="lock"} 2`)
+	require.Contains(t, w.Body.String(), `asbot_loop_stage_duration_seconds_count{stage="scanBchBlocks"} 1`)
+}
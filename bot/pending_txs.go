@@ -0,0 +1,219 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	gethcmn "github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/gcash/bchd/wire"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/smartbch/atomic-swap-bot/htlcbch"
+)
+
+// returns the status transition which is done once the sBCH tx is mined, statusNone if no record is changed
+func sbchTxTransition(txType string) (direction string, toStatus int) {
+	switch txType {
+	case txTypeLock:
+		return DirectionBch2Sbch, int(Bch2SbchStatusSbchLocked)
+	case txTypeUnlock:
+		return DirectionSbch2Bch, int(Sbch2BchStatusSbchUnlocked)
+	case txTypeRefund:
+		return DirectionBch2Sbch, int(Bch2SbchStatusSbchRefunded)
+	default:
+		return "", statusNone
+	}
+}
+
+// tx is ptx.tx or its replacement
+func newSbchPendingTx(ptx *pendingSbchTx, tx *gethtypes.Transaction) (*PendingTx, error) {
+	rawTx, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tx: %w", err)
+	}
+	var recordIDs []uint
+	if ptx.recordID > 0 {
+		recordIDs = []uint{ptx.recordID}
+	}
+	txHash := tx.Hash()
+	direction, toStatus := sbchTxTransition(ptx.txType)
+	return &PendingTx{
+		Chain:     chainSbch,
+		TxType:    ptx.txType,
+		TxHash:    toHex(txHash[:]),
+		RawTx:     toHex(rawTx),
+		Direction: direction,
+		RecordIDs: joinRecordIDs(recordIDs),
+		HashLock:  ptx.hashLock,
+		ToStatus:  toStatus,
+		Value:     ptx.value,
+		TimeLock:  ptx.timeLock,
+	}, nil
+}
+
+// output#0 of the tx is the HTLC output of the record
+func newBchLockPendingTx(tx *wire.MsgTx, record *Sbch2BchRecord) *PendingTx {
+	return &PendingTx{
+		Chain:     chainBch,
+		TxType:    txTypeLock,
+		TxHash:    tx.TxHash().String(),
+		RawTx:     htlcbch.MsgTxToHex(tx),
+		Direction: DirectionSbch2Bch,
+		RecordIDs: joinRecordIDs([]uint{record.ID}),
+		HashLock:  record.HashLock,
+		ToStatus:  int(Sbch2BchStatusBchLocked),
+	}
+}
+
+func joinRecordIDs(ids []uint) string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(strs, ",")
+}
+
+func parseRecordIDs(s string) ([]uint, error) {
+	if s == "" {
+		return nil, nil
+	}
+	strs := strings.Split(s, ",")
+	ids := make([]uint, len(strs))
+	for i, str := range strs {
+		id, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record ID: %s", str)
+		}
+		ids[i] = uint(id)
+	}
+	return ids, nil
+}
+
+// recoverPendingTxs finishes or rolls back status transitions of the txs which are saved before the bot exits:
+// BCH lock txs known by the node are regarded as sent, and their records are updated to BchLocked,
+// otherwise their UTXOs are released and the records are left New to be locked again;
+// sBCH txs are checked by checkPendingSbchTxs, which updates the records if the txs are mined
+// and leaves the records unchanged if the txs are failed or dropped.
+// returns false if it should be retried
+func (bot *MarketMakerBot) recoverPendingTxs() bool {
+	rows, err := bot.db.getPendingTxs()
+	if err != nil {
+		bot.logError("DB error, failed to get pending txs: ", err)
+		return false
+	}
+	if len(rows) == 0 {
+		return true
+	}
+
+	log.Info("recover pending txs: ", len(rows))
+	var sbchRows []*PendingTx
+	for _, row := range rows {
+		switch {
+		case row.Chain == chainSbch:
+			sbchRows = append(sbchRows, row)
+		case row.Chain == chainBch && row.TxType == txTypeLock:
+			if !bot.recoverBchLockTx(row) {
+				return false
+			}
+		default:
+			bot.logWarnf("unknown pending tx, chain: %s, type: %s, hash: %s", row.Chain, row.TxType, row.TxHash)
+		}
+	}
+	bot.recoverSbchTxs(sbchRows)
+	return true
+}
+
+func (bot *MarketMakerBot) recoverBchLockTx(row *PendingTx) bool {
+	log.Info("recover BCH lock tx: ", row.TxHash, ", records: ", row.RecordIDs)
+	recordIDs, err := parseRecordIDs(row.RecordIDs)
+	if err != nil {
+		bot.logError("failed to parse pending tx: ", err)
+		return true // the transition can not be finished, leave the tx for manual check
+	}
+
+	_, err = bot.bchCli.GetTxConfirmations(row.TxHash)
+	if err != nil && !isTxNotFoundErr(err) {
+		bot.logError("RPC error, failed to get tx confirmations: ", err)
+		return false
+	}
+	if err != nil {
+		bot.logWarnf("BCH lock tx is not sent: %s, hashLock: %s", row.TxHash, row.HashLock)
+		err = bot.db.deleteUtxoReservationsBySpendingTx(row.TxHash)
+		if err != nil {
+			bot.logError("DB error, failed to release UTXOs: ", err)
+			return false
+		}
+	} else {
+		for _, id := range recordIDs {
+			record, err := bot.db.getSbch2BchRecordByID(id)
+			if err != nil {
+				bot.logError("DB error, failed to get SBCH2BCH record: ", err)
+				return false
+			}
+			if record.Status != Sbch2BchStatusNew {
+				continue // updated before the bot exits
+			}
+			record.UpdateStatusToBchLocked(row.TxHash, 0) // see newBchLockPendingTx()
+			err = bot.db.updateSbch2BchRecord(record.withReason("recovered BCH lock tx"))
+			if err != nil {
+				bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+				return false
+			}
+		}
+	}
+
+	err = bot.db.deletePendingTxs([]string{row.TxHash})
+	if err != nil {
+		bot.logError("DB error, failed to delete pending tx: ", err)
+		return false
+	}
+	return true
+}
+
+// rows are in the order of saving, so a replacement tx comes after the txs replaced by it
+func (bot *MarketMakerBot) recoverSbchTxs(rows []*PendingTx) {
+	byNonce := map[uint64]*pendingSbchTx{}
+	for _, row := range rows {
+		tx := new(gethtypes.Transaction)
+		err := tx.UnmarshalBinary(gethcmn.FromHex(row.RawTx))
+		if err != nil {
+			bot.logError("failed to decode pending tx: ", err)
+			continue
+		}
+
+		if ptx := byNonce[tx.Nonce()]; ptx != nil {
+			ptx.replacedTxHashes = append(ptx.replacedTxHashes, ptx.tx.Hash())
+			ptx.tx = tx
+			ptx.sentAt = row.CreatedAt
+			continue
+		}
+
+		recordIDs, err := parseRecordIDs(row.RecordIDs)
+		if err != nil {
+			bot.logError("failed to parse pending tx: ", err)
+			continue
+		}
+		ptx := &pendingSbchTx{
+			tx:       tx,
+			txType:   row.TxType,
+			hashLock: row.HashLock,
+			value:    row.Value,
+			timeLock: row.TimeLock,
+			sentAt:   row.CreatedAt,
+			resentAt: time.Time{}, // the tx may not be sent, resend it at once
+		}
+		if len(recordIDs) > 0 {
+			ptx.recordID = recordIDs[0]
+		}
+		byNonce[tx.Nonce()] = ptx
+	}
+
+	for _, ptx := range byNonce {
+		log.Info("recover sBCH tx: ", ptx.tx.Hash().String(), ", nonce: ", ptx.tx.Nonce(),
+			", type: ", ptx.txType, ", hashLock: ", ptx.hashLock)
+		bot.addPendingSbchTx(ptx)
+	}
+}
@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordIDs(t *testing.T) {
+	require.Equal(t, "", joinRecordIDs(nil))
+	require.Equal(t, "1,23,4", joinRecordIDs([]uint{1, 23, 4}))
+
+	ids, err := parseRecordIDs("")
+	require.NoError(t, err)
+	require.Len(t, ids, 0)
+	ids, err = parseRecordIDs("1,23,4")
+	require.NoError(t, err)
+	require.Equal(t, []uint{1, 23, 4}, ids)
+	_, err = parseRecordIDs("1,x")
+	require.ErrorContains(t, err, "invalid record ID: x")
+}
+
+func TestSendSbchTx_saved(t *testing.T) {
+	_bot, _sbchCli := newTestBotWithNewBch2SbchRecords(t, 2)
+
+	// the tx is not saved if it is not sent
+	_sbchCli.sendTxErr = errors.New("failed to send tx")
+	_bot.handleBchUserDeposits()
+	require.Len(t, _bot.pendingSbchTxs, 0)
+	rows, err := _bot.db.getPendingTxs()
+	require.NoError(t, err)
+	require.Len(t, rows, 0)
+
+	_sbchCli.sendTxErr = nil
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 2)
+	rows, err = _bot.db.getPendingTxs()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, chainSbch, rows[0].Chain)
+	require.Equal(t, txTypeLock, rows[0].TxType)
+	require.Equal(t, toHex(_sbchCli.sentTxs[0].Hash().Bytes()), rows[0].TxHash)
+	require.Equal(t, DirectionBch2Sbch, rows[0].Direction)
+	require.Equal(t, "1", rows[0].RecordIDs)
+	require.Equal(t, toHex(gethHash32Bytes("hash0")), rows[0].HashLock)
+	require.Equal(t, int(Bch2SbchStatusSbchLocked), rows[0].ToStatus)
+	require.Equal(t, uint64(1e7), rows[0].Value)
+	require.Equal(t, uint32(30000), rows[0].TimeLock)
+
+	// removed once mined
+	_sbchCli.mineTx(_sbchCli.sentTxs[0], gethtypes.ReceiptStatusSuccessful)
+	_bot.checkPendingSbchTxs()
+	rows, err = _bot.db.getPendingTxs()
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "2", rows[0].RecordIDs)
+}
+
+func TestRecoverPendingTxs_sbch(t *testing.T) {
+	_bot, _sbchCli := newTestBotWithNewBch2SbchRecords(t, 3)
+	_sbchCli.gasPrice = big.NewInt(1000)
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 3)
+	tx0, tx1, tx2 := _sbchCli.sentTxs[0], _sbchCli.sentTxs[1], _sbchCli.sentTxs[2]
+
+	// tx0 is replaced before the bot exits
+	_bot.sbchGasOracle = newTestSbchGasOracle(t)
+	require.True(t, _bot.replaceStuckSbchTx(_bot.pendingSbchTxs[tx0.Hash()]))
+	require.Len(t, _sbchCli.sentTxs, 4)
+	tx0r := _sbchCli.sentTxs[3]
+	rows, err := _bot.db.getPendingTxs()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+
+	// restarted
+	_bot = &MarketMakerBot{
+		db:           _bot.db,
+		dbQueryLimit: 100,
+		bchCli:       newMockBchClient(124, 125),
+		sbchCli:      _sbchCli,
+		errLogQueue:  newErrLogQueue(100),
+	}
+	require.True(t, _bot.recoverPendingTxs())
+	require.Len(t, _bot.pendingSbchTxs, 3)
+	require.Equal(t, uint64(3e7), _bot.getPendingSbchLockValue())
+	ptx0 := _bot.pendingSbchTxs[tx0r.Hash()]
+	require.NotNil(t, ptx0)
+	require.Equal(t, uint(1), ptx0.recordID)
+	require.Equal(t, uint32(30000), ptx0.timeLock)
+	require.Len(t, ptx0.replacedTxHashes, 1)
+	require.Equal(t, tx0.Hash(), ptx0.replacedTxHashes[0])
+	require.True(t, ptx0.resentAt.IsZero())
+
+	// the replaced tx0 and tx1 are mined, tx2 may not be sent
+	_sbchCli.mineTx(tx0, gethtypes.ReceiptStatusSuccessful)
+	_sbchCli.mineTx(tx1, gethtypes.ReceiptStatusSuccessful)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 1)
+	require.Len(t, _sbchCli.resentTxs, 1)
+	require.Equal(t, tx2.Hash(), _sbchCli.resentTxs[0].Hash())
+	records, err := _bot.db.getBch2SbchRecordsByStatus(Bch2SbchStatusSbchLocked, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, toHex(tx0.Hash().Bytes()), records[0].SbchLockTxHash)
+	rows, err = _bot.db.getPendingTxs()
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+
+	// tx2 is dropped, the record is left New
+	_sbchCli.confirmedNonce = 3
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 0)
+	rows, err = _bot.db.getPendingTxs()
+	require.NoError(t, err)
+	require.Len(t, rows, 0)
+	record, err := _bot.db.getBch2SbchRecordByHashLock(toHex(gethHash32Bytes("hash2")))
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusNew, record.Status)
+}
+
+func TestRecoverPendingTxs_bch(t *testing.T) {
+	_db := initDB(t, 123, 456)
+	for i := uint(1); i <= 3; i++ {
+		require.NoError(t, _db.addSbch2BchRecord(createFakeSbch2BchRecord(i)))
+	}
+	require.NoError(t, _db.addUtxoReservations([]*UtxoReservation{
+		{TxID: "utxo1", Vout: 0, Value: 100, SpendingTxHash: "locktx1"},
+		{TxID: "utxo2", Vout: 0, Value: 100, SpendingTxHash: "locktx2"},
+	}))
+	require.NoError(t, _db.addPendingTx(&PendingTx{
+		Chain: chainBch, TxType: txTypeLock, TxHash: "locktx1", RawTx: "01", RecordIDs: "1",
+	}))
+	require.NoError(t, _db.addPendingTx(&PendingTx{
+		Chain: chainBch, TxType: txTypeLock, TxHash: "locktx2", RawTx: "02", RecordIDs: "3",
+	}))
+	require.NoError(t, _db.addPendingTx(&PendingTx{
+		Chain: chainBch, TxType: txTypeLock, TxHash: "locktx3", RawTx: "03", RecordIDs: "2",
+	}))
+
+	// locktx1 and locktx3 are sent, locktx2 is not
+	_bchCli := newMockBchClient(122, 123)
+	_bchCli.confirmations["locktx2"] = -1
+	_bot := &MarketMakerBot{
+		db:          _db,
+		bchCli:      _bchCli,
+		sbchCli:     newMockSbchClient(455, 456, 0),
+		errLogQueue: newErrLogQueue(100),
+	}
+	require.True(t, _bot.recoverPendingTxs())
+	rows, err := _db.getPendingTxs()
+	require.NoError(t, err)
+	require.Len(t, rows, 0)
+
+	for id, lockTxHash := range map[uint]string{1: "locktx1", 2: "locktx3"} {
+		record, err := _db.getSbch2BchRecordByID(id)
+		require.NoError(t, err)
+		require.Equal(t, Sbch2BchStatusBchLocked, record.Status)
+		require.Equal(t, lockTxHash, record.BchLockTxHash)
+		require.Equal(t, uint32(0), record.BchLockVout)
+	}
+	record, err := _db.getSbch2BchRecordByID(3)
+	require.NoError(t, err)
+	require.Equal(t, Sbch2BchStatusNew, record.Status)
+
+	// UTXOs of the unsent tx are released
+	reservations, err := _db.getUtxoReservations()
+	require.NoError(t, err)
+	require.Len(t, reservations, 1)
+	require.Equal(t, "locktx1", reservations[0].SpendingTxHash)
+}
@@ -0,0 +1,223 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const maxPriceRespSize = 1 << 20
+
+// IPriceSource provides the market rate between BCH and sBCH
+type IPriceSource interface {
+	// GetRate returns the price of 1 BCH in sBCH, 8 decimals
+	GetRate() (uint64, error)
+}
+
+var (
+	_ IPriceSource = (*HttpPriceSource)(nil)
+	_ IPriceSource = (*FilePriceSource)(nil)
+)
+
+// HttpPriceSource gets the rate from a JSON API,
+// e.g. jsonPath is "data.price" if the response is {"data": {"price": "1.0002"}}
+type HttpPriceSource struct {
+	url      string
+	jsonPath []string
+	client   *http.Client
+}
+
+func NewHttpPriceSource(url, jsonPath string, timeout time.Duration) *HttpPriceSource {
+	return &HttpPriceSource{
+		url:      url,
+		jsonPath: splitJsonPath(jsonPath),
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HttpPriceSource) GetRate() (uint64, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPriceRespSize))
+	if err != nil {
+		return 0, err
+	}
+	return parseRateJSON(data, s.jsonPath)
+}
+
+// FilePriceSource reads the rate from a JSON file every time, so that it can be changed without restarting the bot
+type FilePriceSource struct {
+	file     string
+	jsonPath []string
+}
+
+func NewFilePriceSource(file, jsonPath string) *FilePriceSource {
+	return &FilePriceSource{
+		file:     file,
+		jsonPath: splitJsonPath(jsonPath),
+	}
+}
+
+func (s *FilePriceSource) GetRate() (uint64, error) {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return 0, err
+	}
+	return parseRateJSON(data, s.jsonPath)
+}
+
+func splitJsonPath(jsonPath string) []string {
+	if jsonPath == "" {
+		return nil
+	}
+	return strings.Split(jsonPath, ".")
+}
+
+// the rate can be a JSON number or string, e.g. 1.0002 or "1.0002"
+func parseRateJSON(data []byte, jsonPath []string) (uint64, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return 0, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	for _, key := range jsonPath {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return 0, fmt.Errorf("%s: not an object", key)
+		}
+		if v, ok = obj[key]; !ok {
+			return 0, fmt.Errorf("%s: not found", key)
+		}
+	}
+
+	var s string
+	switch x := v.(type) {
+	case json.Number:
+		s = x.String()
+	case string:
+		s = x
+	default:
+		return 0, fmt.Errorf("rate is not a number: %v", v)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate: %w", err)
+	}
+	if f <= 0 || f > 1e10 {
+		return 0, fmt.Errorf("invalid rate: %s", s)
+	}
+	return uint64(math.Round(f * 1e8)), nil
+}
+
+type PriceFeedConfig struct {
+	SpreadBPS         uint64        // bchPrice = rate * (1 - spread), sbchPrice = (1 / rate) * (1 - spread)
+	SkewBPS           uint64        // extra spread when inventory is totally imbalanced, 0 means no skew
+	DriftBPS          uint64        // on-chain prices are updated only if they drift more than this
+	MinUpdateInterval time.Duration // min interval between two on-chain updates
+	MinRate           uint64        // sanity band, 8 decimals, rates out of band are ignored
+	MaxRate           uint64        // sanity band, 8 decimals
+}
+
+// PriceFeed calculates prices from an external rate source, only used by master bot
+type PriceFeed struct {
+	PriceFeedConfig
+	source IPriceSource
+
+	lastUpdatedAt time.Time
+}
+
+func NewPriceFeed(source IPriceSource, cfg PriceFeedConfig) (*PriceFeed, error) {
+	if cfg.SpreadBPS+cfg.SkewBPS >= 10000 {
+		return nil, fmt.Errorf("spread is too large: %d + %d", cfg.SpreadBPS, cfg.SkewBPS)
+	}
+	if cfg.MinRate == 0 || cfg.MaxRate < cfg.MinRate {
+		return nil, fmt.Errorf("invalid sanity band: [%d, %d]", cfg.MinRate, cfg.MaxRate)
+	}
+	return &PriceFeed{
+		PriceFeedConfig: cfg,
+		source:          source,
+	}, nil
+}
+
+// in BPS
+func priceDrift(oldPrice, newPrice uint64) uint64 {
+	if oldPrice == 0 {
+		return math.MaxUint64
+	}
+	if newPrice > oldPrice {
+		return (newPrice - oldPrice) * 10000 / oldPrice
+	}
+	return (oldPrice - newPrice) * 10000 / oldPrice
+}
+
+// update on-chain prices if the prices calculated from the price feed drift too much
+func (bot *MarketMakerBot) updatePricesFromFeed(intro [32]byte) {
+	feed := bot.priceFeed
+	if time.Since(feed.lastUpdatedAt) < feed.MinUpdateInterval {
+		return
+	}
+
+	rate, err := feed.source.GetRate()
+	if err != nil {
+		bot.logError("failed to get rate from price feed: ", err)
+		return
+	}
+	if rate < feed.MinRate || rate > feed.MaxRate {
+		bot.logWarnf("rate from price feed is out of sanity band: %d ∉ [%d, %d]",
+			rate, feed.MinRate, feed.MaxRate)
+		return
+	}
+
+	var imbalance int64
+	if feed.SkewBPS > 0 {
+		inv, ok := bot.getInventory()
+		if !ok {
+			return
+		}
+		imbalance = inv.imbalance(rate)
+		log.Infof("inventory, BCH: %d, sBCH: %d, imbalance: %d BPS", inv.bch, inv.sbch, imbalance)
+	}
+
+	bchPrice, sbchPrice := feed.calcPrices(rate, imbalance)
+	bchDrift := priceDrift(bot.bchPrice, bchPrice)
+	sbchDrift := priceDrift(bot.sbchPrice, sbchPrice)
+	log.Infof("rate: %d, new BCH price: %d (drift: %d BPS), new sBCH price: %d (drift: %d BPS)",
+		rate, bchPrice, bchDrift, sbchPrice, sbchDrift)
+	if bchDrift <= feed.DriftBPS && sbchDrift <= feed.DriftBPS {
+		return
+	}
+
+	tx, err := bot.sbchCli.updateMarketMaker(intro, satsToWei(bchPrice), satsToWei(sbchPrice))
+	if err == nil {
+		err = bot.sendSbchTx(tx, txTypeUpdatePrices, 0, "", 0, bot.sbchTimeLock)
+	}
+	if err != nil {
+		bot.logError("RPC error, failed to update market maker prices: ", err)
+		bot.metrics.incTxsFailed(chainSbch, txTypeUpdatePrices)
+		return
+	}
+	bot.metrics.incTxsSent(chainSbch, txTypeUpdatePrices)
+	log.Info("market maker prices update tx sent, txHash: ", tx.Hash().String())
+
+	feed.lastUpdatedAt = time.Now()
+	bot.bchPrice = bchPrice
+	bot.sbchPrice = sbchPrice
+}
@@ -0,0 +1,195 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartbch/atomic-swap-bot/htlcsbch"
+)
+
+func TestParseRateJSON(t *testing.T) {
+	testCases := []struct {
+		json string
+		path string
+		rate uint64
+		err  string
+	}{
+		{`1.0002`, "", 1_0002_0000, ""},
+		{`{"price": 1.0002}`, "price", 1_0002_0000, ""},
+		{`{"price": "0.99"}`, "price", 9900_0000, ""},
+		{`{"data": {"price": "1.23456789"}}`, "data.price", 1_2345_6789, ""},
+		{`{"data": {"price": "1.23456789"}}`, "data.rate", 0, "rate: not found"},
+		{`{"data": [1]}`, "data.price", 0, "price: not an object"},
+		{`{"price": true}`, "price", 0, "rate is not a number: true"},
+		{`{"price": "abc"}`, "price", 0, "invalid rate"},
+		{`{"price": -1}`, "price", 0, "invalid rate: -1"},
+		{`{"price": 0}`, "price", 0, "invalid rate: 0"},
+		{`{"price": `, "price", 0, "invalid JSON"},
+	}
+	for _, tc := range testCases {
+		rate, err := parseRateJSON([]byte(tc.json), splitJsonPath(tc.path))
+		if tc.err == "" {
+			require.NoError(t, err, tc.json)
+			require.Equal(t, tc.rate, rate, tc.json)
+		} else {
+			require.ErrorContains(t, err, tc.err, tc.json)
+		}
+	}
+}
+
+func TestFilePriceSource(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "price.json")
+	src := NewFilePriceSource(file, "data.price")
+	_, err := src.GetRate()
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"data": {"price": "1.01"}}`), 0644))
+	rate, err := src.GetRate()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1_0100_0000), rate)
+
+	// the file is read every time
+	require.NoError(t, os.WriteFile(file, []byte(`{"data": {"price": 0.98}}`), 0644))
+	rate, err = src.GetRate()
+	require.NoError(t, err)
+	require.Equal(t, uint64(9800_0000), rate)
+}
+
+func TestHttpPriceSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/price" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"symbol": "BCH/sBCH", "price": "0.9995"}`))
+	}))
+	defer ts.Close()
+
+	rate, err := NewHttpPriceSource(ts.URL+"/price", "price", time.Second).GetRate()
+	require.NoError(t, err)
+	require.Equal(t, uint64(9995_0000), rate)
+
+	_, err = NewHttpPriceSource(ts.URL+"/xxx", "price", time.Second).GetRate()
+	require.ErrorContains(t, err, "unexpected status: 404")
+}
+
+func TestNewPriceFeed(t *testing.T) {
+	src := NewFilePriceSource("price.json", "price")
+	_, err := NewPriceFeed(src, PriceFeedConfig{SpreadBPS: 10000, MinRate: 1, MaxRate: 2})
+	require.ErrorContains(t, err, "spread is too large")
+	_, err = NewPriceFeed(src, PriceFeedConfig{SpreadBPS: 5000, SkewBPS: 5000, MinRate: 1, MaxRate: 2})
+	require.ErrorContains(t, err, "spread is too large")
+	_, err = NewPriceFeed(src, PriceFeedConfig{MinRate: 0, MaxRate: 2})
+	require.ErrorContains(t, err, "invalid sanity band")
+	_, err = NewPriceFeed(src, PriceFeedConfig{MinRate: 3, MaxRate: 2})
+	require.ErrorContains(t, err, "invalid sanity band")
+	_, err = NewPriceFeed(src, PriceFeedConfig{MinRate: 1, MaxRate: 2})
+	require.NoError(t, err)
+}
+
+func TestPriceFeed_calcPrices(t *testing.T) {
+	feed := &PriceFeed{PriceFeedConfig: PriceFeedConfig{SpreadBPS: 0}}
+	bchPrice, sbchPrice := feed.calcPrices(1_0000_0000, 0)
+	require.Equal(t, uint64(1_0000_0000), bchPrice)
+	require.Equal(t, uint64(1_0000_0000), sbchPrice)
+
+	feed.SpreadBPS = 20
+	bchPrice, sbchPrice = feed.calcPrices(1_0000_0000, 0)
+	require.Equal(t, uint64(9980_0000), bchPrice)
+	require.Equal(t, uint64(9980_0000), sbchPrice)
+
+	bchPrice, sbchPrice = feed.calcPrices(1_2500_0000, 0)
+	require.Equal(t, uint64(1_2475_0000), bchPrice)
+	require.Equal(t, uint64(7984_0000), sbchPrice)
+}
+
+func TestPriceDrift(t *testing.T) {
+	require.Equal(t, uint64(0), priceDrift(1_0000_0000, 1_0000_0000))
+	require.Equal(t, uint64(50), priceDrift(1_0000_0000, 1_0050_0000))
+	require.Equal(t, uint64(50), priceDrift(1_0000_0000, 9950_0000))
+	require.Equal(t, uint64(49), priceDrift(1_0000_0000, 9950_0001))
+	require.Greater(t, priceDrift(0, 1_0000_0000), uint64(10000))
+}
+
+func TestUpdatePricesFromFeed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "price.json")
+	setRate := func(rate string) {
+		require.NoError(t, os.WriteFile(file, []byte(`{"price": "`+rate+`"}`), 0644))
+	}
+	feed, err := NewPriceFeed(NewFilePriceSource(file, "price"), PriceFeedConfig{
+		SpreadBPS:         0,
+		DriftBPS:          50,
+		MinUpdateInterval: time.Hour,
+		MinRate:           9000_0000,
+		MaxRate:           1_1000_0000,
+	})
+	require.NoError(t, err)
+
+	sbchCli := newMockSbchClient(0, 0, 0)
+	sbchCli.mmInfo = &htlcsbch.MarketMakerInfo{
+		BchPrice:  satsToWei(1_0000_0000),
+		SbchPrice: satsToWei(1_0000_0000),
+	}
+	_bot := &MarketMakerBot{
+		db:          initDB(t, 0, 0),
+		sbchCli:     sbchCli,
+		priceFeed:   feed,
+		errLogQueue: newErrLogQueue(100),
+	}
+	updatePrices := func() {
+		_bot.lastPricesUpdatedAt = 0
+		_bot.updatePrices()
+	}
+
+	// no feed data
+	updatePrices()
+	require.Equal(t, uint64(1_0000_0000), _bot.bchPrice)
+	require.True(t, feed.lastUpdatedAt.IsZero())
+
+	// drift is too small
+	setRate("1.004")
+	updatePrices()
+	require.Equal(t, uint64(1_0000_0000), _bot.bchPrice)
+	require.Equal(t, satsToWei(1_0000_0000), sbchCli.mmInfo.BchPrice)
+
+	// out of sanity band
+	setRate("1.2")
+	updatePrices()
+	require.Equal(t, uint64(1_0000_0000), _bot.bchPrice)
+	require.Equal(t, satsToWei(1_0000_0000), sbchCli.mmInfo.BchPrice)
+
+	// updated
+	setRate("1.0")
+	feed.SpreadBPS = 100
+	updatePrices()
+	require.Equal(t, uint64(9900_0000), _bot.bchPrice)
+	require.Equal(t, uint64(9900_0000), _bot.sbchPrice)
+	require.Equal(t, satsToWei(9900_0000), sbchCli.mmInfo.BchPrice)
+	require.Equal(t, satsToWei(9900_0000), sbchCli.mmInfo.SbchPrice)
+	require.False(t, feed.lastUpdatedAt.IsZero())
+
+	// too soon
+	setRate("0.95")
+	updatePrices()
+	require.Equal(t, uint64(9900_0000), _bot.bchPrice)
+	require.Equal(t, satsToWei(9900_0000), sbchCli.mmInfo.BchPrice)
+
+	// slave bot never updates prices
+	feed.lastUpdatedAt = time.Time{}
+	_bot.isSlaveMode = true
+	updatePrices()
+	require.Equal(t, satsToWei(9900_0000), sbchCli.mmInfo.BchPrice)
+
+	_bot.isSlaveMode = false
+	updatePrices()
+	require.Equal(t, uint64(9405_0000), _bot.bchPrice)
+	require.Equal(t, uint64(1_0421_0525), _bot.sbchPrice)
+	require.Equal(t, satsToWei(9405_0000), sbchCli.mmInfo.BchPrice)
+	require.Equal(t, satsToWei(1_0421_0525), sbchCli.mmInfo.SbchPrice)
+}
@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"math"
+)
+
+// inventory of the bot, in sats
+type inventory struct {
+	bch  uint64 // free BCH + BCH to be unlocked - BCH to be locked
+	sbch uint64 // free sBCH + sBCH to be unlocked - sBCH to be locked
+}
+
+func (bot *MarketMakerBot) getInventory() (inv inventory, ok bool) {
+	freeBch, err := bot.getFreeBch()
+	if err != nil {
+		bot.logError("RPC error, failed to get UTXOs: ", err)
+		return
+	}
+	freeSbch, err := bot.getFreeSbch()
+	if err != nil {
+		bot.logError("RPC error, failed to get sBCH balance: ", err)
+		return
+	}
+
+	toBeUnlockedBch, err := bot.db.getToBeUnlockedBchValue()
+	if err != nil {
+		bot.logError("DB error, failed to get BCH to be unlocked: ", err)
+		return
+	}
+	toBeUnlockedSbch, err := bot.db.getToBeUnlockedSbchValue()
+	if err != nil {
+		bot.logError("DB error, failed to get sBCH to be unlocked: ", err)
+		return
+	}
+	toBeLockedBch, err := bot.getToBeLockedBch()
+	if err != nil {
+		bot.logError("DB error, failed to get BCH to be locked: ", err)
+		return
+	}
+	toBeLockedSbch, err := bot.getToBeLockedSbch()
+	if err != nil {
+		bot.logError("DB error, failed to get sBCH to be locked: ", err)
+		return
+	}
+
+	inv.bch = subOrZero(freeBch+toBeUnlockedBch, toBeLockedBch)
+	inv.sbch = subOrZero(freeSbch+toBeUnlockedSbch, toBeLockedSbch)
+	return inv, true
+}
+
+// BCH owed to sbch2bch records which are not locked yet
+func (bot *MarketMakerBot) getToBeLockedBch() (uint64, error) {
+	records, err := bot.db.getSbch2BchRecordsByStatus(Sbch2BchStatusNew, bot.dbQueryLimit)
+	if err != nil {
+		return 0, err
+	}
+	total := uint64(0)
+	for _, record := range records {
+		total += mulByPrice(record.Value, record.SbchPrice)
+	}
+	return total, nil
+}
+
+// sBCH owed to bch2sbch records which are not locked yet, the sent lock txs are not mined yet
+// and their values are still in the balance of the bot
+func (bot *MarketMakerBot) getToBeLockedSbch() (uint64, error) {
+	records, err := bot.db.getBch2SbchRecordsByStatus(Bch2SbchStatusNew, bot.dbQueryLimit)
+	if err != nil {
+		return 0, err
+	}
+	total := bot.getPendingSbchLockValue()
+	for _, record := range records {
+		if !bot.hasPendingSbchTx(txTypeLock, record.HashLock) {
+			total += mulByPrice(record.Value, record.BchPrice)
+		}
+	}
+	return total, nil
+}
+
+func subOrZero(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return 0
+}
+
+// imbalance in BPS, within [-10000, 10000], BCH is valued at rate (8 decimals),
+// positive if the bot has more BCH than sBCH, negative if the bot has more sBCH than BCH
+func (inv inventory) imbalance(rate uint64) int64 {
+	bchVal := float64(inv.bch) * float64(rate) / 1e8
+	sbchVal := float64(inv.sbch)
+	if bchVal+sbchVal == 0 {
+		return 0
+	}
+	return int64(math.Round((bchVal - sbchVal) / (bchVal + sbchVal) * 10000))
+}
+
+// The spread of the direction which consumes the scarce asset widens linearly with the imbalance,
+// and reaches SpreadBPS+SkewBPS if the bot has run out of that asset.
+// BCH2SBCH swaps consume sBCH, SBCH2BCH swaps consume BCH.
+func (feed *PriceFeed) calcSpreads(imbalance int64) (bchSpread, sbchSpread uint64) {
+	bchSpread, sbchSpread = feed.SpreadBPS, feed.SpreadBPS
+	if imbalance > 0 {
+		bchSpread += feed.SkewBPS * uint64(imbalance) / 10000
+	} else {
+		sbchSpread += feed.SkewBPS * uint64(-imbalance) / 10000
+	}
+	return
+}
+
+// prices are 8 decimals
+func (feed *PriceFeed) calcPrices(rate uint64, imbalance int64) (bchPrice, sbchPrice uint64) {
+	bchSpread, sbchSpread := feed.calcSpreads(imbalance)
+	bchPrice = rate * (10000 - bchSpread) / 10000
+	sbchPrice = 1e16 / rate * (10000 - sbchSpread) / 10000
+	return
+}
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gethcmn "github.com/ethereum/go-ethereum/common"
+	"github.com/gcash/bchd/btcjson"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartbch/atomic-swap-bot/htlcsbch"
+)
+
+func TestInventoryImbalance(t *testing.T) {
+	require.Equal(t, int64(0), inventory{}.imbalance(1e8))
+	require.Equal(t, int64(0), inventory{bch: 1e8, sbch: 1e8}.imbalance(1e8))
+	require.Equal(t, int64(10000), inventory{bch: 1e8}.imbalance(1e8))
+	require.Equal(t, int64(-10000), inventory{sbch: 1e8}.imbalance(1e8))
+	require.Equal(t, int64(5000), inventory{bch: 3e8, sbch: 1e8}.imbalance(1e8))
+	require.Equal(t, int64(-5000), inventory{bch: 1e8, sbch: 3e8}.imbalance(1e8))
+	require.Equal(t, int64(0), inventory{bch: 1e8, sbch: 2e8}.imbalance(2e8))
+}
+
+func TestPriceFeed_calcSpreads(t *testing.T) {
+	feed := &PriceFeed{PriceFeedConfig: PriceFeedConfig{SpreadBPS: 20, SkewBPS: 200}}
+	testCases := []struct {
+		imbalance  int64
+		bchSpread  uint64
+		sbchSpread uint64
+	}{
+		{0, 20, 20},
+		{5000, 120, 20},
+		{10000, 220, 20},
+		{-2500, 20, 70},
+		{-10000, 20, 220},
+	}
+	for _, tc := range testCases {
+		bchSpread, sbchSpread := feed.calcSpreads(tc.imbalance)
+		require.Equal(t, tc.bchSpread, bchSpread, tc.imbalance)
+		require.Equal(t, tc.sbchSpread, sbchSpread, tc.imbalance)
+	}
+
+	bchPrice, sbchPrice := feed.calcPrices(1_0000_0000, 5000)
+	require.Equal(t, uint64(9880_0000), bchPrice)
+	require.Equal(t, uint64(9980_0000), sbchPrice)
+}
+
+func TestGetInventory(t *testing.T) {
+	_db := initDB(t, 123, 456)
+	b2s1 := createFakeBch2SbchRecord(1)
+	b2s1.Value = 2_0000_0000
+	b2s1.BchPrice = 1_0000_0000
+	require.NoError(t, _db.addBch2SbchRecord(b2s1))
+	b2s2 := createFakeBch2SbchRecord(2)
+	b2s2.Value = 1_0000_0000
+	b2s2.BchPrice = 1_0000_0000
+	require.NoError(t, _db.addBch2SbchRecord(b2s2))
+	s2b := createFakeSbch2BchRecord(1)
+	s2b.Value = 3_0000_0000
+	s2b.SbchPrice = 5000_0000
+	require.NoError(t, _db.addSbch2BchRecord(s2b))
+
+	bchCli := newMockBchClient(122, 123)
+	bchCli.utxos = []btcjson.ListUnspentResult{{Amount: 1.5}, {Amount: 0.25}}
+	sbchCli := newMockSbchClient(455, 456, 0)
+	sbchCli.balance = satsToWei(4_0000_0000)
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       bchCli,
+		sbchCli:      sbchCli,
+		sbchCliRO:    sbchCli,
+	}
+
+	// BCH: 1.75 free + 3 to be unlocked - 1.5 owed to the sbch2bch record
+	// sBCH: 4 free + 3 to be unlocked - 3 owed to the bch2sbch records
+	inv, ok := _bot.getInventory()
+	require.True(t, ok)
+	require.Equal(t, inventory{bch: 3_2500_0000, sbch: 4_0000_0000}, inv)
+
+	// the lock tx of b2s2 is sent but not mined, it is not counted twice
+	_bot.pendingSbchTxs = map[gethcmn.Hash]*pendingSbchTx{
+		{0x01}: {txType: txTypeLock, hashLock: b2s2.HashLock, value: 1_0000_0000},
+	}
+	inv, ok = _bot.getInventory()
+	require.True(t, ok)
+	require.Equal(t, inventory{bch: 3_2500_0000, sbch: 4_0000_0000}, inv)
+
+	// no free sBCH left
+	sbchCli.balance = satsToWei(0)
+	inv, ok = _bot.getInventory()
+	require.True(t, ok)
+	require.Equal(t, inventory{bch: 3_2500_0000, sbch: 0}, inv)
+}
+
+func TestUpdatePricesFromFeed_skew(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "price.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"price": 1.0}`), 0644))
+	feed, err := NewPriceFeed(NewFilePriceSource(file, "price"), PriceFeedConfig{
+		SpreadBPS: 20,
+		SkewBPS:   200,
+		DriftBPS:  10,
+		MinRate:   9000_0000,
+		MaxRate:   1_1000_0000,
+	})
+	require.NoError(t, err)
+
+	bchCli := newMockBchClient(122, 123)
+	bchCli.utxos = []btcjson.ListUnspentResult{{Amount: 3}}
+	sbchCli := newMockSbchClient(455, 456, 0)
+	sbchCli.balance = satsToWei(1_0000_0000)
+	sbchCli.mmInfo = &htlcsbch.MarketMakerInfo{
+		BchPrice:  satsToWei(1_0000_0000),
+		SbchPrice: satsToWei(1_0000_0000),
+	}
+	_bot := &MarketMakerBot{
+		db:          initDB(t, 123, 456),
+		bchCli:      bchCli,
+		sbchCli:     sbchCli,
+		sbchCliRO:   sbchCli,
+		priceFeed:   feed,
+		errLogQueue: newErrLogQueue(100),
+	}
+
+	// short on sBCH, BCH2SBCH swaps get worse price
+	_bot.updatePrices()
+	require.Equal(t, satsToWei(9880_0000), sbchCli.mmInfo.BchPrice)
+	require.Equal(t, satsToWei(9980_0000), sbchCli.mmInfo.SbchPrice)
+
+	// short on BCH, SBCH2BCH swaps get worse price
+	bchCli.utxos = nil
+	feed.lastUpdatedAt = time.Time{}
+	_bot.lastPricesUpdatedAt = 0
+	_bot.updatePrices()
+	require.Equal(t, satsToWei(9980_0000), sbchCli.mmInfo.BchPrice)
+	require.Equal(t, satsToWei(9780_0000), sbchCli.mmInfo.SbchPrice)
+	require.Equal(t, uint64(9980_0000), _bot.bchPrice)
+	require.Equal(t, uint64(9780_0000), _bot.sbchPrice)
+}
@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sbchGasPriceRefreshInterval = time.Minute
+	minSbchGasPriceBumpBPS      = 1000 // nodes do not accept replacement txs whose gas prices are bumped less than 10%
+)
+
+type SbchGasOracleConfig struct {
+	Floor       *big.Int // in wei
+	Ceiling     *big.Int // in wei
+	BumpBPS     uint64   // gas price increment of each replacement tx
+	DeadlineBPS uint64   // replace a pending tx if this part of the swap's sBCH time lock has elapsed since it is sent
+}
+
+// SbchGasOracle adapts sBCH gas price to the network, the price suggested by the node is clamped by the floor and ceiling,
+// pending txs which are not mined before the deadline are replaced by the same nonce with bumped gas prices
+type SbchGasOracle struct {
+	SbchGasOracleConfig
+
+	refreshedAt time.Time
+}
+
+func NewSbchGasOracle(cfg SbchGasOracleConfig) (*SbchGasOracle, error) {
+	if cfg.Floor == nil || cfg.Floor.Sign() <= 0 ||
+		cfg.Ceiling == nil || cfg.Ceiling.Cmp(cfg.Floor) < 0 {
+		return nil, fmt.Errorf("invalid gas price limits: [%s, %s]", cfg.Floor, cfg.Ceiling)
+	}
+	if cfg.BumpBPS < minSbchGasPriceBumpBPS {
+		return nil, fmt.Errorf("gas price bump is too small: %d", cfg.BumpBPS)
+	}
+	if cfg.DeadlineBPS == 0 || cfg.DeadlineBPS >= 10000 {
+		return nil, fmt.Errorf("invalid deadline: %d", cfg.DeadlineBPS)
+	}
+	return &SbchGasOracle{SbchGasOracleConfig: cfg}, nil
+}
+
+func (o *SbchGasOracle) clamp(gasPrice *big.Int) *big.Int {
+	if gasPrice.Cmp(o.Floor) < 0 {
+		return new(big.Int).Set(o.Floor)
+	}
+	if gasPrice.Cmp(o.Ceiling) > 0 {
+		return new(big.Int).Set(o.Ceiling)
+	}
+	return gasPrice
+}
+
+// bump returns the gas price of the replacement tx, which is not greater than the ceiling
+func (o *SbchGasOracle) bump(gasPrice *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(gasPrice, big.NewInt(int64(10000+o.BumpBPS)))
+	bumped.Div(bumped, big.NewInt(10000))
+	if bumped.Cmp(o.Ceiling) > 0 {
+		return new(big.Int).Set(o.Ceiling)
+	}
+	return bumped
+}
+
+// isStuck returns true if the pending tx is not mined before the deadline
+func (o *SbchGasOracle) isStuck(ptx *pendingSbchTx, now time.Time) bool {
+	if ptx.timeLock == 0 {
+		return false
+	}
+	deadline := time.Duration(uint64(ptx.timeLock)*o.DeadlineBPS/10000) * time.Second
+	return now.Sub(ptx.sentAt) >= deadline
+}
+
+// refresh gas price of new sBCH txs
+func (bot *MarketMakerBot) refreshSbchGasPrice() {
+	o := bot.sbchGasOracle
+	now := time.Now()
+	if now.Sub(o.refreshedAt) < sbchGasPriceRefreshInterval {
+		return
+	}
+	o.refreshedAt = now
+
+	gasPrice, err := bot.sbchCli.suggestGasPrice()
+	if err != nil {
+		bot.logError("RPC error, failed to get sBCH gas price: ", err)
+		return
+	}
+	clamped := o.clamp(gasPrice)
+	log.Info("sBCH gas price, suggested: ", gasPrice.String(), ", used: ", clamped.String())
+	bot.sbchCli.setGasPrice(clamped)
+}
+
+// replace the stuck tx by the same nonce and a higher gas price,
+// returns false if the gas price can not be bumped
+func (bot *MarketMakerBot) replaceStuckSbchTx(ptx *pendingSbchTx) bool {
+	oldTx := ptx.tx
+	gasPrice := bot.sbchGasOracle.bump(oldTx.GasPrice())
+	if gasPrice.Cmp(oldTx.GasPrice()) <= 0 {
+		return false
+	}
+
+	log.Info("replace stuck sBCH tx, hash: ", oldTx.Hash().String(), ", nonce: ", oldTx.Nonce(),
+		", type: ", ptx.txType, ", hashLock: ", ptx.hashLock, ", gas price: ", gasPrice.String())
+	newTx, err := bot.sbchCli.signReplacementTx(oldTx, gasPrice)
+	if err == nil {
+		err = bot.saveAndSendSbchTx(ptx, newTx)
+	}
+	if err != nil {
+		bot.logError("RPC error, failed to replace sBCH tx: ", err)
+		bot.metrics.incTxsFailed(chainSbch, txTypeReplace)
+		return true
+	}
+	log.Info("sBCH replacement tx sent, hash: ", newTx.Hash().String())
+	bot.metrics.incTxsSent(chainSbch, txTypeReplace)
+
+	// the replaced tx may still be mined
+	now := time.Now()
+	delete(bot.pendingSbchTxs, oldTx.Hash())
+	ptx.replacedTxHashes = append(ptx.replacedTxHashes, oldTx.Hash())
+	ptx.tx = newTx
+	ptx.sentAt = now
+	ptx.resentAt = now
+	bot.pendingSbchTxs[newTx.Hash()] = ptx
+	return true
+}
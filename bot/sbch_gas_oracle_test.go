@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSbchGasOracle(t *testing.T) *SbchGasOracle {
+	o, err := NewSbchGasOracle(SbchGasOracleConfig{
+		Floor:       big.NewInt(1000),
+		Ceiling:     big.NewInt(2000),
+		BumpBPS:     2500,
+		DeadlineBPS: 100,
+	})
+	require.NoError(t, err)
+	return o
+}
+
+func TestNewSbchGasOracle(t *testing.T) {
+	cfg := SbchGasOracleConfig{Floor: big.NewInt(0), Ceiling: big.NewInt(10), BumpBPS: 1000, DeadlineBPS: 100}
+	_, err := NewSbchGasOracle(cfg)
+	require.ErrorContains(t, err, "invalid gas price limits: [0, 10]")
+	cfg.Floor = big.NewInt(11)
+	_, err = NewSbchGasOracle(cfg)
+	require.ErrorContains(t, err, "invalid gas price limits: [11, 10]")
+	cfg.Floor = big.NewInt(10)
+	cfg.BumpBPS = 999
+	_, err = NewSbchGasOracle(cfg)
+	require.ErrorContains(t, err, "gas price bump is too small: 999")
+	cfg.BumpBPS = 1000
+	cfg.DeadlineBPS = 10000
+	_, err = NewSbchGasOracle(cfg)
+	require.ErrorContains(t, err, "invalid deadline: 10000")
+	cfg.DeadlineBPS = 100
+	_, err = NewSbchGasOracle(cfg)
+	require.NoError(t, err)
+}
+
+func TestSbchGasOracle_clampAndBump(t *testing.T) {
+	o := newTestSbchGasOracle(t)
+	require.Equal(t, big.NewInt(1000), o.clamp(big.NewInt(999)))
+	require.Equal(t, big.NewInt(1500), o.clamp(big.NewInt(1500)))
+	require.Equal(t, big.NewInt(2000), o.clamp(big.NewInt(2001)))
+	require.Equal(t, big.NewInt(1250), o.bump(big.NewInt(1000)))
+	require.Equal(t, big.NewInt(2000), o.bump(big.NewInt(1700)))
+	require.Equal(t, big.NewInt(2000), o.bump(big.NewInt(2000)))
+}
+
+func TestSbchGasOracle_isStuck(t *testing.T) {
+	o := newTestSbchGasOracle(t)
+	now := time.Now()
+	ptx := &pendingSbchTx{timeLock: 36000, sentAt: now}
+	require.False(t, o.isStuck(ptx, now.Add(359*time.Second)))
+	require.True(t, o.isStuck(ptx, now.Add(360*time.Second)))
+	ptx.timeLock = 0
+	require.False(t, o.isStuck(ptx, now.Add(time.Hour)))
+}
+
+func TestRefreshSbchGasPrice(t *testing.T) {
+	_sbchCli := newMockSbchClient(457, 999, 0)
+	_sbchCli.gasPrice = big.NewInt(1050)
+	_bot := &MarketMakerBot{
+		sbchCli:       _sbchCli,
+		sbchGasOracle: newTestSbchGasOracle(t),
+		errLogQueue:   newErrLogQueue(100),
+	}
+
+	// failed to get gas price, the old one is used
+	_bot.refreshSbchGasPrice()
+	require.Equal(t, big.NewInt(1050), _sbchCli.gasPrice)
+
+	// cached
+	_sbchCli.suggestedPrice = big.NewInt(5000)
+	_bot.refreshSbchGasPrice()
+	require.Equal(t, big.NewInt(1050), _sbchCli.gasPrice)
+
+	// clamped
+	_bot.sbchGasOracle.refreshedAt = time.Time{}
+	_bot.refreshSbchGasPrice()
+	require.Equal(t, big.NewInt(2000), _sbchCli.gasPrice)
+}
+
+func TestCheckPendingSbchTxs_replace(t *testing.T) {
+	_bot, _sbchCli := newTestBotWithNewBch2SbchRecords(t, 1)
+	_bot.sbchGasOracle = newTestSbchGasOracle(t)
+	_bot.sbchGasOracle.DeadlineBPS = 2500
+	_sbchCli.gasPrice = big.NewInt(1500)
+
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 1)
+	tx0 := _sbchCli.sentTxs[0]
+	ptx := _bot.pendingSbchTxs[tx0.Hash()]
+	require.Equal(t, uint32(30000), ptx.timeLock)
+
+	// not stuck, and not given up after the drop timeout
+	ptx.sentAt = time.Now().Add(-sbchTxDropTimeout)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _sbchCli.sentTxs, 1)
+	require.Len(t, _bot.pendingSbchTxs, 1)
+
+	// stuck, replaced with the same nonce
+	ptx.sentAt = time.Now().Add(-7500 * time.Second)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _sbchCli.sentTxs, 2)
+	tx1 := _sbchCli.sentTxs[1]
+	require.Equal(t, tx0.Nonce(), tx1.Nonce())
+	require.Equal(t, big.NewInt(1875), tx1.GasPrice())
+	require.Len(t, _bot.pendingSbchTxs, 1)
+	require.Same(t, ptx, _bot.pendingSbchTxs[tx1.Hash()])
+
+	// stuck again, bumped to the ceiling
+	ptx.sentAt = time.Now().Add(-7500 * time.Second)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _sbchCli.sentTxs, 3)
+	require.Equal(t, big.NewInt(2000), _sbchCli.sentTxs[2].GasPrice())
+
+	// the gas price can not be bumped any more
+	ptx.sentAt = time.Now().Add(-7500 * time.Second)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _sbchCli.sentTxs, 3)
+	require.Len(t, _bot.pendingSbchTxs, 1)
+
+	// the replaced tx is mined
+	_sbchCli.mineTx(tx1, gethtypes.ReceiptStatusSuccessful)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 0)
+	record, err := _bot.db.getBch2SbchRecordByHashLock(toHex(gethHash32Bytes("hash0")))
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusSbchLocked, record.Status)
+	require.Equal(t, toHex(tx1.Hash().Bytes()), record.SbchLockTxHash)
+}
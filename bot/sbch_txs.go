@@ -0,0 +1,285 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	gethcmn "github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	sbchTxResendInterval = time.Minute      // re-broadcast a pending sBCH tx if it is not mined after this
+	sbchTxDropTimeout    = 10 * time.Minute // give up a pending sBCH tx if it is not mined after this and not in mempool, and it is not replaced by sbchGasOracle
+)
+
+// an sBCH tx which is sent by bot but not mined yet
+// liquidity reserved by pending lock txs, in the order of nonces
+func (bot *MarketMakerBot) getPendingSbchLocks() []*Reservation {
+	var ptxs []*pendingSbchTx
+	for _, ptx := range bot.pendingSbchTxs {
+		if ptx.txType == txTypeLock {
+			ptxs = append(ptxs, ptx)
+		}
+	}
+	slices.SortFunc(ptxs, func(a, b *pendingSbchTx) bool {
+		return a.tx.Nonce() < b.tx.Nonce()
+	})
+	return cast(ptxs, func(ptx *pendingSbchTx) *Reservation {
+		return &Reservation{HashLock: ptx.hashLock, Value: ptx.value}
+	})
+}
+
+type pendingSbchTx struct {
+	tx       *gethtypes.Transaction
+	txType   string // txTypeLock|txTypeUnlock|txTypeRefund|txTypeUpdatePrices
+	recordID uint   // 0 for txTypeUpdatePrices
+	hashLock string // empty for txTypeUpdatePrices
+	value    uint64 // in sats, sBCH locked by txTypeLock
+	timeLock uint32 // in seconds, sBCH time lock of the swap, used to derive the deadline of replacement
+	sentAt   time.Time
+	resentAt time.Time
+
+	replacedTxHashes []gethcmn.Hash // txs with the same nonce and lower gas prices, which may still be mined
+}
+
+// sendSbchTx saves and sends the signed tx, the tx is checked by checkPendingSbchTxs once it is sent
+func (bot *MarketMakerBot) sendSbchTx(tx *gethtypes.Transaction,
+	txType string, recordID uint, hashLock string, value uint64, timeLock uint32) error {
+
+	now := time.Now()
+	ptx := &pendingSbchTx{
+		tx:       tx,
+		txType:   txType,
+		recordID: recordID,
+		hashLock: hashLock,
+		value:    value,
+		timeLock: timeLock,
+		sentAt:   now,
+		resentAt: now,
+	}
+	err := bot.saveAndSendSbchTx(ptx, tx)
+	if err != nil {
+		return err
+	}
+	bot.addPendingSbchTx(ptx)
+	return nil
+}
+
+// the tx is saved before it is sent, so that it can be recovered by recoverPendingTxs if the bot crashes,
+// tx is ptx.tx or its replacement
+func (bot *MarketMakerBot) saveAndSendSbchTx(ptx *pendingSbchTx, tx *gethtypes.Transaction) error {
+	row, err := newSbchPendingTx(ptx, tx)
+	if err != nil {
+		return err
+	}
+	err = bot.db.addPendingTx(row)
+	if err != nil {
+		return fmt.Errorf("failed to save pending tx: %w", err)
+	}
+
+	err = bot.sbchCli.sendTx(tx)
+	if err != nil {
+		if err := bot.db.deletePendingTxs([]string{row.TxHash}); err != nil {
+			bot.logError("DB error, failed to delete pending tx: ", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (bot *MarketMakerBot) addPendingSbchTx(ptx *pendingSbchTx) {
+	if bot.pendingSbchTxs == nil {
+		bot.pendingSbchTxs = map[gethcmn.Hash]*pendingSbchTx{}
+	}
+	bot.pendingSbchTxs[ptx.tx.Hash()] = ptx
+}
+
+// removes the pending tx and its saved versions, after it is mined or dropped
+func (bot *MarketMakerBot) removePendingSbchTx(ptx *pendingSbchTx) {
+	delete(bot.pendingSbchTxs, ptx.tx.Hash())
+	txHashes := make([]string, 0, len(ptx.replacedTxHashes)+1)
+	for _, txHash := range append([]gethcmn.Hash{ptx.tx.Hash()}, ptx.replacedTxHashes...) {
+		txHashes = append(txHashes, toHex(txHash[:]))
+	}
+	err := bot.db.deletePendingTxs(txHashes)
+	if err != nil {
+		bot.logError("DB error, failed to delete pending txs: ", err)
+	}
+}
+
+// records which have pending txs are skipped, so they are not handled twice
+func (bot *MarketMakerBot) hasPendingSbchTx(txType, hashLock string) bool {
+	for _, ptx := range bot.pendingSbchTxs {
+		if ptx.txType == txType && ptx.hashLock == hashLock {
+			return true
+		}
+	}
+	return false
+}
+
+// sBCH locked by pending txs is not deducted from the balance of bot yet
+func (bot *MarketMakerBot) getPendingSbchLockValue() (total uint64) {
+	for _, ptx := range bot.pendingSbchTxs {
+		if ptx.txType == txTypeLock {
+			total += ptx.value
+		}
+	}
+	return
+}
+
+// check receipts of pending sBCH txs, and update statuses of records whose txs are mined:
+// bch2sbch records: New => SbchLocked (lock tx) or SbchLocked => SbchRefunded (refund tx);
+// sbch2bch records: SecretRevealed => SbchUnlocked (unlock tx).
+// records of failed or dropped txs are left unchanged, and will be handled again
+func (bot *MarketMakerBot) checkPendingSbchTxs() {
+	if len(bot.pendingSbchTxs) == 0 {
+		return
+	}
+
+	log.Info("check pending sBCH txs: ", len(bot.pendingSbchTxs))
+	// get the nonce before receipts, so a tx which is mined meanwhile is not regarded as dropped
+	confirmedNonce, err := bot.sbchCli.getConfirmedNonce()
+	if err != nil {
+		bot.logError("RPC error, failed to get sBCH nonce: ", err)
+		return
+	}
+
+	// in the order of nonces, which is also the order of mining
+	ptxs := make([]*pendingSbchTx, 0, len(bot.pendingSbchTxs))
+	for _, ptx := range bot.pendingSbchTxs {
+		ptxs = append(ptxs, ptx)
+	}
+	slices.SortFunc(ptxs, func(a, b *pendingSbchTx) bool {
+		return a.tx.Nonce() < b.tx.Nonce()
+	})
+
+	var pendingNonce *uint64 // got once if any tx is timed out
+	for _, ptx := range ptxs {
+		txHash := ptx.tx.Hash()
+		receipt, err := bot.getPendingSbchTxReceipt(ptx)
+		if err == nil {
+			// the record is updated before the saved tx is removed, so it is not lost if the bot crashes in between
+			bot.handleSbchTxReceipt(ptx, receipt)
+			bot.removePendingSbchTx(ptx)
+			continue
+		}
+		if err != ethereum.NotFound {
+			bot.logError("RPC error, failed to get sBCH tx receipt: ", err)
+			continue
+		}
+
+		// not mined yet, stuck txs are replaced if sbchGasOracle is set, otherwise given up after timeout
+		if ptx.tx.Nonce() >= confirmedNonce && bot.sbchGasOracle != nil &&
+			bot.sbchGasOracle.isStuck(ptx, time.Now()) && bot.replaceStuckSbchTx(ptx) {
+			continue
+		}
+		timedOut := bot.sbchGasOracle == nil && time.Since(ptx.sentAt) >= sbchTxDropTimeout
+		if timedOut && ptx.tx.Nonce() >= confirmedNonce {
+			// the tx is given up only if it is gone from mempool,
+			// otherwise the record may be handled twice by a tx with a new nonce
+			if pendingNonce == nil {
+				nonce, err := bot.sbchCli.getPendingNonce()
+				if err != nil {
+					bot.logError("RPC error, failed to get sBCH pending nonce: ", err)
+					continue
+				}
+				pendingNonce = &nonce
+			}
+			if ptx.tx.Nonce() < *pendingNonce {
+				log.Info("sBCH tx is timed out but still pending, hash: ", txHash.String(), ", nonce: ", ptx.tx.Nonce())
+				timedOut = false
+			}
+		}
+		if ptx.tx.Nonce() < confirmedNonce || timedOut {
+			log.Info("sBCH tx is dropped, hash: ", txHash.String(), ", nonce: ", ptx.tx.Nonce(),
+				", type: ", ptx.txType, ", hashLock: ", ptx.hashLock)
+			bot.removePendingSbchTx(ptx)
+			bot.metrics.incTxsFailed(chainSbch, ptx.txType)
+			bot.sbchCli.resetNonce()
+			continue
+		}
+		if time.Since(ptx.resentAt) >= sbchTxResendInterval {
+			log.Info("resend sBCH tx, hash: ", txHash.String(), ", nonce: ", ptx.tx.Nonce())
+			ptx.resentAt = time.Now()
+			if err := bot.sbchCli.resendTx(ptx.tx); err != nil {
+				bot.logError("RPC error, failed to resend sBCH tx: ", err)
+			}
+		}
+	}
+}
+
+// returns the receipt of the pending tx or any tx replaced by it
+func (bot *MarketMakerBot) getPendingSbchTxReceipt(ptx *pendingSbchTx) (*gethtypes.Receipt, error) {
+	for _, txHash := range append([]gethcmn.Hash{ptx.tx.Hash()}, ptx.replacedTxHashes...) {
+		receipt, err := bot.sbchCli.getTxReceipt(txHash)
+		if err != ethereum.NotFound {
+			return receipt, err
+		}
+	}
+	return nil, ethereum.NotFound
+}
+
+func (bot *MarketMakerBot) handleSbchTxReceipt(ptx *pendingSbchTx, receipt *gethtypes.Receipt) {
+	txHash := receipt.TxHash // may be a replaced tx
+	if receipt.Status != gethtypes.ReceiptStatusSuccessful {
+		bot.logWarnf("sBCH tx failed, hash: %s, type: %s, hashLock: %s",
+			txHash.String(), ptx.txType, ptx.hashLock)
+		bot.metrics.incTxsFailed(chainSbch, ptx.txType)
+		return
+	}
+	log.Info("sBCH tx mined, hash: ", txHash.String(), ", type: ", ptx.txType, ", hashLock: ", ptx.hashLock)
+
+	switch ptx.txType {
+	case txTypeLock:
+		record, err := bot.db.getBch2SbchRecordByHashLock(ptx.hashLock)
+		if err != nil {
+			bot.logError("DB error, failed to get BCH2SBCH record: ", err)
+			return
+		}
+		if record.Status != Bch2SbchStatusNew {
+			return // handled by handleSbchLockEventB2S
+		}
+		txTime, err := bot.sbchCli.getTxTime(txHash)
+		if err != nil {
+			bot.logError("RPC error, failed to get sBCH tx time:", err)
+			txTime = uint64(time.Now().Unix())
+		}
+		record.UpdateStatusToSbchLocked(toHex(txHash[:]), txTime)
+		err = bot.db.updateBch2SbchRecord(record)
+		if err != nil {
+			bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
+		}
+	case txTypeUnlock:
+		record, err := bot.db.getSbch2BchRecordByHashLock(ptx.hashLock)
+		if err != nil {
+			bot.logError("DB error, failed to get SBCH2BCH record: ", err)
+			return
+		}
+		if record.Status != Sbch2BchStatusSecretRevealed {
+			return
+		}
+		record.UpdateStatusToSbchUnlocked(toHex(txHash[:]))
+		err = bot.db.updateSbch2BchRecord(record)
+		if err != nil {
+			bot.logError("DB error, failed to update status of SBCH2BCH record: ", err)
+		}
+	case txTypeRefund:
+		record, err := bot.db.getBch2SbchRecordByHashLock(ptx.hashLock)
+		if err != nil {
+			bot.logError("DB error, failed to get BCH2SBCH record: ", err)
+			return
+		}
+		if record.Status != Bch2SbchStatusSbchLocked {
+			return // handled by handleSbchRefundEventB2S
+		}
+		record.UpdateStatusToSbchRefunded(toHex(txHash[:]))
+		err = bot.db.updateBch2SbchRecord(record)
+		if err != nil {
+			bot.logError("DB error, failed to update status of BCH2SBCH record: ", err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBotWithNewBch2SbchRecords(t *testing.T, n int) (*MarketMakerBot, *MockSbchClient) {
+	_db := initDB(t, 123, 456)
+	for i := 0; i < n; i++ {
+		require.NoError(t, _db.addBch2SbchRecord(&Bch2SbchRecord{
+			BchLockHeight:  123,
+			BchLockTxHash:  toHex(gethHash32Bytes("bchlock" + strconv.Itoa(i))),
+			Value:          1e7,
+			BchPrice:       1e8,
+			RecipientPkh:   toHex(testBchPkh),
+			SenderPkh:      toHex(gethAddrBytes("user")),
+			HashLock:       toHex(gethHash32Bytes("hash" + strconv.Itoa(i))),
+			TimeLock:       100,
+			SenderEvmAddr:  toHex(gethAddrBytes("evm")),
+			HtlcScriptHash: toHex(gethAddrBytes("htlc" + strconv.Itoa(i))),
+			Status:         Bch2SbchStatusNew,
+		}))
+	}
+
+	_sbchCli := newMockSbchClient(457, 999, 1234567890)
+	_sbchCli.balance = satsToWei(1e8)
+	_bot := &MarketMakerBot{
+		db:           _db,
+		dbQueryLimit: 100,
+		bchCli:       newMockBchClient(124, 125),
+		sbchCli:      _sbchCli,
+		bchPkh:       testBchPkh,
+		bchTimeLock:  72,
+		bchPrice:     1e8,
+		sbchPrice:    1e8,
+		errLogQueue:  newErrLogQueue(100),
+	}
+	return _bot, _sbchCli
+}
+
+func TestCheckPendingSbchTxs_inFlight(t *testing.T) {
+	_bot, _sbchCli := newTestBotWithNewBch2SbchRecords(t, 3)
+
+	// all lock txs are sent in one round, with consecutive nonces
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 3)
+	for i, tx := range _sbchCli.sentTxs {
+		require.Equal(t, uint64(i), tx.Nonce())
+	}
+	require.Equal(t, uint64(3e7), _bot.getPendingSbchLockValue())
+	require.True(t, _bot.hasPendingSbchTx(txTypeLock, toHex(gethHash32Bytes("hash0"))))
+	require.False(t, _bot.hasPendingSbchTx(txTypeRefund, toHex(gethHash32Bytes("hash0"))))
+
+	// sBCH locked by pending txs is reserved
+	_bot.handleBchUserDeposits()
+	book := _bot.liquidity.snapshot()[DirectionBch2Sbch]
+	require.Equal(t, uint64(1e8), book.Free)
+	require.Equal(t, uint64(3e7), book.Reserved)
+	require.Len(t, book.Reservations, 3)
+	require.Len(t, _sbchCli.sentTxs, 3)
+
+	// the first one is mined
+	_sbchCli.ts = 1234567899
+	_sbchCli.mineTx(_sbchCli.sentTxs[0], gethtypes.ReceiptStatusSuccessful)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 2)
+	record, err := _bot.db.getBch2SbchRecordByHashLock(toHex(gethHash32Bytes("hash0")))
+	require.NoError(t, err)
+	require.Equal(t, Bch2SbchStatusSbchLocked, record.Status)
+	require.Equal(t, toHex(_sbchCli.sentTxs[0].Hash().Bytes()), record.SbchLockTxHash)
+	require.Equal(t, uint64(1234567899), record.SbchLockTxTime)
+
+	_sbchCli.mineSentTxs()
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 0)
+	records, err := _bot.db.getBch2SbchRecordsByStatus(Bch2SbchStatusSbchLocked, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+}
+
+func TestCheckPendingSbchTxs_failed(t *testing.T) {
+	_bot, _sbchCli := newTestBotWithNewBch2SbchRecords(t, 1)
+
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 1)
+	_sbchCli.mineTx(_sbchCli.sentTxs[0], gethtypes.ReceiptStatusFailed)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 0)
+	require.Len(t, _bot.errLogQueue.removeErrLogs(100), 1)
+
+	// the record is locked again
+	records, err := _bot.db.getBch2SbchRecordsByStatus(Bch2SbchStatusNew, 100)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 2)
+	require.Equal(t, uint64(1), _sbchCli.sentTxs[1].Nonce())
+}
+
+func TestCheckPendingSbchTxs_resendAndDrop(t *testing.T) {
+	_bot, _sbchCli := newTestBotWithNewBch2SbchRecords(t, 2)
+
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 2)
+	tx0, tx1 := _sbchCli.sentTxs[0], _sbchCli.sentTxs[1]
+
+	// not mined for a while, resend
+	_bot.pendingSbchTxs[tx0.Hash()].resentAt = time.Now().Add(-sbchTxResendInterval)
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _sbchCli.resentTxs, 1)
+	require.Equal(t, tx0.Hash(), _sbchCli.resentTxs[0].Hash())
+	require.Len(t, _bot.pendingSbchTxs, 2)
+
+	// nonce of tx0 is used by another tx (sent before restart, for example)
+	_sbchCli.confirmedNonce = 1
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 1)
+	require.False(t, _bot.hasPendingSbchTx(txTypeLock, toHex(gethHash32Bytes("hash0"))))
+
+	// tx1 is not mined for too long, but it is still in mempool
+	_bot.pendingSbchTxs[tx1.Hash()].sentAt = time.Now().Add(-sbchTxDropTimeout)
+	_sbchCli.pendingNonce = 2
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 1)
+	require.True(t, _bot.hasPendingSbchTx(txTypeLock, toHex(gethHash32Bytes("hash1"))))
+
+	// tx1 is gone from mempool
+	_sbchCli.pendingNonce = 1
+	_bot.checkPendingSbchTxs()
+	require.Len(t, _bot.pendingSbchTxs, 0)
+
+	// nonce is synced, records are locked again
+	_bot.handleBchUserDeposits()
+	require.Len(t, _sbchCli.sentTxs, 4)
+	require.Equal(t, uint64(1), _sbchCli.sentTxs[2].Nonce())
+	require.Equal(t, uint64(2), _sbchCli.sentTxs[3].Nonce())
+}
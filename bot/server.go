@@ -2,12 +2,21 @@ package bot
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSwapListLimit = 20
+	maxSwapListLimit     = 100
 )
 
 type Info struct {
@@ -27,6 +36,23 @@ type SwapInfo struct {
 	Status   string  `json:"status"`
 }
 
+type SwapDetail struct {
+	Bch2Sbch    *Bch2SbchRecord     `json:"bch2sbch,omitempty"`
+	Sbch2Bch    *Sbch2BchRecord     `json:"sbch2bch,omitempty"`
+	Transitions []*StatusTransition `json:"transitions"`
+}
+
+type SwapListItem struct {
+	Direction string `json:"direction"`
+	Status    string `json:"status"`
+	Record    any    `json:"record"` // *Bch2SbchRecord or *Sbch2BchRecord
+}
+
+type SwapList struct {
+	Swaps      []SwapListItem `json:"swaps"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
 type Resp struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
@@ -64,6 +90,9 @@ func (bot *MarketMakerBot) StartHttpServer(listenAddr string) {
 		ReadTimeout:  3 * time.Second,
 		WriteTimeout: 5 * time.Second,
 	}
+	if bot.swapEvents != nil {
+		go bot.runSwapEventHub()
+	}
 	log.Info("server listening at:", listenAddr, "...")
 	err := server.ListenAndServe()
 	if err != nil {
@@ -76,6 +105,15 @@ func (bot *MarketMakerBot) createHttpHandlers() *http.ServeMux {
 	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) { bot.handlePing(w, r) })
 	mux.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) { bot.handleLogs(w, r) })
 	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) { bot.handleInfo(w, r) })
+	mux.HandleFunc("/swaps", func(w http.ResponseWriter, r *http.Request) { bot.handleSwaps(w, r) })
+	mux.HandleFunc("/swaps/", func(w http.ResponseWriter, r *http.Request) { bot.handleSwap(w, r) })
+	mux.HandleFunc("/reservations", func(w http.ResponseWriter, r *http.Request) { bot.handleReservations(w, r) })
+	if bot.metrics != nil {
+		mux.Handle("/metrics", bot.metrics.handler())
+	}
+	if bot.swapEvents != nil {
+		mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) { bot.handleSwapEvents(w, r) })
+	}
 	return mux
 }
 
@@ -86,11 +124,17 @@ func (bot *MarketMakerBot) handlePing(w http.ResponseWriter, r *http.Request) {
 
 // remove and return a number of logs from queue
 func (bot *MarketMakerBot) handleLogs(w http.ResponseWriter, r *http.Request) {
-	n := getIntQueryParam(r, "n", 100)
+	n := getIntQueryParam(r.URL.Query(), "n", 100)
 	logs := bot.errLogQueue.removeErrLogs(n)
 	NewOkResp(logs).WriteTo(w)
 }
 
+// return liquidity reserved for admitted swaps, by direction,
+// the books are kept across rounds (see LiquidityBook)
+func (bot *MarketMakerBot) handleReservations(w http.ResponseWriter, r *http.Request) {
+	NewOkResp(bot.liquidity.snapshot()).WriteTo(w)
+}
+
 // return bot balance info
 func (bot *MarketMakerBot) handleInfo(w http.ResponseWriter, r *http.Request) {
 	info, err := bot.getBotInfo()
@@ -101,6 +145,164 @@ func (bot *MarketMakerBot) handleInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// return swap records and status transitions of a hash lock
+func (bot *MarketMakerBot) handleSwap(w http.ResponseWriter, r *http.Request) {
+	hashLock := normalizeHex(strings.TrimPrefix(r.URL.Path, "/swaps/"))
+	if hashLock == "" {
+		NewErrResp("missing hash lock").WriteTo(w)
+		return
+	}
+
+	detail, err := bot.getSwapDetail(hashLock)
+	if err != nil {
+		NewErrResp(err.Error()).WriteTo(w)
+	} else {
+		NewOkResp(detail).WriteTo(w)
+	}
+}
+
+// list swap records, newest first
+// query params: direction, status, sender, recipient, from, to (unix seconds), limit, cursor
+func (bot *MarketMakerBot) handleSwaps(w http.ResponseWriter, r *http.Request) {
+	list, err := bot.listSwaps(r.URL.Query())
+	if err != nil {
+		NewErrResp(err.Error()).WriteTo(w)
+	} else {
+		NewOkResp(list).WriteTo(w)
+	}
+}
+
+func (bot *MarketMakerBot) getSwapDetail(hashLock string) (*SwapDetail, error) {
+	detail := &SwapDetail{}
+
+	b2sRecord, err := bot.db.getBch2SbchRecordByHashLock(hashLock)
+	if err == nil {
+		detail.Bch2Sbch = b2sRecord
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to query DB: %w", err)
+	}
+
+	s2bRecord, err := bot.db.getSbch2BchRecordByHashLock(hashLock)
+	if err == nil {
+		detail.Sbch2Bch = s2bRecord
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to query DB: %w", err)
+	}
+
+	if detail.Bch2Sbch == nil && detail.Sbch2Bch == nil {
+		return nil, fmt.Errorf("swap not found")
+	}
+
+	detail.Transitions, err = bot.db.GetStatusTransitions(hashLock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query DB: %w", err)
+	}
+	return detail, nil
+}
+
+func (bot *MarketMakerBot) listSwaps(params url.Values) (*SwapList, error) {
+	direction := strings.ToUpper(params.Get("direction"))
+	if direction != "" && direction != DirectionBch2Sbch && direction != DirectionSbch2Bch {
+		return nil, fmt.Errorf("invalid direction: %s", params.Get("direction"))
+	}
+
+	limit := getIntQueryParam(params, "limit", defaultSwapListLimit)
+	if limit <= 0 || limit > maxSwapListLimit {
+		limit = maxSwapListLimit
+	}
+
+	// the cursor is "<BCH2SBCH ID>-<SBCH2BCH ID>", records with IDs less than them are returned
+	var b2sBeforeID, s2bBeforeID uint64
+	if cursor := params.Get("cursor"); cursor != "" {
+		_, err := fmt.Sscanf(cursor, "%d-%d", &b2sBeforeID, &s2bBeforeID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+	}
+
+	filter := SwapFilter{
+		Sender:    normalizeHex(params.Get("sender")),
+		Recipient: normalizeHex(params.Get("recipient")),
+		Limit:     limit,
+	}
+	if from := getIntQueryParam(params, "from", 0); from > 0 {
+		filter.FromTime = time.Unix(int64(from), 0)
+	}
+	if to := getIntQueryParam(params, "to", 0); to > 0 {
+		filter.ToTime = time.Unix(int64(to), 0)
+	}
+
+	// status names are shared by both directions, but the numbers are not
+	queryB2S := direction != DirectionSbch2Bch
+	queryS2B := direction != DirectionBch2Sbch
+	var b2sStatus, s2bStatus int
+	if status := params.Get("status"); status != "" {
+		var ok1, ok2 bool
+		b2sStatus, ok1 = parseBch2SbchStatus(status)
+		s2bStatus, ok2 = parseSbch2BchStatus(status)
+		queryB2S = queryB2S && ok1
+		queryS2B = queryS2B && ok2
+		if !queryB2S && !queryS2B {
+			return nil, fmt.Errorf("invalid status: %s", status)
+		}
+	}
+
+	var b2sRecords []*Bch2SbchRecord
+	var s2bRecords []*Sbch2BchRecord
+	var err error
+	if queryB2S {
+		filter.BeforeID = uint(b2sBeforeID)
+		if params.Get("status") != "" {
+			filter.Status = &b2sStatus
+		}
+		b2sRecords, err = bot.db.queryBch2SbchRecords(filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query DB: %w", err)
+		}
+	}
+	if queryS2B {
+		filter.BeforeID = uint(s2bBeforeID)
+		if params.Get("status") != "" {
+			filter.Status = &s2bStatus
+		}
+		s2bRecords, err = bot.db.querySbch2BchRecords(filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query DB: %w", err)
+		}
+	}
+
+	// merge records of both directions, newest first
+	list := &SwapList{Swaps: make([]SwapListItem, 0, limit)}
+	i, j := 0, 0
+	for len(list.Swaps) < limit && (i < len(b2sRecords) || j < len(s2bRecords)) {
+		if j >= len(s2bRecords) ||
+			(i < len(b2sRecords) && !b2sRecords[i].CreatedAt.Before(s2bRecords[j].CreatedAt)) {
+
+			record := b2sRecords[i]
+			list.Swaps = append(list.Swaps, SwapListItem{
+				Direction: DirectionBch2Sbch,
+				Status:    record.Status.String(),
+				Record:    record,
+			})
+			b2sBeforeID = uint64(record.ID)
+			i++
+		} else {
+			record := s2bRecords[j]
+			list.Swaps = append(list.Swaps, SwapListItem{
+				Direction: DirectionSbch2Bch,
+				Status:    record.Status.String(),
+				Record:    record,
+			})
+			s2bBeforeID = uint64(record.ID)
+			j++
+		}
+	}
+	if len(list.Swaps) == limit {
+		list.NextCursor = fmt.Sprintf("%d-%d", b2sBeforeID, s2bBeforeID)
+	}
+	return list, nil
+}
+
 func (bot *MarketMakerBot) getBotInfo() (*Info, error) {
 	freeBch, err := bot.getFreeBch()
 	if err != nil {
@@ -123,8 +325,8 @@ func (bot *MarketMakerBot) getBotInfo() (*Info, error) {
 	}
 
 	return &Info{
-		FreeBch:          freeBch,
-		FreeSbch:         freeSbch,
+		FreeBch:          satsToUtxoAmt(freeBch),
+		FreeSbch:         satsToUtxoAmt(freeSbch),
 		LockedBch:        lockedBch,
 		LockedSbch:       lockedSbch,
 		ToBeUnlockedBch:  toBeUnlockedBch,
@@ -134,25 +336,27 @@ func (bot *MarketMakerBot) getBotInfo() (*Info, error) {
 	}, nil
 }
 
-func (bot *MarketMakerBot) getFreeBch() (float64, error) {
+// in sats
+func (bot *MarketMakerBot) getFreeBch() (uint64, error) {
 	utxos, err := bot.bchCli.GetAllUTXOs()
 	if err != nil {
 		return 0, err
 	}
 
-	freeBch := float64(0)
+	freeBch := uint64(0)
 	for _, utxo := range utxos {
-		freeBch += utxo.Amount
+		freeBch += uint64(utxoAmtToSats(utxo.Amount))
 	}
 	return freeBch, nil
 }
 
-func (bot *MarketMakerBot) getFreeSbch() (float64, error) {
+// in sats
+func (bot *MarketMakerBot) getFreeSbch() (uint64, error) {
 	freeSbch, err := bot.sbchCliRO.getBotBalance()
 	if err != nil {
 		return 0, err
 	}
-	return satsToUtxoAmt(weiToSats(freeSbch)), nil
+	return weiToSats(freeSbch), nil
 }
 
 func (bot *MarketMakerBot) getSbch2BchInfo() (
@@ -253,8 +457,34 @@ func (bot *MarketMakerBot) getBch2SbchInfo() (
 	return
 }
 
-func getIntQueryParam(r *http.Request, name string, defaultVal int) int {
-	params := r.URL.Query()[name]
+// status name or number
+func parseBch2SbchStatus(s string) (int, bool) {
+	for status := Bch2SbchStatusNew; status <= Bch2SbchStatusInsufficientLiquidity; status++ {
+		if strings.EqualFold(s, status.String()) || s == strconv.Itoa(int(status)) {
+			return int(status), true
+		}
+	}
+	return 0, false
+}
+
+// status name or number
+func parseSbch2BchStatus(s string) (int, bool) {
+	for status := Sbch2BchStatusNew; status <= Sbch2BchStatusInsufficientLiquidity; status++ {
+		if strings.EqualFold(s, status.String()) || s == strconv.Itoa(int(status)) {
+			return int(status), true
+		}
+	}
+	return 0, false
+}
+
+// hex strings are saved without 0x prefix in lower case
+func normalizeHex(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.TrimPrefix(s, "0x")
+}
+
+func getIntQueryParam(query url.Values, name string, defaultVal int) int {
+	params := query[name]
 	if len(params) == 0 {
 		return defaultVal
 	}
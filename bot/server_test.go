@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSwapDetail(t *testing.T) {
+	db := initDB(t, 123, 456)
+	bot := &MarketMakerBot{db: db}
+
+	b2s := createFakeBch2SbchRecord(1)
+	b2s.HashLock = "abcd"
+	require.NoError(t, db.addBch2SbchRecord(b2s))
+	b2s.UpdateStatusToSbchLocked("sl", 1234)
+	require.NoError(t, db.updateBch2SbchRecord(b2s))
+
+	detail, err := bot.getSwapDetail("abcd")
+	require.NoError(t, err)
+	require.Nil(t, detail.Sbch2Bch)
+	require.Equal(t, "sl", detail.Bch2Sbch.SbchLockTxHash)
+	require.Len(t, detail.Transitions, 2)
+
+	_, err = bot.getSwapDetail("1234")
+	require.ErrorContains(t, err, "swap not found")
+
+	w := httptest.NewRecorder()
+	bot.createHttpHandlers().ServeHTTP(w, httptest.NewRequest("GET", "/swaps/0xABCD", nil))
+	var resp struct {
+		Success bool
+		Result  SwapDetail
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.True(t, resp.Success)
+	require.Equal(t, b2s.ID, resp.Result.Bch2Sbch.ID)
+}
+
+func TestListSwaps(t *testing.T) {
+	db := initDB(t, 123, 456)
+	bot := &MarketMakerBot{db: db}
+
+	// B2S: 1, 3, 5; S2B: 2, 4, 6 (created in this order)
+	for i := uint(1); i <= 6; i++ {
+		if i%2 == 1 {
+			record := createFakeBch2SbchRecord(i)
+			record.SenderPkh = "aa"
+			if i == 5 {
+				record.Status = Bch2SbchStatusPriceChanged
+			}
+			require.NoError(t, db.addBch2SbchRecord(record))
+		} else {
+			record := createFakeSbch2BchRecord(i)
+			record.SbchSenderAddr = "aa"
+			require.NoError(t, db.addSbch2BchRecord(record))
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	list, err := bot.listSwaps(url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"6", "5", "4", "3", "2", "1"}, getSwapListHashLocks(list))
+	require.Equal(t, "", list.NextCursor)
+
+	// pagination
+	list, err = bot.listSwaps(url.Values{"limit": {"4"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"6", "5", "4", "3"}, getSwapListHashLocks(list))
+	require.Equal(t, "2-2", list.NextCursor)
+	list, err = bot.listSwaps(url.Values{"limit": {"4"}, "cursor": {list.NextCursor}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"2", "1"}, getSwapListHashLocks(list))
+	require.Equal(t, "", list.NextCursor)
+
+	// filters
+	list, err = bot.listSwaps(url.Values{"direction": {"sbch2bch"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"6", "4", "2"}, getSwapListHashLocks(list))
+	require.Equal(t, DirectionSbch2Bch, list.Swaps[0].Direction)
+	require.Equal(t, "New", list.Swaps[0].Status)
+
+	list, err = bot.listSwaps(url.Values{"status": {"PriceChanged"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"5"}, getSwapListHashLocks(list))
+
+	list, err = bot.listSwaps(url.Values{"status": {"SbchUnlocked"}})
+	require.NoError(t, err)
+	require.Len(t, list.Swaps, 0)
+
+	list, err = bot.listSwaps(url.Values{"sender": {"0xAA"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"6", "5", "4", "3", "2", "1"}, getSwapListHashLocks(list))
+
+	list, err = bot.listSwaps(url.Values{"recipient": {"3"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"3"}, getSwapListHashLocks(list))
+
+	list, err = bot.listSwaps(url.Values{"from": {"1"}, "to": {"2"}})
+	require.NoError(t, err)
+	require.Len(t, list.Swaps, 0)
+
+	_, err = bot.listSwaps(url.Values{"direction": {"bch"}})
+	require.ErrorContains(t, err, "invalid direction")
+	_, err = bot.listSwaps(url.Values{"status": {"Unknown"}})
+	require.ErrorContains(t, err, "invalid status")
+	_, err = bot.listSwaps(url.Values{"direction": {"BCH2SBCH"}, "status": {"BchLocked"}})
+	require.ErrorContains(t, err, "invalid status")
+	_, err = bot.listSwaps(url.Values{"cursor": {"abc"}})
+	require.ErrorContains(t, err, "invalid cursor")
+}
+
+func getSwapListHashLocks(list *SwapList) []string {
+	hashLocks := make([]string, len(list.Swaps))
+	for i, item := range list.Swaps {
+		switch record := item.Record.(type) {
+		case *Bch2SbchRecord:
+			hashLocks[i] = record.HashLock
+		case *Sbch2BchRecord:
+			hashLocks[i] = record.HashLock
+		}
+	}
+	return hashLocks
+}
+
+func TestHandleReservations(t *testing.T) {
+	bot := &MarketMakerBot{}
+	bot.liquidity.update(DirectionSbch2Bch, 1000, nil)
+	_, ok := bot.liquidity.reserve(DirectionSbch2Bch, "abcd", 600)
+	require.True(t, ok)
+
+	w := httptest.NewRecorder()
+	bot.createHttpHandlers().ServeHTTP(w, httptest.NewRequest("GET", "/reservations", nil))
+	var resp struct {
+		Success bool
+		Result  map[string]*LiquidityBook
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.True(t, resp.Success)
+	require.Len(t, resp.Result, 1)
+	book := resp.Result[DirectionSbch2Bch]
+	require.Equal(t, uint64(1000), book.Free)
+	require.Equal(t, uint64(600), book.Reserved)
+	require.Equal(t, []*Reservation{{HashLock: "abcd", Value: 600}}, book.Reservations)
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"github.com/gcash/bchd/btcjson"
+	log "github.com/sirupsen/logrus"
+)
+
+// key: utxoKey(txID, vout)
+func (bot *MarketMakerBot) getReservedUtxos() (map[string]bool, error) {
+	reservations, err := bot.db.getUtxoReservations()
+	if err != nil {
+		return nil, err
+	}
+	reserved := make(map[string]bool, len(reservations))
+	for _, r := range reservations {
+		reserved[utxoKey(r.TxID, r.Vout)] = true
+	}
+	return reserved, nil
+}
+
+// mark UTXOs as spent-pending before the spending tx is sent
+func (bot *MarketMakerBot) reserveUtxos(utxos []btcjson.ListUnspentResult, spendingTxHash, hashLock string) error {
+	reservations := make([]*UtxoReservation, len(utxos))
+	for i, utxo := range utxos {
+		reservations[i] = &UtxoReservation{
+			TxID:           utxo.TxID,
+			Vout:           utxo.Vout,
+			Value:          uint64(utxoAmtToSats(utxo.Amount)),
+			SpendingTxHash: spendingTxHash,
+			HashLock:       hashLock,
+		}
+	}
+	return bot.db.addUtxoReservations(reservations)
+}
+
+// release UTXOs reserved by confirmed or dropped txs
+func (bot *MarketMakerBot) releaseUtxoReservations() {
+	reservations, err := bot.db.getUtxoReservations()
+	if err != nil {
+		bot.logError("DB error, failed to get UTXO reservations: ", err)
+		return
+	}
+	if len(reservations) == 0 {
+		return
+	}
+
+	log.Info("check UTXO reservations ...")
+	checked := map[string]bool{}
+	for _, r := range reservations {
+		if checked[r.SpendingTxHash] {
+			continue
+		}
+		checked[r.SpendingTxHash] = true
+
+		confirmations, err := bot.bchCli.GetTxConfirmations(r.SpendingTxHash)
+		if err != nil {
+			if !isTxNotFoundErr(err) {
+				bot.logError("RPC error, failed to get tx confirmations: ", err)
+				continue
+			}
+			bot.logWarnf("UTXO spending tx dropped: %s, hashLock: %s", r.SpendingTxHash, r.HashLock)
+		} else if confirmations == 0 {
+			continue
+		}
+
+		log.Info("release UTXOs reserved by tx: ", r.SpendingTxHash)
+		err = bot.db.deleteUtxoReservationsBySpendingTx(r.SpendingTxHash)
+		if err != nil {
+			bot.logError("DB error, failed to release UTXOs: ", err)
+		}
+	}
+}
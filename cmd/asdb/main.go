@@ -11,6 +11,14 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	dbFile := "./bot.db"
 	format := "text"
 
@@ -49,6 +57,52 @@ func main() {
 	}
 }
 
+// asdb migrate [dbFile or DSN] [--dry-run]
+func migrate(args []string) error {
+	dbFile := "./bot.db"
+	dryRun := false
+	for _, arg := range args {
+		if arg == "--dry-run" {
+			dryRun = true
+		} else {
+			dbFile = arg
+		}
+	}
+
+	db, err := bot.OpenDB(dbFile)
+	if err != nil {
+		return err
+	}
+
+	ver, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Println("current schema version:", ver)
+
+	if dryRun {
+		pending, err := db.PendingMigrations()
+		if err != nil {
+			return err
+		}
+		fmt.Println("pending migrations:", len(pending))
+		for _, m := range pending {
+			fmt.Printf("  %d: %s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		fmt.Printf("applied %d: %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("applied migrations:", len(applied))
+	return nil
+}
+
 func printBch2SbchRecords(records []*bot.Bch2SbchRecord) {
 	fmt.Println("BCH2SBCH records:")
 	j, _ := json.MarshalIndent(records, "", "  ")
@@ -82,6 +136,7 @@ func printBch2SbchRecordsTable(records []*bot.Bch2SbchRecord) {
 		"Secret",
 		"BchUnlockTx",
 		"SbchRefundTx",
+		"BchRefundTx",
 		"Status",
 	})
 	for _, record := range records {
@@ -103,6 +158,7 @@ func printBch2SbchRecordsTable(records []*bot.Bch2SbchRecord) {
 			subStr12(record.Secret),
 			subStr12(record.BchUnlockTxHash),
 			subStr12(record.SbchRefundTxHash),
+			subStr12(record.BchRefundTxHash),
 			intToStr(record.Status),
 		})
 	}
@@ -128,6 +184,7 @@ func printSbch2BchRecordsTable(records []*bot.Sbch2BchRecord) {
 		"Secret",
 		"SbchUnlockTx",
 		"BchRefundTx",
+		"SbchRefundTx",
 		"Status",
 	})
 	for _, record := range records {
@@ -147,6 +204,7 @@ func printSbch2BchRecordsTable(records []*bot.Sbch2BchRecord) {
 			subStr12(record.Secret),
 			subStr12(record.SbchUnlockTxHash),
 			subStr12(record.BchRefundTxHash),
+			subStr12(record.SbchRefundTxHash),
 			intToStr(record.Status),
 		})
 	}
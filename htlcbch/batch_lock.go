@@ -0,0 +1,140 @@
+package htlcbch
+
+import (
+	"fmt"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/wire"
+	"github.com/gcash/bchutil"
+)
+
+// one HTLC output of a lock tx, it is followed by its OP_RETURN output
+type lockOutput struct {
+	Covenant *HtlcCovenant
+	Amount   int64 // in sats
+}
+
+// makeBatchLockTx locks coins into several covenants by one tx,
+// output#2i: deposit i, output#2i+1: op_return i, last output: change (optional).
+// MakeLockTx only uses it with one output: each OP_RETURN output is 117 bytes,
+// so a tx with more than one HTLC output exceeds the default data carrier limit (223 bytes)
+// and is not relayed by BCH nodes as a standard tx
+func makeBatchLockTx(
+	fromKey *bchec.PrivateKey,
+	inputs []InputInfo,
+	outputs []lockOutput,
+	minerFeeRate uint64,
+) (*wire.MsgTx, error) {
+	// estimate miner fee, assume there is a change output
+	tx, err := makeBatchLockTxWithFee(fromKey, inputs, outputs, 0)
+	if err != nil {
+		return nil, err
+	}
+	txSize := int64(len(MsgTxToBytes(tx)))
+	if len(tx.TxOut) == len(outputs)*2 {
+		txSize += P2PKHOutputSize
+	}
+	minerFee := txSize * int64(minerFeeRate)
+
+	var totalInAmt, totalOutAmt int64
+	for _, input := range inputs {
+		totalInAmt += input.Amount
+	}
+	for _, output := range outputs {
+		totalOutAmt += output.Amount
+	}
+	if totalInAmt-totalOutAmt-minerFee <= DustAmt {
+		// no change output, the remaining value is paid as miner fee
+		minFee := (txSize - P2PKHOutputSize) * int64(minerFeeRate)
+		if totalInAmt-totalOutAmt < minFee {
+			return nil, fmt.Errorf("insufficient input value: %d < %d", totalInAmt, totalOutAmt+minFee)
+		}
+		minerFee = totalInAmt - totalOutAmt
+	}
+
+	// make tx
+	return makeBatchLockTxWithFee(fromKey, inputs, outputs, minerFee)
+}
+
+// returns the size of lock tx without inputs and change output,
+// it is used to estimate miner fee before UTXOs are selected
+func getBatchLockTxBaseSize(covenants []*HtlcCovenant) (int64, error) {
+	if len(covenants) == 0 {
+		return 0, fmt.Errorf("no outputs")
+	}
+
+	builder := newMsgTxBuilder()
+	for _, c := range covenants {
+		toAddr, opRetScript, err := c.getLockOutputScripts()
+		if err != nil {
+			return 0, err
+		}
+		builder.addOutput(toAddr, 0)
+		builder.addOpRet(opRetScript)
+	}
+	tx, err := builder.build()
+	if err != nil {
+		return 0, err
+	}
+	return int64(tx.SerializeSize()), nil
+}
+
+func makeBatchLockTxWithFee(
+	fromKey *bchec.PrivateKey,
+	inputs []InputInfo,
+	outputs []lockOutput,
+	minerFee int64,
+) (*wire.MsgTx, error) {
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no outputs")
+	}
+
+	fromPk := fromKey.PubKey().SerializeCompressed()
+	fromPkh := bchutil.Hash160(fromPk)
+	changeAddr, err := bchutil.NewAddressPubKeyHash(fromPkh, outputs[0].Covenant.net)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calc p2pkh address: %w", err)
+	}
+
+	builder := newMsgTxBuilder()
+	var totalInAmt, totalOutAmt int64
+	for _, input := range inputs {
+		builder.addInput(input.TxID, input.Vout, 0, nil)
+		totalInAmt += input.Amount
+	}
+	for _, output := range outputs {
+		toAddr, opRetScript, err := output.Covenant.getLockOutputScripts()
+		if err != nil {
+			return nil, err
+		}
+		builder.addOutput(toAddr, output.Amount)
+		builder.addOpRet(opRetScript)
+		totalOutAmt += output.Amount
+	}
+	changeAmt := totalInAmt - totalOutAmt - minerFee
+	if changeAmt < 0 {
+		return nil, fmt.Errorf("insufficient input value: %d < %d", totalInAmt, totalOutAmt+minerFee)
+	}
+	builder.addChange(changeAddr, changeAmt)
+	builder.signP2PKHInputs(inputs, fromKey)
+	return builder.build()
+}
+
+// returns the P2SH address and OP_RETURN script of lock tx
+func (c *HtlcCovenant) getLockOutputScripts() (bchutil.Address, []byte, error) {
+	script, err := c.BuildFullRedeemScript()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build full redeem script: %w", err)
+	}
+
+	toAddr, err := bchutil.NewAddressScriptHash(script, c.net)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to calc p2sh address: %w", err)
+	}
+
+	opRetScript, err := c.BuildOpRetPkScript(make([]byte, 20), 1e8)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build OP_RETURN: %w", err)
+	}
+	return toAddr, opRetScript, nil
+}
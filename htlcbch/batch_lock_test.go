@@ -0,0 +1,97 @@
+package htlcbch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	gethcmn "github.com/ethereum/go-ethereum/common"
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/txscript"
+)
+
+func newTestBatchLockOutputs(t *testing.T, n int) (outputs []lockOutput) {
+	for i := 0; i < n; i++ {
+		c, err := NewCovenant(
+			testSenderPkh,
+			testRecipientPkh,
+			gethcmn.Hash{'h', 'a', 's', 'h', byte(i)}.Bytes(),
+			testExpiration,
+			testPenaltyBPS,
+			&chaincfg.TestNet3Params,
+		)
+		require.NoError(t, err)
+		outputs = append(outputs, lockOutput{Covenant: c, Amount: int64(10000 * (i + 1))})
+	}
+	return
+}
+
+func TestMakeBatchLockTx(t *testing.T) {
+	outputs := newTestBatchLockOutputs(t, 3)
+	inputs := []InputInfo{
+		{TxID: gethcmn.Hash{'t', 'x', '1'}.Bytes(), Vout: 0, Amount: 50000},
+		{TxID: gethcmn.Hash{'t', 'x', '2'}.Bytes(), Vout: 1, Amount: 50000},
+	}
+
+	tx, err := makeBatchLockTx(testSenderWIF.PrivKey, inputs, outputs, 2)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 2)
+	require.Len(t, tx.TxOut, 7)
+	for i, output := range outputs {
+		scriptHash, err := output.Covenant.GetRedeemScriptHash()
+		require.NoError(t, err)
+		require.Equal(t, scriptHash, getP2SHash(tx.TxOut[2*i].PkScript))
+		require.Equal(t, output.Amount, tx.TxOut[2*i].Value)
+		opRetScript, err := output.Covenant.BuildOpRetPkScript(make([]byte, 20), 1e8)
+		require.NoError(t, err)
+		require.Equal(t, opRetScript, tx.TxOut[2*i+1].PkScript)
+	}
+	changePkScript, err := txscript.PayToAddrScript(testSenderAddr)
+	require.NoError(t, err)
+	require.Equal(t, changePkScript, tx.TxOut[6].PkScript)
+	size := int64(len(MsgTxToBytes(tx)))
+	minerFee := 100000 - 60000 - tx.TxOut[6].Value
+	require.InDelta(t, size*2, minerFee, 2*2) // signatures may be 1 byte shorter or longer after re-signing
+
+	// signatures
+	prevPkScript, err := payToPubKeyHashPkScript(testSenderPkh)
+	require.NoError(t, err)
+	for i, input := range inputs {
+		vm, err := txscript.NewEngine(prevPkScript, tx, i,
+			txscript.StandardVerifyFlags, nil, nil, nil, input.Amount)
+		require.NoError(t, err)
+		require.NoError(t, vm.Execute(), i)
+	}
+
+	// the same as MakeLockTx if there is only one output
+	tx1, err := makeBatchLockTx(testSenderWIF.PrivKey, inputs, outputs[:1], 2)
+	require.NoError(t, err)
+	tx2, err := outputs[0].Covenant.MakeLockTx(testSenderWIF.PrivKey, inputs, outputs[0].Amount, 2)
+	require.NoError(t, err)
+	require.Equal(t, MsgTxToHex(tx1), MsgTxToHex(tx2))
+
+	_, err = makeBatchLockTx(testSenderWIF.PrivKey, inputs, nil, 2)
+	require.ErrorContains(t, err, "no outputs")
+	_, err = makeBatchLockTx(testSenderWIF.PrivKey, inputs[:1], outputs, 2)
+	require.ErrorContains(t, err, "insufficient input value")
+}
+
+func TestGetBatchLockTxBaseSize(t *testing.T) {
+	outputs := newTestBatchLockOutputs(t, 3)
+	covenants := []*HtlcCovenant{outputs[0].Covenant, outputs[1].Covenant, outputs[2].Covenant}
+
+	baseSize1, err := getBatchLockTxBaseSize(covenants[:1])
+	require.NoError(t, err)
+	require.Equal(t, int64(168), baseSize1)
+	baseSize3, err := getBatchLockTxBaseSize(covenants)
+	require.NoError(t, err)
+	require.Equal(t, baseSize1+2*(baseSize1-10), baseSize3)
+
+	inputs := []InputInfo{{TxID: gethcmn.Hash{'t', 'x', 'i', 'd'}.Bytes(), Vout: 1, Amount: 100000}}
+	tx, err := makeBatchLockTx(testSenderWIF.PrivKey, inputs, outputs, 2)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(MsgTxToBytes(tx)), int(baseSize3)+P2PKHInputSize+P2PKHOutputSize)
+
+	_, err = getBatchLockTxBaseSize(nil)
+	require.ErrorContains(t, err, "no outputs")
+}
@@ -0,0 +1,92 @@
+package htlcbch
+
+import (
+	"fmt"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/wire"
+	"github.com/gcash/bchutil"
+)
+
+// MakeConsolidationTx merges P2PKH UTXOs owned by fromKey into nOutputs coins of (almost) the same value
+func MakeConsolidationTx(
+	fromKey *bchec.PrivateKey,
+	inputs []InputInfo,
+	toAddr bchutil.Address,
+	nOutputs int,
+	minerFeeRate uint64,
+) (*wire.MsgTx, error) {
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no inputs")
+	}
+	if nOutputs <= 0 {
+		return nil, fmt.Errorf("invalid number of outputs: %d", nOutputs)
+	}
+
+	// estimate miner fee
+	tx, err := makeConsolidationTx(fromKey, inputs, toAddr, nOutputs, 0)
+	if err != nil {
+		return nil, err
+	}
+	// make tx
+	minerFee := int64(len(MsgTxToBytes(tx))) * int64(minerFeeRate)
+	return makeConsolidationTx(fromKey, inputs, toAddr, nOutputs, minerFee)
+}
+
+func makeConsolidationTx(
+	fromKey *bchec.PrivateKey,
+	inputs []InputInfo,
+	toAddr bchutil.Address,
+	nOutputs int,
+	minerFee int64,
+) (*wire.MsgTx, error) {
+	builder := newMsgTxBuilder()
+	var totalInAmt int64
+	for _, input := range inputs {
+		builder.addInput(input.TxID, input.Vout, 0, nil)
+		totalInAmt += input.Amount
+	}
+
+	// the remainder goes to the first output
+	totalOutAmt := totalInAmt - minerFee
+	outAmt := totalOutAmt / int64(nOutputs)
+	if outAmt <= DustAmt {
+		return nil, fmt.Errorf("output value is too small: %d", outAmt)
+	}
+	for i := 0; i < nOutputs; i++ {
+		if i == 0 {
+			builder.addOutput(toAddr, outAmt+totalOutAmt%int64(nOutputs))
+		} else {
+			builder.addOutput(toAddr, outAmt)
+		}
+	}
+	builder.signP2PKHInputs(inputs, fromKey)
+	return builder.build()
+}
+
+// MakeCpfpTx spends the change output of an unconfirmed parent tx,
+// its miner fee lifts the fee rate of the parent+child package to packageFeeRate
+func MakeCpfpTx(
+	fromKey *bchec.PrivateKey,
+	input InputInfo, // change output of the parent tx
+	toAddr bchutil.Address,
+	parentSize int64,
+	parentFee int64,
+	packageFeeRate uint64,
+) (*wire.MsgTx, error) {
+	inputs := []InputInfo{input}
+
+	// estimate the size of child tx
+	tx, err := makeConsolidationTx(fromKey, inputs, toAddr, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	childSize := int64(len(MsgTxToBytes(tx)))
+
+	// the child pays at least for itself
+	minerFee := (parentSize+childSize)*int64(packageFeeRate) - parentFee
+	if minerFee < childSize {
+		minerFee = childSize
+	}
+	return makeConsolidationTx(fromKey, inputs, toAddr, 1, minerFee)
+}
@@ -0,0 +1,73 @@
+package htlcbch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	gethcmn "github.com/ethereum/go-ethereum/common"
+	"github.com/gcash/bchd/txscript"
+)
+
+func TestMakeConsolidationTx(t *testing.T) {
+	inputs := []InputInfo{
+		{TxID: gethcmn.Hash{'t', 'x', '1'}.Bytes(), Vout: 0, Amount: 10000},
+		{TxID: gethcmn.Hash{'t', 'x', '2'}.Bytes(), Vout: 1, Amount: 20000},
+		{TxID: gethcmn.Hash{'t', 'x', '3'}.Bytes(), Vout: 2, Amount: 30001},
+	}
+	toPkScript, err := txscript.PayToAddrScript(testSenderAddr)
+	require.NoError(t, err)
+
+	tx, err := MakeConsolidationTx(testSenderWIF.PrivKey, inputs, testSenderAddr, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 3)
+	require.Len(t, tx.TxOut, 2)
+	size := int64(len(MsgTxToBytes(tx)))
+	require.LessOrEqual(t, size, int64(10+3*P2PKHInputSize+2*P2PKHOutputSize))
+	minerFee := 60001 - tx.TxOut[0].Value - tx.TxOut[1].Value
+	require.InDelta(t, size*2, minerFee, 3*2) // signatures may be 1 byte shorter or longer after re-signing
+	require.Equal(t, tx.TxOut[1].Value+(60001-minerFee)%2, tx.TxOut[0].Value)
+	for _, txOut := range tx.TxOut {
+		require.Equal(t, toPkScript, txOut.PkScript)
+	}
+
+	// signatures
+	prevPkScript, err := payToPubKeyHashPkScript(testSenderPkh)
+	require.NoError(t, err)
+	for i, input := range inputs {
+		vm, err := txscript.NewEngine(prevPkScript, tx, i,
+			txscript.StandardVerifyFlags, nil, nil, nil, input.Amount)
+		require.NoError(t, err)
+		require.NoError(t, vm.Execute(), i)
+	}
+
+	_, err = MakeConsolidationTx(testSenderWIF.PrivKey, nil, testSenderAddr, 1, 2)
+	require.ErrorContains(t, err, "no inputs")
+	_, err = MakeConsolidationTx(testSenderWIF.PrivKey, inputs, testSenderAddr, 0, 2)
+	require.ErrorContains(t, err, "invalid number of outputs: 0")
+	_, err = MakeConsolidationTx(testSenderWIF.PrivKey, inputs, testSenderAddr, 100, 2)
+	require.ErrorContains(t, err, "output value is too small")
+}
+
+func TestMakeCpfpTx(t *testing.T) {
+	input := InputInfo{TxID: gethcmn.Hash{'p', 'a', 'r', 'e', 'n', 't'}.Bytes(), Vout: 2, Amount: 10000}
+
+	// parent: 400 bytes, 400 sats
+	tx, err := MakeCpfpTx(testSenderWIF.PrivKey, input, testSenderAddr, 400, 400, 5)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 1)
+	require.Len(t, tx.TxOut, 1)
+	childSize := int64(len(MsgTxToBytes(tx)))
+	childFee := 10000 - tx.TxOut[0].Value
+	require.InDelta(t, (400+childSize)*5-400, childFee, 5) // signature may be 1 byte shorter or longer
+
+	// parent pays enough, the child pays for itself
+	tx, err = MakeCpfpTx(testSenderWIF.PrivKey, input, testSenderAddr, 400, 4000, 5)
+	require.NoError(t, err)
+	childSize = int64(len(MsgTxToBytes(tx)))
+	require.InDelta(t, childSize, 10000-tx.TxOut[0].Value, 1)
+
+	// change is too small
+	_, err = MakeCpfpTx(testSenderWIF.PrivKey, input, testSenderAddr, 400, 400, 50)
+	require.ErrorContains(t, err, "output value is too small")
+}
@@ -1,6 +1,7 @@
 package htlcbch
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -111,38 +112,73 @@ func (c *HtlcCovenant) GetP2SHAddress() (string, error) {
 	return c.net.CashAddressPrefix + ":" + addr.EncodeAddress(), nil
 }
 
+// the covenant only accepts HTLC input#0 (both unlock and refund),
+// so each HTLC UTXO is spent by its own tx, and can not be batched with other HTLC UTXOs
 func (c *HtlcCovenant) MakeUnlockTx(
 	txid []byte, vout uint32, inAmt int64, // input info
 	minerFeeRate uint64,
 	secret []byte,
 ) (*wire.MsgTx, error) {
+	return c.MakeUnlockTxWithInputs(txid, vout, inAmt, minerFeeRate, secret, nil, nil)
+}
+
+// MakeUnlockTxWithInputs is MakeUnlockTx which also spends P2PKH UTXOs owned by the recipient (input#1~n),
+// their value is merged into output#0, so the recipient's UTXOs are consolidated by the unlock tx
+func (c *HtlcCovenant) MakeUnlockTxWithInputs(
+	txid []byte, vout uint32, inAmt int64, // HTLC input info
+	minerFeeRate uint64,
+	secret []byte,
+	recipientKey *bchec.PrivateKey, // only used if there are P2PKH inputs
+	inputs []InputInfo, // P2PKH inputs
+) (*wire.MsgTx, error) {
+	if err := checkInputsOwner(recipientKey, inputs, c.recipientPkh); err != nil {
+		return nil, err
+	}
+
 	// estimate miner fee
-	tx, err := c.makeUnlockTx(txid, vout, inAmt, secret, 1000)
+	tx, err := c.makeUnlockTx(txid, vout, inAmt, secret, recipientKey, inputs, 1000)
 	if err != nil {
 		return nil, err
 	}
 	// make tx
 	minerFee := int64(len(MsgTxToBytes(tx))) * int64(minerFeeRate)
-	return c.makeUnlockTx(txid, vout, inAmt, secret, minerFee)
+	return c.makeUnlockTx(txid, vout, inAmt, secret, recipientKey, inputs, minerFee)
 }
 
 func (c *HtlcCovenant) MakeRefundTx(
 	txid []byte, vout uint32, inAmt int64, // input info
 	minerFeeRate uint64,
 ) (*wire.MsgTx, error) {
+	return c.MakeRefundTxWithInputs(txid, vout, inAmt, minerFeeRate, nil, nil)
+}
+
+// MakeRefundTxWithInputs is MakeRefundTx which also spends P2PKH UTXOs owned by the sender (input#1~n),
+// their value is merged into output#0, so the sender's UTXOs are consolidated by the refund tx
+func (c *HtlcCovenant) MakeRefundTxWithInputs(
+	txid []byte, vout uint32, inAmt int64, // HTLC input info
+	minerFeeRate uint64,
+	senderKey *bchec.PrivateKey, // only used if there are P2PKH inputs
+	inputs []InputInfo, // P2PKH inputs
+) (*wire.MsgTx, error) {
+	if err := checkInputsOwner(senderKey, inputs, c.senderPkh); err != nil {
+		return nil, err
+	}
+
 	// estimate miner fee
-	tx, err := c.makeRefundTx(txid, vout, inAmt, 1000)
+	tx, err := c.makeRefundTx(txid, vout, inAmt, senderKey, inputs, 1000)
 	if err != nil {
 		return nil, err
 	}
 	// make tx
 	minerFee := int64(len(MsgTxToBytes(tx))) * int64(minerFeeRate)
-	return c.makeRefundTx(txid, vout, inAmt, minerFee)
+	return c.makeRefundTx(txid, vout, inAmt, senderKey, inputs, minerFee)
 }
 
 func (c *HtlcCovenant) makeUnlockTx(
 	txid []byte, vout uint32, inAmt int64, // input info
 	secret []byte,
+	recipientKey *bchec.PrivateKey,
+	inputs []InputInfo,
 	minerFee int64,
 ) (*wire.MsgTx, error) {
 
@@ -162,14 +198,19 @@ func (c *HtlcCovenant) makeUnlockTx(
 		return nil, err
 	}
 
-	return newMsgTxBuilder().
-		addInput(txid, vout, seq, sigScript).
+	builder := newMsgTxBuilder().
+		addInput(txid, vout, seq, sigScript)
+	inAmt += addP2PKHInputs(builder, inputs)
+	return builder.
 		addOutput(toAddr, inAmt-minerFee).
+		signP2PKHInputsFrom(1, inputs, recipientKey).
 		build()
 }
 
 func (c *HtlcCovenant) makeRefundTx(
 	txid []byte, vout uint32, inAmt int64, // input info
+	senderKey *bchec.PrivateKey,
+	inputs []InputInfo,
 	minerFee int64,
 ) (*wire.MsgTx, error) {
 
@@ -185,11 +226,15 @@ func (c *HtlcCovenant) makeRefundTx(
 		return nil, err
 	}
 
+	builder := newMsgTxBuilder().
+		addInput(txid, vout, seq, sigScript)
+	extraAmt := addP2PKHInputs(builder, inputs)
+
 	// no penalty
 	if c.penaltyBPS == 0 {
-		return newMsgTxBuilder().
-			addInput(txid, vout, seq, sigScript).
-			addOutput(senderAddr, inAmt-minerFee).
+		return builder.
+			addOutput(senderAddr, inAmt+extraAmt-minerFee).
+			signP2PKHInputsFrom(1, inputs, senderKey).
 			build()
 	}
 
@@ -205,84 +250,51 @@ func (c *HtlcCovenant) makeRefundTx(
 		penaltyVal = 546
 	}
 
-	return newMsgTxBuilder().
-		addInput(txid, vout, seq, sigScript).
-		addOutput(senderAddr, inAmt-penaltyVal-minerFee).
+	return builder.
+		addOutput(senderAddr, inAmt+extraAmt-penaltyVal-minerFee).
 		addOutput(recipientAddr, penaltyVal).
+		signP2PKHInputsFrom(1, inputs, senderKey).
 		build()
 }
 
-func (c *HtlcCovenant) MakeLockTx(
-	fromKey *bchec.PrivateKey,
-	inputs []InputInfo, // inputs info
-	outAmt int64, // output info
-	minerFeeRate uint64,
-) (*wire.MsgTx, error) {
-	// estimate miner fee
-	tx, err := c.makeLockTx(fromKey, inputs, outAmt, 1000)
-	if err != nil {
-		return nil, err
+// adds P2PKH inputs after the HTLC input, returns their total value
+func addP2PKHInputs(builder *msgTxBuilder, inputs []InputInfo) int64 {
+	var totalAmt int64
+	for _, input := range inputs {
+		builder.addInput(input.TxID, input.Vout, 0, nil)
+		totalAmt += input.Amount
 	}
-	// make tx
-	minerFee := int64(len(MsgTxToBytes(tx))) * int64(minerFeeRate)
-	return c.makeLockTx(fromKey, inputs, outAmt, minerFee)
+	return totalAmt
 }
 
-func (c *HtlcCovenant) makeLockTx(
-	fromKey *bchec.PrivateKey,
-	inputs []InputInfo, // inputs info
-	outAmt int64, // output info
-	minerFee int64,
-) (*wire.MsgTx, error) {
-	fromPk := fromKey.PubKey().SerializeCompressed()
-	fromPkh := bchutil.Hash160(fromPk)
-
-	script, err := c.BuildFullRedeemScript()
-	if err != nil {
-		return nil, fmt.Errorf("failed to build full redeem script: %d", err)
+// P2PKH inputs merged into an unlock/refund tx must be owned by the receiver of output#0
+func checkInputsOwner(key *bchec.PrivateKey, inputs []InputInfo, ownerPkh []byte) error {
+	if len(inputs) == 0 {
+		return nil
 	}
-
-	toAddr, err := bchutil.NewAddressScriptHash(script, c.net)
-	if err != nil {
-		return nil, fmt.Errorf("failed to calc p2sh address: %d", err)
+	if key == nil {
+		return fmt.Errorf("no key to sign P2PKH inputs")
 	}
-
-	changeAddr, err := bchutil.NewAddressPubKeyHash(fromPkh, c.net)
-	if err != nil {
-		return nil, fmt.Errorf("failed to calc p2pkh address: %w", err)
-	}
-
-	prevPkScript, err := payToPubKeyHashPkScript(fromPkh)
-	if err != nil {
-		return nil, fmt.Errorf("failed to creatte pkScript: %w", err)
-	}
-
-	opRetScript, err := c.BuildOpRetPkScript(make([]byte, 20), 1e8)
-	if err != nil {
-		return nil, fmt.Errorf("failed to build OP_RETURN: %w", err)
+	pkh := bchutil.Hash160(key.PubKey().SerializeCompressed())
+	if !bytes.Equal(pkh, ownerPkh) {
+		return fmt.Errorf("P2PKH inputs are not owned by %s", hex.EncodeToString(ownerPkh))
 	}
+	return nil
+}
 
-	sigScriptFn := func(sig []byte) ([]byte, error) {
-		return payToPubKeyHashSigScript(sig, fromPk)
-	}
+func (c *HtlcCovenant) MakeLockTx(
+	fromKey *bchec.PrivateKey,
+	inputs []InputInfo, // inputs info
+	outAmt int64, // output info
+	minerFeeRate uint64,
+) (*wire.MsgTx, error) {
+	return makeBatchLockTx(fromKey, inputs, []lockOutput{{Covenant: c, Amount: outAmt}}, minerFeeRate)
+}
 
-	builder := newMsgTxBuilder()
-	var totalInAmt int64
-	for _, input := range inputs {
-		builder.addInput(input.TxID, input.Vout, 0, nil)
-		totalInAmt += input.Amount
-	}
-	changeAmt := totalInAmt - outAmt - minerFee
-	if changeAmt < 0 {
-		return nil, fmt.Errorf("insufficient input value: %d < %d", totalInAmt, outAmt+minerFee)
-	}
-	builder.addOutput(toAddr, outAmt)
-	builder.addOpRet(opRetScript)
-	builder.addChange(changeAddr, changeAmt)
-	for i, utxo := range inputs {
-		builder.sign(i, utxo.Amount, prevPkScript, fromKey, sigScriptFn)
-	}
-	return builder.build()
+// GetLockTxBaseSize returns the size of lock tx without inputs and change output,
+// it is used to estimate miner fee before UTXOs are selected
+func (c *HtlcCovenant) GetLockTxBaseSize() (int64, error) {
+	return getBatchLockTxBaseSize([]*HtlcCovenant{c})
 }
 
 func (c *HtlcCovenant) BuildFullRedeemScript() ([]byte, error) {
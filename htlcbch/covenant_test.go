@@ -9,6 +9,8 @@ import (
 
 	gethcmn "github.com/ethereum/go-ethereum/common"
 	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchd/wire"
 	"github.com/gcash/bchutil"
 )
 
@@ -145,6 +147,151 @@ func TestMakeRefundTx(t *testing.T) {
 	//require.Equal(t, "?", MsgTxToHex(tx))
 }
 
+// the covenant checks this.activeInputIndex == 0, so HTLC UTXOs can not be spent together by one tx
+func TestMakeUnlockTx_onlyInput0(t *testing.T) {
+	var txs []*wire.MsgTx
+	utxoCache := txscript.NewUtxoCache()
+	for i, secret := range [][]byte{testSecretKey, gethcmn.Hash{'4', '5', '6'}.Bytes()} {
+		c, err := NewCovenant(
+			testSenderPkh,
+			testRecipientPkh,
+			gethcmn.Hash(sha256.Sum256(secret)).Bytes(),
+			testExpiration,
+			testPenaltyBPS,
+			&chaincfg.TestNet3Params,
+		)
+		require.NoError(t, err)
+		redeemScript, err := c.BuildFullRedeemScript()
+		require.NoError(t, err)
+		p2shAddr, err := bchutil.NewAddressScriptHash(redeemScript, c.net)
+		require.NoError(t, err)
+		pkScript, err := txscript.PayToAddrScript(p2shAddr)
+		require.NoError(t, err)
+		utxoCache.AddEntry(i, wire.TxOut{Value: 100000, PkScript: pkScript})
+
+		tx, err := c.MakeUnlockTx(gethcmn.Hash{'u', 't', 'x', 'o'}.Bytes(), uint32(i), 100000, 2, secret)
+		require.NoError(t, err)
+		txs = append(txs, tx)
+	}
+
+	execute := func(tx *wire.MsgTx, idx int) error {
+		utxo, err := utxoCache.GetEntry(idx)
+		require.NoError(t, err)
+		vm, err := txscript.NewEngine(utxo.PkScript, tx, idx,
+			txscript.StandardVerifyFlags, nil, nil, utxoCache, utxo.Value)
+		require.NoError(t, err)
+		return vm.Execute()
+	}
+
+	// one HTLC input
+	require.NoError(t, execute(txs[0], 0))
+
+	// two HTLC inputs
+	tx := txs[0].Copy()
+	tx.AddTxIn(txs[1].TxIn[0])
+	tx.AddTxOut(txs[1].TxOut[0])
+	require.NoError(t, execute(tx, 0))
+	require.ErrorContains(t, execute(tx, 1), "OP_NUMEQUALVERIFY failed") // OP_INPUTINDEX 0 OP_NUMEQUALVERIFY
+}
+
+func TestMakeUnlockTxWithInputs(t *testing.T) {
+	c, err := NewCovenant(
+		testSenderPkh,
+		testRecipientPkh,
+		testSecretHash,
+		testExpiration,
+		testPenaltyBPS,
+		&chaincfg.TestNet3Params,
+	)
+	require.NoError(t, err)
+
+	inputs := []InputInfo{
+		{TxID: gethcmn.Hash{'p', '2', 'p', 'k', 'h'}.Bytes(), Vout: 0, Amount: 20000},
+		{TxID: gethcmn.Hash{'p', '2', 'p', 'k', 'h'}.Bytes(), Vout: 1, Amount: 30000},
+	}
+	_, err = c.MakeUnlockTxWithInputs(gethcmn.Hash{'u', 't', 'x', 'o'}.Bytes(), 1, 100000, 2,
+		testSecretKey, testSenderWIF.PrivKey, inputs)
+	require.ErrorContains(t, err, "P2PKH inputs are not owned by")
+
+	tx, err := c.MakeUnlockTxWithInputs(gethcmn.Hash{'u', 't', 'x', 'o'}.Bytes(), 1, 100000, 2,
+		testSecretKey, testRecipientWIF.PrivKey, inputs)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 3)
+	require.Len(t, tx.TxOut, 1)
+	minerFee := int64(len(MsgTxToBytes(tx))) * 2
+	require.Equal(t, 100000+20000+30000-minerFee, tx.TxOut[0].Value)
+
+	htlcPkScript := getHtlcPkScript(t, c)
+	p2pkhPkScript, err := payToPubKeyHashPkScript(testRecipientPkh)
+	require.NoError(t, err)
+	utxoCache := txscript.NewUtxoCache()
+	utxoCache.AddEntry(0, wire.TxOut{Value: 100000, PkScript: htlcPkScript})
+	utxoCache.AddEntry(1, wire.TxOut{Value: 20000, PkScript: p2pkhPkScript})
+	utxoCache.AddEntry(2, wire.TxOut{Value: 30000, PkScript: p2pkhPkScript})
+	for i := range tx.TxIn {
+		require.NoError(t, executeInput(t, tx, i, utxoCache))
+	}
+}
+
+func TestMakeRefundTxWithInputs(t *testing.T) {
+	c, err := NewCovenant(
+		testSenderPkh,
+		testRecipientPkh,
+		testSecretHash,
+		testExpiration,
+		testPenaltyBPS,
+		&chaincfg.TestNet3Params,
+	)
+	require.NoError(t, err)
+
+	inputs := []InputInfo{
+		{TxID: gethcmn.Hash{'p', '2', 'p', 'k', 'h'}.Bytes(), Vout: 0, Amount: 20000},
+	}
+	_, err = c.MakeRefundTxWithInputs(gethcmn.Hash{'u', 't', 'x', 'o'}.Bytes(), 1, 100000, 3,
+		nil, inputs)
+	require.ErrorContains(t, err, "no key to sign P2PKH inputs")
+
+	tx, err := c.MakeRefundTxWithInputs(gethcmn.Hash{'u', 't', 'x', 'o'}.Bytes(), 1, 100000, 3,
+		testSenderWIF.PrivKey, inputs)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 2)
+	require.Equal(t, uint32(testExpiration), tx.TxIn[0].Sequence)
+	require.Equal(t, uint32(0xffffffff), tx.TxIn[1].Sequence)
+	require.Len(t, tx.TxOut, 2)
+	minerFee := int64(len(MsgTxToBytes(tx))) * 3
+	require.Equal(t, int64(100000*testPenaltyBPS/10000), tx.TxOut[1].Value)
+	require.Equal(t, 100000+20000-tx.TxOut[1].Value-minerFee, tx.TxOut[0].Value)
+
+	htlcPkScript := getHtlcPkScript(t, c)
+	p2pkhPkScript, err := payToPubKeyHashPkScript(testSenderPkh)
+	require.NoError(t, err)
+	utxoCache := txscript.NewUtxoCache()
+	utxoCache.AddEntry(0, wire.TxOut{Value: 100000, PkScript: htlcPkScript})
+	utxoCache.AddEntry(1, wire.TxOut{Value: 20000, PkScript: p2pkhPkScript})
+	for i := range tx.TxIn {
+		require.NoError(t, executeInput(t, tx, i, utxoCache))
+	}
+}
+
+func getHtlcPkScript(t *testing.T, c *HtlcCovenant) []byte {
+	redeemScript, err := c.BuildFullRedeemScript()
+	require.NoError(t, err)
+	p2shAddr, err := bchutil.NewAddressScriptHash(redeemScript, c.net)
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToAddrScript(p2shAddr)
+	require.NoError(t, err)
+	return pkScript
+}
+
+func executeInput(t *testing.T, tx *wire.MsgTx, idx int, utxoCache *txscript.UtxoCache) error {
+	utxo, err := utxoCache.GetEntry(idx)
+	require.NoError(t, err)
+	vm, err := txscript.NewEngine(utxo.PkScript, tx, idx,
+		txscript.StandardVerifyFlags, nil, nil, utxoCache, utxo.Value)
+	require.NoError(t, err)
+	return vm.Execute()
+}
+
 func TestMakeLockTx(t *testing.T) {
 	c, err := NewCovenant(
 		testSenderPkh,
@@ -171,3 +318,43 @@ func TestMakeLockTx(t *testing.T) {
 	require.Len(t, MsgTxToBytes(tx), 350)
 	//require.Equal(t, "?", MsgTxToHex(tx))
 }
+
+func TestGetLockTxBaseSize(t *testing.T) {
+	c, err := NewCovenant(
+		testSenderPkh,
+		testRecipientPkh,
+		testSecretHash,
+		testExpiration,
+		testPenaltyBPS,
+		&chaincfg.TestNet3Params,
+	)
+	require.NoError(t, err)
+
+	baseSize, err := c.GetLockTxBaseSize()
+	require.NoError(t, err)
+	require.Equal(t, int64(168), baseSize)
+
+	for n := 1; n <= 3; n++ {
+		var inputs []InputInfo
+		for i := 0; i < n; i++ {
+			inputs = append(inputs, InputInfo{
+				TxID:   gethcmn.Hash{'t', 'x', 'i', 'd', byte(i)}.Bytes(),
+				Vout:   uint32(i),
+				Amount: int64(20000),
+			})
+		}
+
+		// with change
+		tx, err := c.MakeLockTx(testSenderWIF.PrivKey, inputs, 10000, 2)
+		require.NoError(t, err)
+		require.Len(t, tx.TxOut, 3)
+		require.LessOrEqual(t, len(MsgTxToBytes(tx)), int(baseSize)+n*P2PKHInputSize+P2PKHOutputSize)
+		require.Greater(t, len(MsgTxToBytes(tx)), int(baseSize)+n*(P2PKHInputSize-3)+P2PKHOutputSize)
+
+		// without change
+		tx, err = c.MakeLockTx(testSenderWIF.PrivKey, inputs, int64(n)*(20000-400)-500, 2)
+		require.NoError(t, err)
+		require.Len(t, tx.TxOut, 2)
+		require.LessOrEqual(t, len(MsgTxToBytes(tx)), int(baseSize)+n*P2PKHInputSize)
+	}
+}
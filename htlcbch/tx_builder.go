@@ -2,6 +2,7 @@ package htlcbch
 
 import (
 	"encoding/hex"
+	"fmt"
 
 	"github.com/gcash/bchd/bchec"
 	"github.com/gcash/bchd/chaincfg/chainhash"
@@ -11,7 +12,10 @@ import (
 )
 
 const (
-	dustAmt = 546
+	DustAmt = 546 // change output is dropped if its value is not larger than this
+
+	P2PKHInputSize  = 149 // max size of signed P2PKH input (ECDSA signature, compressed pubkey)
+	P2PKHOutputSize = 34
 )
 
 type msgTxBuilder struct {
@@ -72,7 +76,7 @@ func (builder *msgTxBuilder) addOpRet(pkScript []byte) *msgTxBuilder {
 }
 
 func (builder *msgTxBuilder) addChange(toAddr bchutil.Address, changeAmt int64) *msgTxBuilder {
-	if changeAmt > dustAmt {
+	if changeAmt > DustAmt {
 		return builder.addOutput(toAddr, changeAmt)
 	}
 	return builder
@@ -107,6 +111,33 @@ func (builder *msgTxBuilder) sign(
 	return builder
 }
 
+// all inputs must be P2PKH UTXOs owned by privKey
+func (builder *msgTxBuilder) signP2PKHInputs(inputs []InputInfo, privKey *bchec.PrivateKey) *msgTxBuilder {
+	return builder.signP2PKHInputsFrom(0, inputs, privKey)
+}
+
+// inputs[i] is input#(firstIdx+i) of the tx
+func (builder *msgTxBuilder) signP2PKHInputsFrom(firstIdx int, inputs []InputInfo, privKey *bchec.PrivateKey) *msgTxBuilder {
+	if builder.err != nil || len(inputs) == 0 {
+		return builder
+	}
+
+	pk := privKey.PubKey().SerializeCompressed()
+	prevPkScript, err := payToPubKeyHashPkScript(bchutil.Hash160(pk))
+	if err != nil {
+		builder.err = fmt.Errorf("failed to create pkScript: %w", err)
+		return builder
+	}
+
+	sigScriptFn := func(sig []byte) ([]byte, error) {
+		return payToPubKeyHashSigScript(sig, pk)
+	}
+	for i, input := range inputs {
+		builder.sign(firstIdx+i, input.Amount, prevPkScript, privKey, sigScriptFn)
+	}
+	return builder
+}
+
 func (builder *msgTxBuilder) build() (*wire.MsgTx, error) {
 	return builder.msgTx, builder.err
 }
@@ -18,6 +18,7 @@ const (
 type HtlcLockInfo struct {
 	//BlockNum      uint64
 	TxHash        string        // 32 bytes, hex
+	Vout          uint32        // index of the P2SH output
 	RecipientPkh  hexutil.Bytes // 20 bytes
 	SenderPkh     hexutil.Bytes // 20 bytes
 	HashLock      hexutil.Bytes // 32 bytes, sha256
@@ -31,18 +32,23 @@ type HtlcLockInfo struct {
 
 type HtlcUnlockInfo struct {
 	PrevTxHash string // 32 bytes, hex
+	PrevVout   uint32
 	TxHash     string // 32 bytes, hex
 	Secret     string // 32 bytes, hex
 }
 
+type HtlcRefundInfo struct {
+	PrevTxHash   string // 32 bytes, hex
+	PrevVout     uint32
+	TxHash       string // 32 bytes, hex
+	PenaltyValue uint64 // in sats, paid to recipient, 0 if no penalty
+}
+
 // === Lock ===
 
 func GetHtlcLocksInfo(block *btcjson.GetBlockVerboseTxResult) (deposits []*HtlcLockInfo) {
 	for _, tx := range block.Tx {
-		depositInfo := isHtlcLockTx(tx)
-		if depositInfo != nil {
-			deposits = append(deposits, depositInfo)
-		}
+		deposits = append(deposits, isHtlcLockTx(tx)...)
 	}
 	return
 }
@@ -55,20 +61,32 @@ func decodeHex(s string) []byte {
 	return bz
 }
 
-// output#0: deposit, output#1: op_return
-func isHtlcLockTx(tx btcjson.TxRawResult) *HtlcLockInfo {
-	if len(tx.Vout) < 2 {
-		return nil
+// ParseHtlcLockTx returns nil if tx is not an HTLC lock tx, it can be used to parse unconfirmed txs
+func ParseHtlcLockTx(tx btcjson.TxRawResult) []*HtlcLockInfo {
+	return isHtlcLockTx(tx)
+}
+
+// output#i: deposit, output#i+1: op_return, a tx may lock coins into several HTLCs
+func isHtlcLockTx(tx btcjson.TxRawResult) (deposits []*HtlcLockInfo) {
+	for i := 0; i+1 < len(tx.Vout); i++ {
+		depositInfo := getHtlcLockOutputInfo(tx, i)
+		if depositInfo != nil {
+			deposits = append(deposits, depositInfo)
+			i++ // skip op_return
+		}
 	}
+	return
+}
 
-	// output#0 must be locked by P2SH script
-	scriptHash := getP2SHash(decodeHex(tx.Vout[0].ScriptPubKey.Hex))
+func getHtlcLockOutputInfo(tx btcjson.TxRawResult, i int) *HtlcLockInfo {
+	// output#i must be locked by P2SH script
+	scriptHash := getP2SHash(decodeHex(tx.Vout[i].ScriptPubKey.Hex))
 	if scriptHash == nil {
 		return nil
 	}
 
-	// output#1 must be NULL DATA that contains the HTLC info
-	depositInfo := getHtlcLockInfo(decodeHex(tx.Vout[1].ScriptPubKey.Hex))
+	// output#i+1 must be NULL DATA that contains the HTLC info
+	depositInfo := getHtlcLockInfo(decodeHex(tx.Vout[i+1].ScriptPubKey.Hex))
 	if depositInfo == nil {
 		return nil
 	}
@@ -88,8 +106,9 @@ func isHtlcLockTx(tx btcjson.TxRawResult) *HtlcLockInfo {
 	}
 
 	depositInfo.TxHash = tx.Txid
+	depositInfo.Vout = uint32(i)
 	depositInfo.ScriptHash = scriptHash
-	depositInfo.Value = utxoAmtToSats(tx.Vout[0].Value)
+	depositInfo.Value = utxoAmtToSats(tx.Vout[i].Value)
 	return depositInfo
 }
 
@@ -142,28 +161,33 @@ func getP2SHash(pkScript []byte) (scriptHash []byte) {
 
 func GetHtlcUnlocksInfo(block *btcjson.GetBlockVerboseTxResult) (receipts []*HtlcUnlockInfo) {
 	for _, tx := range block.Tx {
-		receiptInfo := isHtlcUnlockTx(tx)
-		if receiptInfo != nil {
-			receipts = append(receipts, receiptInfo)
-		}
+		receipts = append(receipts, isHtlcUnlockTx(tx)...)
 	}
 	return
 }
 
-func isHtlcUnlockTx(tx btcjson.TxRawResult) *HtlcUnlockInfo {
-	if len(tx.Vin) != 1 {
-		return nil
-	}
-	if tx.Vin[0].ScriptSig == nil {
-		return nil
-	}
-	sigScript := decodeHex(tx.Vin[0].ScriptSig.Hex)
-	receiptInfo := getHtlcUnlockInfo(sigScript)
-	if receiptInfo != nil {
-		receiptInfo.PrevTxHash = tx.Vin[0].Txid
-		receiptInfo.TxHash = tx.Txid
+// ParseHtlcUnlockTx returns nil if tx is not an HTLC unlock tx, it can be used to parse unconfirmed txs
+func ParseHtlcUnlockTx(tx btcjson.TxRawResult) []*HtlcUnlockInfo {
+	return isHtlcUnlockTx(tx)
+}
+
+// every input is checked, so secrets are found even if other inputs (e.g. P2PKH inputs added by wallets) are spent together,
+// NOTE: the covenant only accepts HTLC input#0, so a valid tx has at most one receipt
+func isHtlcUnlockTx(tx btcjson.TxRawResult) (receipts []*HtlcUnlockInfo) {
+	for _, vin := range tx.Vin {
+		if vin.ScriptSig == nil {
+			continue
+		}
+		sigScript := decodeHex(vin.ScriptSig.Hex)
+		receiptInfo := getHtlcUnlockInfo(sigScript)
+		if receiptInfo != nil {
+			receiptInfo.PrevTxHash = vin.Txid
+			receiptInfo.PrevVout = vin.Vout
+			receiptInfo.TxHash = tx.Txid
+			receipts = append(receipts, receiptInfo)
+		}
 	}
-	return receiptInfo
+	return
 }
 
 func getHtlcUnlockInfo(sigScript []byte) *HtlcUnlockInfo {
@@ -186,6 +210,81 @@ func getHtlcUnlockInfo(sigScript []byte) *HtlcUnlockInfo {
 	}
 }
 
+// === Refund ===
+
+func GetHtlcRefundsInfo(block *btcjson.GetBlockVerboseTxResult) (refunds []*HtlcRefundInfo) {
+	for _, tx := range block.Tx {
+		refundInfo := isHtlcRefundTx(tx)
+		if refundInfo != nil {
+			refunds = append(refunds, refundInfo)
+		}
+	}
+	return
+}
+
+// output#0: refund to sender, output#1: penalty to recipient (optional), other outputs (e.g. change) are not checked.
+// every input is checked like isHtlcUnlockTx, so refunds with other inputs (e.g. P2PKH inputs paying miner fee) are found
+func isHtlcRefundTx(tx btcjson.TxRawResult) *HtlcRefundInfo {
+	if len(tx.Vout) < 1 {
+		return nil
+	}
+	for _, vin := range tx.Vin {
+		if vin.ScriptSig == nil {
+			continue
+		}
+		sigScript := decodeHex(vin.ScriptSig.Hex)
+		if !isHtlcRefundSigScript(sigScript) {
+			continue
+		}
+
+		refundInfo := &HtlcRefundInfo{
+			PrevTxHash: vin.Txid,
+			PrevVout:   vin.Vout,
+			TxHash:     tx.Txid,
+		}
+		if len(tx.Vout) >= 2 && isHtlcPenaltyOutput(sigScript, tx.Vout[1]) {
+			refundInfo.PenaltyValue = utxoAmtToSats(tx.Vout[1].Value)
+		}
+		return refundInfo
+	}
+	return nil
+}
+
+// the penalty output pays to the recipient pkh of the covenant,
+// <penalty bps> <expiration> <hash lock> <recipient pkh> <sender pkh> are pushed before the covenant code
+func isHtlcPenaltyOutput(refundSigScript []byte, vout btcjson.Vout) bool {
+	pushes, err := txscript.PushedData(refundSigScript)
+	if err != nil || len(pushes) != 1 {
+		return false
+	}
+	redeemScript := pushes[0]
+	args, err := txscript.PushedData(redeemScript[:len(redeemScript)-len(redeemScriptWithoutConstructorArgs)])
+	if err != nil || len(args) < 2 {
+		return false
+	}
+	pkScript, err := payToPubKeyHashPkScript(args[len(args)-2])
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(pkScript, decodeHex(vout.ScriptPubKey.Hex))
+}
+
+// OP_1 (selector) <redeem script>
+func isHtlcRefundSigScript(sigScript []byte) bool {
+	if len(sigScript) == 0 || sigScript[0] != txscript.OP_1 {
+		return false
+	}
+	if !bytes.HasSuffix(sigScript, redeemScriptWithoutConstructorArgs) {
+		return false
+	}
+	// OP_1 is not counted as pushed data
+	pushes, err := txscript.PushedData(sigScript)
+	if err != nil {
+		return false
+	}
+	return len(pushes) == 1
+}
+
 // utils
 
 func utxoAmtToSats(amt float64) uint64 {
@@ -1,6 +1,7 @@
 package htlcbch
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"testing"
@@ -9,7 +10,10 @@ import (
 
 	gethcmn "github.com/ethereum/go-ethereum/common"
 	"github.com/gcash/bchd/btcjson"
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/chaincfg/chainhash"
 	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchd/wire"
 )
 
 func TestIsP2SH(t *testing.T) {
@@ -138,9 +142,11 @@ func TestIsHtlcLockTx(t *testing.T) {
 	require.NoError(t, json.Unmarshal([]byte(txJSON), &tx))
 
 	//recipientPkh := gethcmn.FromHex("0x104f3f29055f1b2b6debeb6e69a6f0d534f01585")
-	result := isHtlcLockTx(tx)
-	require.NotNil(t, result)
+	results := isHtlcLockTx(tx)
+	require.Len(t, results, 1)
+	result := results[0]
 	require.Equal(t, "7e6343c8ccdc0ef7504931fb80b61414c1eee4bab287879cbf1f3deb63222b4f", result.TxHash)
+	require.Equal(t, uint32(0), result.Vout)
 	require.Equal(t, "92a9a3f7f0bbd5b6a66b95db86957de6277bc491", hex.EncodeToString(result.RecipientPkh))
 	require.Equal(t, "8b79ea99e6c418776a9c9d2c5dc074b4404c8a57", hex.EncodeToString(result.SenderPkh))
 	require.Equal(t, "ed88bb4d5991f2f91939d37277c0f988bbf461c889cafbdd5384ecb881ce6bf3", hex.EncodeToString(result.HashLock))
@@ -151,6 +157,43 @@ func TestIsHtlcLockTx(t *testing.T) {
 	require.Equal(t, uint64(1e8), result.ExpectedPrice)
 }
 
+func TestIsHtlcLockTx_multiOutputs(t *testing.T) {
+	var covenants []*HtlcCovenant
+	var outputs []lockOutput
+	for i := 0; i < 3; i++ {
+		c, err := NewMainnetCovenant(testSenderPkh, testRecipientPkh,
+			gethcmn.Hash{'h', 'a', 's', 'h', byte(i)}.Bytes(), testExpiration, testPenaltyBPS)
+		require.NoError(t, err)
+		covenants = append(covenants, c)
+		outputs = append(outputs, lockOutput{Covenant: c, Amount: int64(10000 * (i + 1))})
+	}
+	inputs := []InputInfo{{TxID: gethcmn.Hash{'t', 'x', 'i', 'd'}.Bytes(), Vout: 1, Amount: 100000}}
+	msgTx, err := makeBatchLockTx(testSenderWIF.PrivKey, inputs, outputs, 1)
+	require.NoError(t, err)
+	require.Len(t, msgTx.TxOut, 7)
+
+	// change, HTLC#0, HTLC#1, P2SH without OP_RETURN, HTLC#2
+	rawTx := msgTxToRawResult(msgTx)
+	tx := btcjson.TxRawResult{Txid: rawTx.Txid}
+	tx.Vout = append(tx.Vout, rawTx.Vout[6], rawTx.Vout[0], rawTx.Vout[1], rawTx.Vout[2], rawTx.Vout[3])
+	tx.Vout = append(tx.Vout, rawTx.Vout[4], rawTx.Vout[4], rawTx.Vout[5])
+
+	deposits := ParseHtlcLockTx(tx)
+	require.Len(t, deposits, 3)
+	for i, deposit := range deposits {
+		scriptHash, err := covenants[i].GetRedeemScriptHash()
+		require.NoError(t, err)
+		require.Equal(t, tx.Txid, deposit.TxHash)
+		require.Equal(t, []uint32{1, 3, 6}[i], deposit.Vout)
+		require.Equal(t, scriptHash, []byte(deposit.ScriptHash))
+		require.Equal(t, uint64(outputs[i].Amount), deposit.Value)
+	}
+
+	// OP_RETURN without P2SH
+	tx.Vout = tx.Vout[2:]
+	require.Len(t, ParseHtlcLockTx(tx), 2)
+}
+
 func TestGetHtlcUnlockInfo(t *testing.T) {
 	sigScript := gethcmn.FromHex("203132330000000000000000000000000000000000000000000000000000000000004cd102f401012420ed88bb4d5991f2f91939d37277c0f988bbf461c889cafbdd5384ecb881ce6bf31492a9a3f7f0bbd5b6a66b95db86957de6277bc491148b79ea99e6c418776a9c9d2c5dc074b4404c8a575579009c63c0009d567aa8537a880376a9147b7e0288ac7e00cd8800cc00c602d00794a2696d6d5167557a519dc0009d537ab27500c67600567900a06352795779950210279677527978947b757c0376a91455797e0288ac7e51cd788851cc5279a26975680376a914547a7e0288ac7e00cd8800cc7b02d00794a2696d6d755168")
 	receiptInfo := getHtlcUnlockInfo(sigScript)
@@ -200,9 +243,150 @@ func TestIsUnlockTx(t *testing.T) {
 	var tx btcjson.TxRawResult
 	require.NoError(t, json.Unmarshal([]byte(txJSON), &tx))
 
-	result := isHtlcUnlockTx(tx)
-	require.NotNil(t, result)
+	results := isHtlcUnlockTx(tx)
+	require.Len(t, results, 1)
+	result := results[0]
 	require.Equal(t, "44ce4fce907ecbc8d5070ac38aeb32df85c8cdb0aea07f592cae4c4553f828bc", result.PrevTxHash)
 	require.Equal(t, "c748992bb1d40087c6976099e70c4fbf7124ab17359e5337baeb8e96589db15f", result.TxHash)
 	require.Equal(t, "3132330000000000000000000000000000000000000000000000000000000000", result.Secret)
 }
+
+func TestIsUnlockTx_multiInputs(t *testing.T) {
+	secrets := [][]byte{testSecretKey, gethcmn.Hash{'4', '5', '6'}.Bytes()}
+	var txs []*wire.MsgTx
+	for i, secret := range secrets {
+		c, err := NewCovenant(
+			testSenderPkh,
+			testRecipientPkh,
+			gethcmn.Hash(sha256.Sum256(secret)).Bytes(),
+			testExpiration,
+			testPenaltyBPS,
+			&chaincfg.TestNet3Params,
+		)
+		require.NoError(t, err)
+		tx, err := c.MakeUnlockTx(gethcmn.Hash{'u', 't', 'x', 'o'}.Bytes(), uint32(i*2), 100000000, 2, secret)
+		require.NoError(t, err)
+		txs = append(txs, tx)
+	}
+
+	// HTLC input, P2PKH input, HTLC input
+	tx := txs[0].Copy()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{'f', 'e', 'e'}, 1), []byte{0x01, 0x02}))
+	tx.AddTxIn(txs[1].TxIn[0])
+
+	receipts := isHtlcUnlockTx(msgTxToRawResult(tx))
+	require.Len(t, receipts, 2)
+	for i, receipt := range receipts {
+		require.Equal(t, tx.TxHash().String(), receipt.TxHash)
+		require.Equal(t, txs[i].TxIn[0].PreviousOutPoint.Hash.String(), receipt.PrevTxHash)
+		require.Equal(t, uint32(i*2), receipt.PrevVout)
+		require.Equal(t, hex.EncodeToString(secrets[i]), receipt.Secret)
+	}
+}
+
+func TestIsRefundTx(t *testing.T) {
+	for _, penaltyBPS := range []uint16{0, testPenaltyBPS} {
+		c, err := NewCovenant(
+			testSenderPkh,
+			testRecipientPkh,
+			testSecretHash,
+			testExpiration,
+			penaltyBPS,
+			&chaincfg.TestNet3Params,
+		)
+		require.NoError(t, err)
+		prevTxHash := gethcmn.Hash{'u', 't', 'x', 'o'}
+		tx, err := c.MakeRefundTx(prevTxHash.Bytes(), 1, 100000000, 3)
+		require.NoError(t, err)
+
+		result := isHtlcRefundTx(msgTxToRawResult(tx))
+		require.NotNil(t, result)
+		require.Equal(t, tx.TxIn[0].PreviousOutPoint.Hash.String(), result.PrevTxHash)
+		require.Equal(t, uint32(1), result.PrevVout)
+		require.Equal(t, tx.TxHash().String(), result.TxHash)
+		if penaltyBPS == 0 {
+			require.Equal(t, uint64(0), result.PenaltyValue)
+		} else {
+			require.Equal(t, uint64(5000000), result.PenaltyValue)
+		}
+	}
+}
+
+func TestIsRefundTx_feeInput(t *testing.T) {
+	for _, penaltyBPS := range []uint16{0, testPenaltyBPS} {
+		c, err := NewCovenant(
+			testSenderPkh,
+			testRecipientPkh,
+			testSecretHash,
+			testExpiration,
+			penaltyBPS,
+			&chaincfg.TestNet3Params,
+		)
+		require.NoError(t, err)
+		tx, err := c.MakeRefundTx(gethcmn.Hash{'u', 't', 'x', 'o'}.Bytes(), 1, 100000000, 3)
+		require.NoError(t, err)
+
+		// HTLC input, P2PKH input paying miner fee, the change goes back to the fee payer
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{'f', 'e', 'e'}, 0), []byte{0x01, 0x02}))
+		changePkScript, err := payToPubKeyHashPkScript(testSenderPkh)
+		require.NoError(t, err)
+		tx.AddTxOut(wire.NewTxOut(12345, changePkScript))
+
+		rawTx := msgTxToRawResult(tx)
+		result := isHtlcRefundTx(rawTx)
+		require.NotNil(t, result)
+		require.Equal(t, tx.TxIn[0].PreviousOutPoint.Hash.String(), result.PrevTxHash)
+		require.Equal(t, uint32(1), result.PrevVout)
+		require.Equal(t, tx.TxHash().String(), result.TxHash)
+		if penaltyBPS == 0 {
+			require.Equal(t, uint64(0), result.PenaltyValue) // output#1 is the change
+		} else {
+			require.Equal(t, uint64(5000000), result.PenaltyValue)
+		}
+
+		block := &btcjson.GetBlockVerboseTxResult{Tx: []btcjson.TxRawResult{rawTx}}
+		require.Len(t, GetHtlcRefundsInfo(block), 1)
+	}
+}
+
+func TestIsRefundTx_unlockTx(t *testing.T) {
+	c, err := NewCovenant(
+		testSenderPkh,
+		testRecipientPkh,
+		testSecretHash,
+		testExpiration,
+		testPenaltyBPS,
+		&chaincfg.TestNet3Params,
+	)
+	require.NoError(t, err)
+	tx, err := c.MakeUnlockTx(gethcmn.Hash{'u', 't', 'x', 'o'}.Bytes(), 1, 100000000, 2, testSecretKey)
+	require.NoError(t, err)
+
+	rawTx := msgTxToRawResult(tx)
+	require.Nil(t, isHtlcRefundTx(rawTx))
+	require.Len(t, isHtlcUnlockTx(rawTx), 1)
+
+	block := &btcjson.GetBlockVerboseTxResult{Tx: []btcjson.TxRawResult{rawTx}}
+	require.Len(t, GetHtlcRefundsInfo(block), 0)
+	require.Len(t, GetHtlcUnlocksInfo(block), 1)
+}
+
+func msgTxToRawResult(tx *wire.MsgTx) btcjson.TxRawResult {
+	rawTx := btcjson.TxRawResult{Txid: tx.TxHash().String()}
+	for _, in := range tx.TxIn {
+		rawTx.Vin = append(rawTx.Vin, btcjson.Vin{
+			Txid:      in.PreviousOutPoint.Hash.String(),
+			Vout:      in.PreviousOutPoint.Index,
+			ScriptSig: &btcjson.ScriptSig{Hex: hex.EncodeToString(in.SignatureScript)},
+			Sequence:  in.Sequence,
+		})
+	}
+	for i, out := range tx.TxOut {
+		rawTx.Vout = append(rawTx.Vout, btcjson.Vout{
+			Value:        float64(out.Value) / 1e8,
+			N:            uint32(i),
+			ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(out.PkScript)},
+		})
+	}
+	return rawTx
+}
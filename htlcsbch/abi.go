@@ -712,6 +712,11 @@ func PackRefund(sender common.Address, hashLock common.Hash) ([]byte, error) {
 	return htlcAbi.Pack("refund", sender, hashLock)
 }
 
+func PackUpdateMarketMaker(intro [32]byte, bchPrice, sbchPrice *big.Int) ([]byte, error) {
+	// function updateMarketMaker(bytes32 _intro, uint256 _bchPrice, uint256 _sbchPrice) public
+	return htlcAbi.Pack("updateMarketMaker", intro, bchPrice, sbchPrice)
+}
+
 func PackGetSwapState(sender common.Address, hashLock common.Hash) ([]byte, error) {
 	// function getSwapState(address sender, bytes32 secretLock) public view returns (States)
 	return htlcAbi.Pack("getSwapState", sender, hashLock)
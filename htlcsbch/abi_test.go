@@ -22,6 +22,7 @@ func TestABI(t *testing.T) {
 	require.Equal(t, "37b6ee7e", hex.EncodeToString(htlcAbi.Methods["refund"].ID))
 	require.Equal(t, "b90a2883", hex.EncodeToString(htlcAbi.Methods["getSwapState"].ID))
 	require.Equal(t, "e670ce1f", hex.EncodeToString(htlcAbi.Methods["marketMakerByAddress"].ID))
+	require.Equal(t, "2fc3185f", hex.EncodeToString(htlcAbi.Methods["updateMarketMaker"].ID))
 }
 
 func TestPackLock(t *testing.T) {
@@ -67,6 +68,17 @@ func TestPackRefund(t *testing.T) {
 `, "\n", ""), hex.EncodeToString(data))
 }
 
+func TestPackUpdateMarketMaker(t *testing.T) {
+	intro := [32]byte{'b', 'o', 't', '1'}
+	data, err := PackUpdateMarketMaker(intro, big.NewInt(1100000000000000000), big.NewInt(900000000000000000))
+	require.NoError(t, err)
+	require.Equal(t, strings.ReplaceAll(`2fc3185f
+626f743100000000000000000000000000000000000000000000000000000000
+0000000000000000000000000000000000000000000000000f43fc2c04ee0000
+0000000000000000000000000000000000000000000000000c7d713b49da0000
+`, "\n", ""), hex.EncodeToString(data))
+}
+
 func TestPackGetSwapState(t *testing.T) {
 	sender := common.Address{'s', 'e', 'n', 'd', 'e', 'r'}
 	hashLock := common.Hash{'h', 'a', 's', 'h', 'l', 'o', 'c', 'k', 0xaa}